@@ -0,0 +1,1423 @@
+package main
+
+/*
+	## JSON API
+	Scripts and other clients can work with the same data as the HTML pages
+	through a JSON API. Resources are addressed by ID, errors come back as
+	{"error": "..."} with a matching status code, and new resources are
+	answered with 201 Created and a Location header.
+
+	Clients log in through /api/v1/session and send back the session cookie
+	it sets; every other endpoint answers 401 without one. Requests that
+	change anything also send the "csrf_token" the session endpoint answers
+	with in an X-CSRF-Token header, or get 403 (see csrf.go).
+
+	| method | endpoint                         | purpose                      |
+	| ------ | -------------------------------- | ---------------------------- |
+	| POST   | /api/v1/users                    | register a user              |
+	| POST   | /api/v1/session                  | log in                       |
+	| GET    | /api/v1/session                  | the logged in user           |
+	| DELETE | /api/v1/session                  | log out                      |
+	| GET    | /api/v1/users/{id}               | a user                       |
+	| PATCH  | /api/v1/users/{id}               | change email settings        |
+	| GET    | /api/v1/users/{id}/lists         | a user's (and shared) lists  |
+	| POST   | /api/v1/users/{id}/lists         | add a list                   |
+	| GET    | /api/v1/lists/{id}               | a list                       |
+	| PATCH  | /api/v1/lists/{id}               | rename a list                |
+	| DELETE | /api/v1/lists/{id}               | delete a list and tasks      |
+	| GET    | /api/v1/lists/{id}/tasks         | a list's tasks               |
+	| POST   | /api/v1/lists/{id}/tasks         | add a task                   |
+	| GET    | /api/v1/tasks/{id}               | a task                       |
+	| PATCH  | /api/v1/tasks/{id}               | change some of a task        |
+	| DELETE | /api/v1/tasks/{id}               | delete a task and steps      |
+	| GET    | /api/v1/tasks/{id}/subtasks      | a task's steps               |
+	| POST   | /api/v1/tasks/{id}/subtasks      | add a step to a task         |
+	| GET    | /api/v1/tags                     | the user's tags              |
+	| GET    | /api/v1/tags/{id}                | a tag                        |
+	| PATCH  | /api/v1/tags/{id}                | rename or recolour a tag     |
+	| DELETE | /api/v1/tags/{id}                | delete a tag                 |
+	| GET    | /api/v1/tags/{id}/tasks          | the tasks with a tag         |
+	| GET    | /api/v1/search?q=words           | search the user's tasks      |
+	| GET    | /api/v1/users/{id}/invites       | a user's open invitations    |
+	| GET    | /api/v1/users/{id}/export        | a user's lists, as a file    |
+	| POST   | /api/v1/users/{id}/import        | load lists from a file       |
+	| GET    | /api/v1/lists/{id}/members       | a list's members             |
+	| POST   | /api/v1/lists/{id}/members       | invite a user to a list      |
+	| GET    | /api/v1/members/{id}             | a membership                 |
+	| PATCH  | /api/v1/members/{id}             | accept, or change a role     |
+	| DELETE | /api/v1/members/{id}             | decline, leave or remove     |
+	| GET    | /api/v1/webhooks                 | the user's webhooks          |
+	| POST   | /api/v1/webhooks                 | add a webhook                |
+	| GET    | /api/v1/webhooks/{id}            | a webhook                    |
+	| PATCH  | /api/v1/webhooks/{id}            | change or turn off a webhook |
+	| DELETE | /api/v1/webhooks/{id}            | delete a webhook             |
+	| GET    | /api/v1/webhooks/{id}/deliveries | a webhook's deliveries       |
+
+	A list's tasks come back in their owner's order, soonest due first with
+	?sort=due, or highest priority first with ?sort=priority.
+
+	Tasks and lists come back in their owner's order. PATCHing a "position"
+	moves one to that index among its siblings (see order.go).
+
+	Tasks with a "parent_id" are steps of that task (see subtasks.go); a
+	list's tasks include them all.
+
+	A task's "tags" are the names of the user's tags on it (see tags.go);
+	setting them makes any tags that don't exist yet.
+
+	Search results (see search.go) come best first, each with its task and
+	the task's title, list title and a snippet of its details as HTML, with
+	the hits in <mark> tags.
+
+	Lists shared with the user (see share.go) come after their own lists.
+	Members are invited with a "role" and the user's "user_id" (or
+	"first_name" and "last_name"); invitees PATCH "accepted" to join.
+	Renaming, moving, deleting and sharing a list need the owner role,
+	and changing its tasks the editor role.
+
+	Exports are JSON, or CSV or todo.txt with ?format=csv or ?format=todotxt
+	(see export.go). Imports take
+	either, by ?format= or Content-Type, merging into the user's lists unless
+	?mode=replace, and answer with counts of what changed. With
+	?dry_run=true nothing changes; the counts are what would.
+
+	A task's "reminder" is emailed to its list's users at a time, or as long
+	before it's due as "30m", "2h", "1d" or "1w" (see remind.go). Users PATCH
+	their "email" address and whether they want "reminders".
+
+	Webhooks (see webhook.go) take a "url" and the "events" they want,
+	none meaning all; the reply holds the "secret" deliveries are signed
+	with.
+
+	Setting "completed" on a task with a "repeat" rule also adds its next
+	occurrence (see recur.go), which the reply links to with a
+	Link: </api/v1/tasks/{id}>; rel="next" header.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the API's root path
+const apiPrefix = "/api/v1/"
+
+type (
+	// apiUser is the JSON form of a User
+	apiUser struct {
+		ID        uint   `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Password  string `json:"password,omitempty"` // only ever read
+		Email     string `json:"email"`
+		Reminders bool   `json:"reminders"`            // false if turned off
+		CSRFToken string `json:"csrf_token,omitempty"` // only from /session
+	}
+
+	// apiList is the JSON form of a TaskList
+	apiList struct {
+		ID        uint      `json:"id"`
+		UserID    uint      `json:"user_id"`
+		Title     string    `json:"title"`
+		Position  int       `json:"position"` // see order.go
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	// apiTask is the JSON form of a Task
+	apiTask struct {
+		ID           uint      `json:"id"`
+		TaskListID   uint      `json:"list_id"`
+		Title        string    `json:"title"`
+		Details      string    `json:"details"`
+		DueDate      string    `json:"due_date"` // see due.go
+		Overdue      bool      `json:"overdue"`  // only ever written
+		Repeat       string    `json:"repeat"`   // see recur.go
+		Reminder     string    `json:"reminder"` // see remind.go
+		Completed    bool      `json:"completed"`
+		ParentID     uint      `json:"parent_id"` // 0 unless a subtask
+		AutoComplete bool      `json:"auto_complete"`
+		Priority     string    `json:"priority"` // see order.go
+		Position     int       `json:"position"`
+		Tags         []string  `json:"tags"` // see tags.go
+		CreatedAt    time.Time `json:"created_at"`
+		UpdatedAt    time.Time `json:"updated_at"`
+	}
+
+	// apiSearchResult is the JSON form of a searchResult
+	apiSearchResult struct {
+		Score   int     `json:"score"`
+		Task    apiTask `json:"task"`
+		Title   string  `json:"title"` // HTML, hits in <mark> tags
+		List    string  `json:"list"`
+		Snippet string  `json:"snippet"` // "" unless the details matched
+	}
+
+	// apiMember is the JSON form of a Member
+	apiMember struct {
+		ID        uint   `json:"id"`
+		ListID    uint   `json:"list_id"`
+		ListTitle string `json:"list_title"` // only ever written
+		UserID    uint   `json:"user_id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Role      string `json:"role"`
+		Accepted  bool   `json:"accepted"`
+	}
+
+	// apiTag is the JSON form of a Tag
+	apiTag struct {
+		ID    uint   `json:"id"`
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	}
+
+	// apiWebhook is the JSON form of a Webhook
+	apiWebhook struct {
+		ID        uint      `json:"id"`
+		URL       string    `json:"url"`
+		Events    []string  `json:"events"` // empty for all of them
+		Active    bool      `json:"active"`
+		Secret    string    `json:"secret"` // only ever written
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// apiDelivery is the JSON form of a Delivery
+	apiDelivery struct {
+		ID          uint            `json:"id"`
+		WebhookID   uint            `json:"webhook_id"`
+		Event       string          `json:"event"`
+		Payload     json.RawMessage `json:"payload"`
+		Attempts    int             `json:"attempts"`
+		Status      int             `json:"status"`
+		Error       string          `json:"error"`
+		Delivered   bool            `json:"delivered"`
+		NextAttempt *time.Time      `json:"next_attempt"` // null once delivered or given up on
+		CreatedAt   time.Time       `json:"created_at"`
+	}
+)
+
+// userJSON converts a User for the API.
+func userJSON(u User) apiUser {
+	return apiUser{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email, Reminders: !u.RemindersOff}
+}
+
+// listJSON converts a TaskList for the API.
+func listJSON(l TaskList) apiList {
+	return apiList{ID: l.ID, UserID: l.UserID, Title: l.Title, Position: l.Position, CreatedAt: l.CreatedAt, UpdatedAt: l.UpdatedAt}
+}
+
+// taskJSON converts a Task for the API.
+func taskJSON(t Task) apiTask {
+	tags := []string{}
+	for _, tag := range t.Tags {
+		tags = append(tags, tag.Name)
+	}
+	return apiTask{
+		ID:           t.ID,
+		TaskListID:   t.TaskListID,
+		Title:        t.Title,
+		Details:      t.Details,
+		DueDate:      t.DueString(),
+		Overdue:      t.Overdue(),
+		Repeat:       t.Recurrence,
+		Reminder:     t.Reminder,
+		Completed:    t.Completed,
+		ParentID:     t.ParentID,
+		AutoComplete: t.AutoComplete,
+		Priority:     t.PriorityName(),
+		Position:     t.Position,
+		Tags:         tags,
+		CreatedAt:    t.CreatedAt,
+		UpdatedAt:    t.UpdatedAt,
+	}
+}
+
+// memberJSON converts a Member for the API, looking up its list and user.
+func memberJSON(m Member) (apiMember, error) {
+	list, err := store.GetList(m.TaskListID)
+	if err != nil {
+		return apiMember{}, err
+	}
+	u, err := store.GetUser(m.UserID)
+	if err != nil {
+		return apiMember{}, err
+	}
+	return apiMember{
+		ID:        m.ID,
+		ListID:    m.TaskListID,
+		ListTitle: list.Title,
+		UserID:    m.UserID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Role:      m.Role,
+		Accepted:  m.Accepted,
+	}, nil
+}
+
+// membersJSON converts Members for the API.
+func membersJSON(members []Member) ([]apiMember, error) {
+	out := []apiMember{}
+	for _, m := range members {
+		j, err := memberJSON(m)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, nil
+}
+
+// tagJSON converts a Tag for the API.
+func tagJSON(t Tag) apiTag {
+	return apiTag{ID: t.ID, Name: t.Name, Color: t.Color}
+}
+
+// webhookJSON converts a Webhook for the API.
+func webhookJSON(h Webhook) apiWebhook {
+	events := []string{}
+	if h.Events != "" {
+		events = strings.Split(h.Events, ",")
+	}
+	return apiWebhook{ID: h.ID, URL: h.URL, Events: events, Active: h.Active, Secret: h.Secret, CreatedAt: h.CreatedAt}
+}
+
+// deliveryJSON converts a Delivery for the API.
+func deliveryJSON(d Delivery) apiDelivery {
+	payload := json.RawMessage(d.Payload)
+	if d.Payload == "" {
+		payload = json.RawMessage("null")
+	}
+	return apiDelivery{
+		ID:          d.ID,
+		WebhookID:   d.WebhookID,
+		Event:       d.Event,
+		Payload:     payload,
+		Attempts:    d.Attempts,
+		Status:      d.Status,
+		Error:       d.Error,
+		Delivered:   d.Delivered,
+		NextAttempt: d.NextAttempt,
+		CreatedAt:   d.CreatedAt,
+	}
+}
+
+// writeJSON replies with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiError replies with a JSON error body.
+func apiError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// apiStoreError replies with a JSON error from the store or accounts.
+func apiStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrNotFound:
+		apiError(w, http.StatusNotFound, "not found")
+	case errForbidden, errBadCSRF:
+		apiError(w, http.StatusForbidden, err.Error())
+	case errBadSession, errBadLogin:
+		apiError(w, http.StatusUnauthorized, err.Error())
+	case errNameTaken:
+		apiError(w, http.StatusConflict, err.Error())
+	default:
+		if _, ok := err.(badInput); ok {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		noteError(w, err)
+		apiError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// methodNotAllowed rejects a request made with an unsupported method.
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	apiError(w, http.StatusMethodNotAllowed, "method not allowed (use "+allow+")")
+}
+
+// readJSON decodes the request body into v.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "bad JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// apiHandler routes API requests by resource.
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+
+	// paths look like resource[/id[/subresource]]
+	var resource, sub string
+	var id uint
+	switch len(parts) {
+	case 3:
+		sub = parts[2]
+		fallthrough
+	case 2:
+		n, err := strconv.ParseUint(parts[1], 10, 0)
+		if err != nil {
+			apiError(w, http.StatusNotFound, "no such resource")
+			return
+		}
+		id = uint(n)
+		fallthrough
+	case 1:
+		resource = parts[0]
+	}
+
+	// anyone may register or log in; everything else needs a session, and
+	// its CSRF token to change anything
+	var user User
+	if len(parts) != 1 || (resource != "users" && resource != "session") {
+		var err error
+		if user, err = sessionUser(r); err == nil {
+			err = checkCSRF(r)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+	}
+
+	switch {
+	case resource == "users" && len(parts) == 1:
+		apiUsers(w, r)
+	case resource == "session" && len(parts) == 1:
+		apiSession(w, r)
+	case resource == "users" && len(parts) == 2:
+		apiUserByID(w, r, user, id)
+	case resource == "users" && sub == "lists":
+		apiUserLists(w, r, user, id)
+	case resource == "users" && sub == "invites":
+		apiUserInvites(w, r, user, id)
+	case resource == "users" && sub == "export":
+		apiUserExport(w, r, user, id)
+	case resource == "users" && sub == "import":
+		apiUserImport(w, r, user, id)
+	case resource == "lists" && len(parts) == 2:
+		apiListByID(w, r, user, id)
+	case resource == "lists" && sub == "tasks":
+		apiListTasks(w, r, user, id)
+	case resource == "lists" && sub == "members":
+		apiListMembers(w, r, user, id)
+	case resource == "members" && len(parts) == 2:
+		apiMemberByID(w, r, user, id)
+	case resource == "tasks" && len(parts) == 2:
+		apiTaskByID(w, r, user, id)
+	case resource == "tasks" && sub == "subtasks":
+		apiSubtasks(w, r, user, id)
+	case resource == "tags" && len(parts) == 1:
+		apiTags(w, r, user)
+	case resource == "tags" && len(parts) == 2:
+		apiTagByID(w, r, user, id)
+	case resource == "tags" && sub == "tasks":
+		apiTagTasks(w, r, user, id)
+	case resource == "search" && len(parts) == 1:
+		apiSearch(w, r, user)
+	case resource == "webhooks" && len(parts) == 1:
+		apiWebhooks(w, r, user)
+	case resource == "webhooks" && len(parts) == 2:
+		apiWebhookByID(w, r, user, id)
+	case resource == "webhooks" && sub == "deliveries":
+		apiDeliveries(w, r, user, id)
+	default:
+		apiError(w, http.StatusNotFound, "no such resource")
+	}
+}
+
+// apiUsers registers a user.
+func apiUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var in apiUser
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	user, err := register(in.FirstName, in.LastName, in.Password)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%susers/%d", apiPrefix, user.ID))
+	writeJSON(w, http.StatusCreated, userJSON(user))
+}
+
+// apiSession logs in, reports who is logged in, or logs out.
+func apiSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var in apiUser
+		if !readJSON(w, r, &in) {
+			return
+		}
+		user, err := authenticate(in.FirstName, in.LastName, in.Password)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		out := userJSON(user)
+		out.CSRFToken = csrfToken(startSession(w, user))
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodGet:
+		user, err := sessionUser(r)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		out := userJSON(user)
+		out.CSRFToken = pageToken(r)
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodDelete:
+		if _, err := sessionUser(r); err == nil {
+			if err = checkCSRF(r); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		endSession(w)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET, POST, DELETE")
+	}
+}
+
+// apiUserByID serves a user, or changes their email settings.
+func apiUserByID(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+		methodNotAllowed(w, "GET, PATCH")
+		return
+	}
+
+	if err := authorizeUser(user, id); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	if r.Method == http.MethodPatch {
+		var in struct {
+			Email     *string `json:"email"`
+			Reminders *bool   `json:"reminders"`
+		}
+		if !readJSON(w, r, &in) {
+			return
+		}
+		if in.Email != nil {
+			if err := user.setEmail(*in.Email); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		if in.Reminders != nil {
+			user.RemindersOff = !*in.Reminders
+		}
+		if err := store.SaveUser(&user); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, userJSON(user))
+}
+
+// apiUserLists lists or adds to a user's lists.
+func apiUserLists(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if err := authorizeUser(user, id); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		lists, err := userLists(user)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		out := []apiList{}
+		for _, l := range lists {
+			out = append(out, listJSON(l))
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in apiList
+		if !readJSON(w, r, &in) {
+			return
+		}
+		list := TaskList{Title: strings.TrimSpace(in.Title), UserID: user.ID}
+		err := list.Validate()
+		if err == nil {
+			err = checkListTitle(list)
+		}
+		if err == nil {
+			err = store.CreateList(&list)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		emit("list.created", user, list, nil)
+		w.Header().Set("Location", fmt.Sprintf("%slists/%d", apiPrefix, list.ID))
+		writeJSON(w, http.StatusCreated, listJSON(list))
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// apiListByID serves, renames or deletes a list.
+func apiListByID(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	need := methodAccess(r)
+	if need == writeAccess {
+		need = ownerAccess
+	}
+	list, err := userList(user, id, need)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, listJSON(list))
+
+	case http.MethodPatch:
+		var in struct {
+			Title    *string `json:"title"`
+			Position *int    `json:"position"`
+		}
+		if !readJSON(w, r, &in) {
+			return
+		}
+		title := list.Title
+		if in.Title != nil {
+			list.Title = strings.TrimSpace(*in.Title)
+		}
+
+		err := list.Validate()
+		if err == nil && list.Title != title {
+			err = checkListTitle(list)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		err = store.SaveList(&list)
+		if err == nil && in.Position != nil {
+			err = moveList(list, strconv.Itoa(*in.Position))
+		}
+		if err == nil {
+			list, err = store.GetList(list.ID)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		emit("list.updated", user, list, nil)
+		writeJSON(w, http.StatusOK, listJSON(list))
+
+	case http.MethodDelete:
+		event := newEvent("list.deleted", user, list, nil)
+		if err := store.DeleteList(list); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		event.send()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	}
+}
+
+// apiListTasks lists or adds to a list's tasks.
+func apiListTasks(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	list, err := userList(user, id, methodAccess(r))
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tasks, err := store.Tasks(list.ID)
+		if err == nil {
+			err = loadTags(user, tasks)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		sortTasks(tasks, r.FormValue("sort"))
+		out := []apiTask{}
+		for _, t := range tasks {
+			out = append(out, taskJSON(t))
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in apiTask
+		if !readJSON(w, r, &in) {
+			return
+		}
+		apiCreateTask(w, user, list, in)
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// apiCreateTask adds a task (or with in.ParentID, a subtask) to a list.
+// A repeating task added as completed is completed as if marked, adding
+// its next occurrence.
+func apiCreateTask(w http.ResponseWriter, user User, list TaskList, in apiTask) {
+	task := Task{
+		Title:        strings.TrimSpace(in.Title),
+		Details:      in.Details,
+		TaskListID:   list.ID,
+		ParentID:     in.ParentID,
+		AutoComplete: in.AutoComplete,
+	}
+	var err error
+	task.Priority, err = parsePriority(in.Priority)
+	if err == nil {
+		err = task.SetDue(in.DueDate)
+	}
+	if err == nil {
+		err = task.SetRepeat(in.Repeat)
+	}
+	if err == nil {
+		err = task.SetReminder(in.Reminder)
+	}
+	if err == nil {
+		err = task.Validate()
+	}
+	if err == nil {
+		err = checkTaskTitle(task)
+	}
+	if err == nil {
+		err = checkParent(task, task.ParentID)
+	}
+	var tags []string
+	if err == nil {
+		tags, err = parseTagNames(strings.Join(in.Tags, ","))
+	}
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	err = store.CreateTask(&task)
+	if err == nil {
+		err = tagTask(user, &task, tags)
+	}
+	var next *Task
+	if err == nil && in.Completed {
+		next, err = setCompleted(&task, true, time.Now())
+	} else if err == nil {
+		err = settleParent(task.TaskListID, task.ParentID, time.Now())
+	}
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	emit("task.created", user, list, &task)
+	if next != nil {
+		emitTask("task.created", user, *next)
+		w.Header().Set("Link", fmt.Sprintf(`<%stasks/%d>; rel="next"`, apiPrefix, next.ID))
+	}
+	w.Header().Set("Location", fmt.Sprintf("%stasks/%d", apiPrefix, task.ID))
+	writeJSON(w, http.StatusCreated, taskJSON(task))
+}
+
+// apiTaskByID serves, changes or deletes a task.
+func apiTaskByID(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	task, err := userTask(user, id, methodAccess(r))
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if task.Tags, err = taskTags(user, task.ID); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, taskJSON(task))
+
+	case http.MethodPatch:
+		var in struct {
+			Title        *string   `json:"title"`
+			Details      *string   `json:"details"`
+			DueDate      *string   `json:"due_date"`
+			Repeat       *string   `json:"repeat"`
+			Reminder     *string   `json:"reminder"`
+			Completed    *bool     `json:"completed"`
+			ParentID     *uint     `json:"parent_id"`
+			AutoComplete *bool     `json:"auto_complete"`
+			Priority     *string   `json:"priority"`
+			Position     *int      `json:"position"`
+			Tags         *[]string `json:"tags"`
+		}
+		if !readJSON(w, r, &in) {
+			return
+		}
+		title := task.Title
+		if in.Title != nil {
+			task.Title = strings.TrimSpace(*in.Title)
+		}
+		if in.Details != nil {
+			task.Details = *in.Details
+		}
+		if in.DueDate != nil {
+			if err := task.SetDue(*in.DueDate); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		if in.Repeat != nil {
+			if err := task.SetRepeat(*in.Repeat); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		if in.Reminder != nil {
+			if err := task.SetReminder(*in.Reminder); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		if in.AutoComplete != nil {
+			task.AutoComplete = *in.AutoComplete
+		}
+		if in.Priority != nil {
+			if task.Priority, err = parsePriority(*in.Priority); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		var tags []string
+		if in.Tags != nil {
+			if tags, err = parseTagNames(strings.Join(*in.Tags, ",")); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		// tasks moved to a new parent go after its other subtasks
+		oldParent := task.ParentID
+		if in.ParentID != nil && *in.ParentID != task.ParentID {
+			err := checkParent(task, *in.ParentID)
+			if err == nil {
+				task.ParentID = *in.ParentID
+				task.Position, err = lastPosition(task)
+			}
+			if err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+
+		err = task.Validate()
+		if err == nil && task.Title != title {
+			err = checkTaskTitle(task)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		// completing a repeating task also adds its next occurrence, and
+		// parents may complete or reopen with it
+		now := time.Now()
+		event := "task.updated"
+		var next *Task
+		if in.Completed != nil && *in.Completed != task.Completed {
+			if next, err = setCompleted(&task, *in.Completed, now); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+			event = completedEvent(task)
+			if next != nil {
+				w.Header().Set("Link", fmt.Sprintf(`<%stasks/%d>; rel="next"`, apiPrefix, next.ID))
+			}
+		} else if err := store.SaveTask(&task); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		parents := []uint{oldParent, task.ParentID}
+		if in.AutoComplete != nil {
+			parents = append(parents, task.ID)
+		}
+		for _, parent := range parents {
+			if err := settleParent(task.TaskListID, parent, now); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		if in.Tags != nil {
+			if err := tagTask(user, &task, tags); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		if in.Position != nil {
+			if err := moveTask(task, strconv.Itoa(*in.Position)); err != nil {
+				apiStoreError(w, err)
+				return
+			}
+		}
+		task, err = store.GetTask(task.ID)
+		if err == nil {
+			task.Tags, err = taskTags(user, task.ID)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		emitTask(event, user, task)
+		if next != nil {
+			emitTask("task.created", user, *next)
+		}
+		writeJSON(w, http.StatusOK, taskJSON(task))
+
+	case http.MethodDelete:
+		if err := deleteTask(task); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		emitTask("task.deleted", user, task)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	}
+}
+
+// apiSubtasks lists or adds to a task's subtasks.
+func apiSubtasks(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	parent, err := userTask(user, id, methodAccess(r))
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tasks, err := store.Tasks(parent.TaskListID)
+		if err == nil {
+			err = loadTags(user, tasks)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		sortTasks(tasks, r.FormValue("sort"))
+		out := []apiTask{}
+		for _, t := range tasks {
+			if t.ParentID == parent.ID {
+				out = append(out, taskJSON(t))
+			}
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in apiTask
+		if !readJSON(w, r, &in) {
+			return
+		}
+		list, err := store.GetList(parent.TaskListID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		in.ParentID = parent.ID
+		apiCreateTask(w, user, list, in)
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// apiTags lists the user's tags.
+func apiTags(w http.ResponseWriter, r *http.Request, user User) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	tags, err := store.Tags(user.ID)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	out := []apiTag{}
+	for _, t := range tags {
+		out = append(out, tagJSON(t))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiTagByID serves, renames or recolours, or deletes a tag.
+func apiTagByID(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	tag, err := userTag(user, id)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, tagJSON(tag))
+
+	case http.MethodPatch:
+		var in struct {
+			Name  *string `json:"name"`
+			Color *string `json:"color"`
+		}
+		if !readJSON(w, r, &in) {
+			return
+		}
+		name, color := tag.Name, tag.Color
+		if in.Name != nil {
+			name = strings.TrimSpace(*in.Name)
+		}
+		if in.Color != nil {
+			color = *in.Color
+		}
+		if err := updateTag(user, &tag, name, color); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		refresh(user.ID)
+		writeJSON(w, http.StatusOK, tagJSON(tag))
+
+	case http.MethodDelete:
+		if err := store.DeleteTag(tag); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		refresh(user.ID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	}
+}
+
+// apiTagTasks lists the tasks with a tag, from all of the user's lists.
+func apiTagTasks(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	tag, err := userTag(user, id)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	tasks, err := store.TaggedTasks(tag.ID)
+	if err == nil {
+		err = loadTags(user, tasks)
+	}
+	// leaving a shared list leaves the user's tags on its tasks
+	var lists []TaskList
+	if err == nil {
+		lists, err = userLists(user)
+	}
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	visible := map[uint]bool{}
+	for _, l := range lists {
+		visible[l.ID] = true
+	}
+	sortTasks(tasks, r.FormValue("sort"))
+	out := []apiTask{}
+	for _, t := range tasks {
+		if visible[t.TaskListID] {
+			out = append(out, taskJSON(t))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiSearch finds the user's tasks matching ?q=.
+func apiSearch(w http.ResponseWriter, r *http.Request, user User) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	results, err := search(user, r.FormValue("q"))
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	out := []apiSearchResult{}
+	for _, res := range results {
+		if res.Task.Tags, err = taskTags(user, res.Task.ID); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		out = append(out, apiSearchResult{
+			Score:   res.Score,
+			Task:    taskJSON(res.Task),
+			Title:   res.Title.HTML(),
+			List:    res.ListTitle.HTML(),
+			Snippet: res.Details.HTML(),
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiUserInvites lists a user's open invitations.
+func apiUserInvites(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	if err := authorizeUser(user, id); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	members, err := store.Memberships(user.ID)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	var open []Member
+	for _, m := range members {
+		if !m.Accepted {
+			open = append(open, m)
+		}
+	}
+	out, err := membersJSON(open)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiUserExport serves a user's lists in the export format.
+func apiUserExport(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	if err := authorizeUser(user, id); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	if err := writeExport(w, user, r.FormValue("format"), false); err != nil {
+		apiStoreError(w, err)
+	}
+}
+
+// apiUserImport loads a file of lists into a user's.
+func apiUserImport(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	if err := authorizeUser(user, id); err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	replace, err := parseImportMode(r.FormValue("mode"))
+	var dryRun bool
+	if err == nil && r.FormValue("dry_run") != "" {
+		if dryRun, err = strconv.ParseBool(r.FormValue("dry_run")); err != nil {
+			err = badInput("dry_run must be true or false")
+		}
+	}
+	var file exportFile
+	if err == nil {
+		format := r.URL.Query().Get("format")
+		switch ct := r.Header.Get("Content-Type"); {
+		case format != "":
+		case strings.HasPrefix(ct, "text/csv"):
+			format = "csv"
+		case strings.HasPrefix(ct, "text/plain"):
+			format = "todotxt"
+		case strings.HasPrefix(ct, "application/json"):
+			format = "json"
+		}
+		file, err = readImport(user, r.Body, format)
+	}
+	var sum importSummary
+	before := audience(user)
+	if err == nil {
+		sum, err = importLists(user, file, replace, dryRun)
+	}
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	if !dryRun {
+		refresh(append(before, audience(user)...)...)
+	}
+	writeJSON(w, http.StatusOK, sum)
+}
+
+// apiListMembers lists a list's members or invites a user to it.
+func apiListMembers(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	need := methodAccess(r)
+	if need == writeAccess {
+		need = ownerAccess
+	}
+	list, err := userList(user, id, need)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		members, err := store.Members(list.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		out, err := membersJSON(members)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in apiMember
+		if !readJSON(w, r, &in) {
+			return
+		}
+		var invitee User
+		if in.UserID != 0 {
+			if invitee, err = store.GetUser(in.UserID); err == ErrNotFound {
+				err = badInput("there's no user with that ID")
+			}
+		} else {
+			invitee, err = findInvitee(in.FirstName, in.LastName)
+		}
+		var member Member
+		if err == nil {
+			member, err = invite(list, invitee, in.Role)
+		}
+		var out apiMember
+		if err == nil {
+			out, err = memberJSON(member)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		refreshMember(member)
+		w.Header().Set("Location", fmt.Sprintf("%smembers/%d", apiPrefix, member.ID))
+		writeJSON(w, http.StatusCreated, out)
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// apiMemberByID serves a membership, lets the invited user accept it or
+// an owner change its role, or ends it.
+func apiMemberByID(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	need := readAccess
+	if r.Method != http.MethodGet {
+		need = ownerAccess
+	}
+	member, err := userMember(user, id, need)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPatch:
+		var in struct {
+			Role     *string `json:"role"`
+			Accepted *bool   `json:"accepted"`
+		}
+		if !readJSON(w, r, &in) {
+			return
+		}
+		if in.Role != nil {
+			// members can't change their own role
+			if _, err = userList(user, member.TaskListID, ownerAccess); err == nil {
+				member.Role, err = parseRole(*in.Role)
+			}
+		}
+		if err == nil && in.Accepted != nil && *in.Accepted != member.Accepted {
+			switch {
+			case member.UserID != user.ID:
+				err = badInput("only the invited user can accept")
+			case !*in.Accepted:
+				err = badInput("leave a list by deleting the membership")
+			default:
+				err = accept(&member)
+			}
+		}
+		if err == nil {
+			err = store.SaveMember(&member)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		refreshMember(member)
+
+	case http.MethodDelete:
+		if err := store.DeleteMember(member); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		refreshMember(member)
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+		return
+	}
+
+	out, err := memberJSON(member)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// apiWebhooks lists or adds to the user's webhooks.
+func apiWebhooks(w http.ResponseWriter, r *http.Request, user User) {
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := store.Webhooks(user.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		out := []apiWebhook{}
+		for _, h := range hooks {
+			out = append(out, webhookJSON(h))
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in apiWebhook
+		if !readJSON(w, r, &in) {
+			return
+		}
+		hook, err := newWebhook(user, in.URL, in.Events)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("%swebhooks/%d", apiPrefix, hook.ID))
+		writeJSON(w, http.StatusCreated, webhookJSON(hook))
+
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// apiWebhookByID serves, changes or deletes a webhook.
+func apiWebhookByID(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	hook, err := userWebhook(user, id)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, webhookJSON(hook))
+
+	case http.MethodPatch:
+		var in struct {
+			URL    *string   `json:"url"`
+			Events *[]string `json:"events"`
+			Active *bool     `json:"active"`
+		}
+		if !readJSON(w, r, &in) {
+			return
+		}
+		if in.URL != nil {
+			hook.URL, err = parseWebhookURL(*in.URL)
+		}
+		if err == nil && in.Events != nil {
+			hook.Events, err = parseEvents(*in.Events)
+		}
+		if in.Active != nil {
+			hook.Active = *in.Active
+		}
+		if err == nil {
+			err = store.SaveWebhook(&hook)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, webhookJSON(hook))
+
+	case http.MethodDelete:
+		if err := store.DeleteWebhook(hook); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	}
+}
+
+// apiDeliveries lists a webhook's deliveries, newest first.
+func apiDeliveries(w http.ResponseWriter, r *http.Request, user User, id uint) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+
+	hook, err := userWebhook(user, id)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	deliveries, err := store.Deliveries(hook.ID)
+	if err != nil {
+		apiStoreError(w, err)
+		return
+	}
+	out := []apiDelivery{}
+	for _, d := range deliveries {
+		out = append(out, deliveryJSON(d))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiClient sends JSON requests through the app's routes, keeping cookies.
+type apiClient struct {
+	t       *testing.T
+	mux     http.Handler
+	cookies []*http.Cookie
+}
+
+func (c *apiClient) call(method, path, body string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for _, cookie := range c.cookies {
+		if cookie.Name == sessionCookie {
+			withSession(r, cookie)
+		} else {
+			r.AddCookie(cookie)
+		}
+	}
+	c.mux.ServeHTTP(w, r)
+	if set := w.Result().Cookies(); len(set) > 0 {
+		c.cookies = set
+	}
+	return w
+}
+
+func TestAPI(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		c := &apiClient{t: t, mux: testServer(t)}
+		w := c.call("POST", "/api/v1/users", `{"first_name": "Ivan", "last_name": "Webber", "password": "correct horse"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create user: %d %s", w.Code, w.Body)
+		}
+		var user apiUser
+		json.Unmarshal(w.Body.Bytes(), &user)
+		if user.Password != "" {
+			t.Error("password echoed back")
+		}
+
+		w = c.call("POST", "/api/v1/users", `{"first_name": "Ivan", "last_name": "Webber", "password": "correct horse"}`)
+		if w.Code != http.StatusConflict {
+			t.Errorf("duplicate user: %d", w.Code)
+		}
+
+		if w = c.call("GET", "/api/v1/session", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("session before login: %d", w.Code)
+		}
+		if w = c.call("POST", "/api/v1/session", `{"first_name": "Ivan", "last_name": "Webber", "password": "wrong"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("login with wrong password: %d", w.Code)
+		}
+		if w = c.call("POST", "/api/v1/session", `{"first_name": "Ivan", "last_name": "Webber", "password": "correct horse"}`); w.Code != http.StatusOK {
+			t.Fatalf("login: %d %s", w.Code, w.Body)
+		}
+
+		w = c.call("POST", fmt.Sprintf("/api/v1/users/%d/lists", user.ID), `{"title": "Home Work"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create list: %d %s", w.Code, w.Body)
+		}
+		var list apiList
+		json.Unmarshal(w.Body.Bytes(), &list)
+		listPath := fmt.Sprintf("/api/v1/lists/%d", list.ID)
+		if list.UserID != user.ID || w.Header().Get("Location") != listPath {
+			t.Errorf("create list: %+v at %q", list, w.Header().Get("Location"))
+		}
+
+		w = c.call("POST", listPath+"/tasks", `{"title": "Write Code", "due_date": "2020-04-01"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create task: %d %s", w.Code, w.Body)
+		}
+		var task apiTask
+		json.Unmarshal(w.Body.Bytes(), &task)
+		taskPath := w.Header().Get("Location")
+
+		w = c.call("PATCH", taskPath, `{"completed": true}`)
+		json.Unmarshal(w.Body.Bytes(), &task)
+		if w.Code != http.StatusOK || !task.Completed || task.Title != "Write Code" {
+			t.Errorf("patch task: %d %+v", w.Code, task)
+		}
+
+		w = c.call("GET", listPath+"/tasks", "")
+		var tasks []apiTask
+		json.Unmarshal(w.Body.Bytes(), &tasks)
+		if w.Code != http.StatusOK || len(tasks) != 1 {
+			t.Errorf("list tasks: %d %s", w.Code, w.Body)
+		}
+
+		// titles must be unique, as on the pages
+		lists := fmt.Sprintf("/api/v1/users/%d/lists", user.ID)
+		other := c.call("POST", listPath+"/tasks", `{"title": "Read Docs"}`).Header().Get("Location")
+		otherList := c.call("POST", lists, `{"title": "Errands"}`).Header().Get("Location")
+
+		errors := []struct {
+			method, path, body string
+			code               int
+		}{
+			{"POST", listPath + "/tasks", `{"title": ""}`, http.StatusBadRequest},
+			{"POST", listPath + "/tasks", `{"nonsense": 1}`, http.StatusBadRequest},
+			{"POST", listPath + "/tasks", `{"title": " Write Code "}`, http.StatusBadRequest},
+			{"PATCH", other, `{"title": "Write Code"}`, http.StatusBadRequest},
+			{"POST", lists, `{"title": "Home Work"}`, http.StatusBadRequest},
+			{"PATCH", otherList, `{"title": "Home Work"}`, http.StatusBadRequest},
+			{"PUT", taskPath, `{}`, http.StatusMethodNotAllowed},
+			{"GET", "/api/v1/tasks/99", "", http.StatusNotFound},
+			{"GET", "/api/v1/tasks/abc", "", http.StatusNotFound},
+			{"GET", "/api/v1/widgets", "", http.StatusNotFound},
+		}
+		for _, e := range errors {
+			w := c.call(e.method, e.path, e.body)
+			var body map[string]string
+			if w.Code != e.code || json.Unmarshal(w.Body.Bytes(), &body) != nil || body["error"] == "" {
+				t.Errorf("%s %s: %d %s, want %d with an error body", e.method, e.path, w.Code, w.Body, e.code)
+			}
+		}
+
+		// renaming saves
+		if w := c.call("PATCH", other, `{"title": "Read More Docs"}`); w.Code != http.StatusOK {
+			t.Errorf("rename task: %d %s", w.Code, w.Body)
+		}
+		if w := c.call("PATCH", otherList, `{"title": "Chores"}`); w.Code != http.StatusOK {
+			t.Errorf("rename list: %d %s", w.Code, w.Body)
+		}
+
+		// a repeating task added as done is followed by its next occurrence
+		w = c.call("POST", listPath+"/tasks", `{"title": "Water Plants", "due_date": "2020-04-01", "repeat": "FREQ=DAILY", "completed": true}`)
+		var done apiTask
+		json.Unmarshal(w.Body.Bytes(), &done)
+		if w.Code != http.StatusCreated || !done.Completed || done.Title != "Water Plants (2020-04-01)" || w.Header().Get("Link") == "" {
+			t.Errorf("create completed repeating task: %d %s", w.Code, w.Body)
+		}
+		w = c.call("GET", listPath+"/tasks", "")
+		json.Unmarshal(w.Body.Bytes(), &tasks)
+		found := false
+		for _, task := range tasks {
+			found = found || task.Title == "Water Plants" && !task.Completed && task.Repeat == "FREQ=DAILY"
+		}
+		if !found {
+			t.Errorf("no next occurrence in %s", w.Body)
+		}
+
+		if w := c.call("DELETE", listPath, ""); w.Code != http.StatusNoContent {
+			t.Errorf("delete list: %d", w.Code)
+		}
+		if w := c.call("GET", taskPath, ""); w.Code != http.StatusNotFound {
+			t.Errorf("task survived list delete: %d", w.Code)
+		}
+
+		if w := c.call("DELETE", "/api/v1/session", ""); w.Code != http.StatusNoContent {
+			t.Errorf("logout: %d", w.Code)
+		}
+		if w := c.call("GET", listPath, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("list after logout: %d", w.Code)
+		}
+	})
+}
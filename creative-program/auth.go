@@ -0,0 +1,211 @@
+package main
+
+/*
+	## Accounts & Sessions
+	Users register with their name and a password. Only a bcrypt hash of the
+	password is stored. Logging in hands the browser a session cookie holding
+	the user's ID and an expiry time, signed with HMAC-SHA256 so it can't be
+	forged or edited. Every handler that touches lists or tasks works out who
+	the user is from that cookie rather than from the URL.
+
+	The signing key comes from the session-key setting (see config.go). If it
+	is empty a random key is made at startup, which logs everyone out
+	whenever the server restarts.
+
+	When the server speaks HTTPS the cookie is marked Secure, so browsers
+	only ever send it encrypted (see tls.go).
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// name of the cookie holding a signed session
+const sessionCookie = "session"
+
+// how long a login lasts
+const sessionMaxAge = 7 * 24 * time.Hour
+
+// minPassword is the shortest password accepted at registration.
+const minPassword = 8
+
+// badInput is an error the user can fix by changing what they sent.
+type badInput string
+
+func (e badInput) Error() string {
+	return string(e)
+}
+
+var (
+	// errBadSession is returned for a missing, forged or expired session.
+	errBadSession = errors.New("not logged in")
+	// errBadLogin is returned for an unknown name or wrong password.
+	errBadLogin = badInput("wrong name or password")
+	// errNameTaken is returned when registering a name already in use.
+	errNameTaken = badInput("that name is already registered")
+)
+
+// key used to sign session cookies (see serve)
+var sessionKey []byte
+
+// newSessionKey makes a random signing key.
+func newSessionKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// SetPassword stores a hash of password on the user.
+func (u *User) SetPassword(password string) error {
+	if len(password) < minPassword {
+		return badInput(fmt.Sprintf("password must be at least %d characters", minPassword))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword reports whether password is the user's password.
+func (u User) CheckPassword(password string) bool {
+	return u.PasswordHash != "" &&
+		bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// sign computes the signature of a session payload.
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// startSession gives the client a signed cookie naming the user, and
+// returns its value.
+func startSession(w http.ResponseWriter, user User) string {
+	expires := time.Now().Add(sessionMaxAge)
+	payload := fmt.Sprintf("%d.%d", user.ID, expires.Unix())
+	value := payload + "." + sign(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return value
+}
+
+// endSession clears the client's session cookie.
+func endSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionUser finds the user named by the request's session cookie.
+func sessionUser(r *http.Request) (User, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return User{}, errBadSession
+	}
+
+	// value is id.expires.signature
+	i := strings.LastIndex(c.Value, ".")
+	if i < 0 {
+		return User{}, errBadSession
+	}
+	payload, sig := c.Value[:i], c.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(sign(payload))) {
+		return User{}, errBadSession
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return User{}, errBadSession
+	}
+	id, err1 := strconv.ParseUint(parts[0], 10, 0)
+	expires, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil || time.Now().Unix() > expires {
+		return User{}, errBadSession
+	}
+
+	user, err := store.GetUser(uint(id))
+	if err == ErrNotFound {
+		return User{}, errBadSession
+	}
+	return user, err
+}
+
+// userHandler is a handler that needs to know who is logged in.
+type userHandler func(w http.ResponseWriter, r *http.Request, user User)
+
+// loggedIn sends visitors without a session to the welcome page, and
+// turns away changes without the session's CSRF token (see csrf.go).
+func loggedIn(h userHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := sessionUser(r)
+		if err == errBadSession {
+			http.Redirect(w, r, "/welcome/", http.StatusFound)
+			return
+		}
+		if err == nil {
+			err = checkCSRF(r)
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		noteUser(w, user)
+		h(w, r, user)
+	}
+}
+
+// register creates an account, checking the name is free.
+func register(first, last, password string) (User, error) {
+	if strings.TrimSpace(first) == "" || strings.TrimSpace(last) == "" {
+		return User{}, badInput("first and last name are required")
+	}
+	if _, err := store.FindUser(first, last); err != ErrNotFound {
+		if err == nil {
+			err = errNameTaken
+		}
+		return User{}, err
+	}
+
+	user := User{FirstName: first, LastName: last}
+	if err := user.SetPassword(password); err != nil {
+		return User{}, err
+	}
+	return user, store.CreateUser(&user)
+}
+
+// authenticate finds the user with the given name and password.
+func authenticate(first, last, password string) (User, error) {
+	user, err := store.FindUser(first, last)
+	if err == ErrNotFound || (err == nil && !user.CheckPassword(password)) {
+		return User{}, errBadLogin
+	}
+	return user, err
+}
@@ -0,0 +1,216 @@
+package main
+
+/*
+	## Authorization
+	Knowing who is logged in (auth.go) isn't enough; every list, task and
+	tag a request reads or changes must also belong to that user, or to a
+	list shared with them (see share.go). Handlers never look them up on
+	their own. Instead they ask for them through the functions below,
+	saying how much access they need, and get back ErrNotFound for lists
+	the user can't see at all (so IDs and titles of other people's lists
+	aren't revealed) or errForbidden for lists they can see but not change.
+
+	| access | needed to                                 |
+	| ------ | ----------------------------------------- |
+	| read   | see a list and its tasks                  |
+	| write  | add, change, complete or delete its tasks |
+	| owner  | rename, move, delete or share the list    |
+*/
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errForbidden is returned when a user may see a resource but not change it.
+var errForbidden = errors.New("forbidden")
+
+// access is how much a user may do with a list and its tasks.
+type access int
+
+const (
+	noAccess access = iota
+	readAccess
+	writeAccess
+	ownerAccess
+)
+
+// methodAccess is the access a request's method needs.
+func methodAccess(r *http.Request) access {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return readAccess
+	}
+	return writeAccess
+}
+
+// listAccess works out a user's access to a list: full access to their
+// own, and whatever their role allows in lists shared with them.
+func listAccess(user User, list TaskList) (access, error) {
+	if list.UserID == user.ID {
+		return ownerAccess, nil
+	}
+	member, err := store.FindMember(list.ID, user.ID)
+	switch {
+	case err == ErrNotFound || err == nil && !member.Accepted:
+		return noAccess, nil
+	case err != nil:
+		return noAccess, err
+	}
+	return roleAccess[member.Role], nil
+}
+
+// authorizeList checks the user has at least the needed access to a list.
+func authorizeList(user User, list TaskList, need access) error {
+	have, err := listAccess(user, list)
+	switch {
+	case err != nil:
+		return err
+	case have == noAccess:
+		return ErrNotFound
+	case have < need:
+		return errForbidden
+	}
+	return nil
+}
+
+// authorizeUser checks a user is acting on their own account.
+func authorizeUser(user User, id uint) error {
+	if user.ID != id {
+		return errForbidden
+	}
+	return nil
+}
+
+// userList finds a list by ID that the user has the needed access to.
+func userList(user User, id uint, need access) (TaskList, error) {
+	list, err := store.GetList(id)
+	if err == nil {
+		err = authorizeList(user, list, need)
+	}
+	if err != nil {
+		return TaskList{}, err
+	}
+	return list, nil
+}
+
+// sharedLists returns the lists shared with the user, in the order they
+// joined them.
+func sharedLists(user User) ([]TaskList, error) {
+	members, err := store.Memberships(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var lists []TaskList
+	for _, m := range members {
+		if !m.Accepted {
+			continue
+		}
+		list, err := store.GetList(m.TaskListID)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// userLists returns the user's own lists, by position, followed by those
+// shared with them.
+func userLists(user User) ([]TaskList, error) {
+	lists, err := store.Lists(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := sharedLists(user)
+	return append(lists, shared...), err
+}
+
+// userListByTitle finds one of the user's lists by title, or failing that
+// one shared with them.
+func userListByTitle(user User, title string, need access) (TaskList, error) {
+	list, err := store.FindList(user.ID, title)
+	if err == ErrNotFound {
+		var shared []TaskList
+		shared, err = sharedLists(user)
+		if err == nil {
+			err = ErrNotFound
+		}
+		for _, l := range shared {
+			if l.Title == title {
+				list, err = l, nil
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = authorizeList(user, list, need)
+	}
+	if err != nil {
+		return TaskList{}, err
+	}
+	return list, nil
+}
+
+// userTask finds a task by ID in a list the user has the needed access to.
+func userTask(user User, id uint, need access) (Task, error) {
+	task, err := store.GetTask(id)
+	if err == nil {
+		_, err = userList(user, task.TaskListID, need)
+	}
+	if err != nil {
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// userTaskByTitle finds a task by title in one of the user's lists (or one
+// shared with them).
+func userTaskByTitle(user User, listTitle, taskTitle string, need access) (Task, error) {
+	list, err := userListByTitle(user, listTitle, need)
+	if err != nil {
+		return Task{}, err
+	}
+	return store.FindTask(list.ID, taskTitle)
+}
+
+// userTag finds one of the user's tags by ID.
+func userTag(user User, id uint) (Tag, error) {
+	tag, err := store.GetTag(id)
+	if err == nil && tag.UserID != user.ID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return Tag{}, err
+	}
+	return tag, nil
+}
+
+// userTagByName finds one of the user's tags by name.
+func userTagByName(user User, name string) (Tag, error) {
+	return store.FindTag(user.ID, name)
+}
+
+// userWebhook finds one of the user's webhooks by ID.
+func userWebhook(user User, id uint) (Webhook, error) {
+	hook, err := store.GetWebhook(id)
+	if err == nil && hook.UserID != user.ID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+// userMember finds a membership by ID for the invited user themselves, or
+// for a user with the needed access to its list.
+func userMember(user User, id uint, need access) (Member, error) {
+	member, err := store.GetMember(id)
+	if err == nil && member.UserID != user.ID {
+		_, err = userList(user, member.TaskListID, need)
+	}
+	if err != nil {
+		return Member{}, err
+	}
+	return member, nil
+}
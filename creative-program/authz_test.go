@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		c := &apiClient{t: t, mux: testServer(t)}
+		c.cookies = []*http.Cookie{loginAs(t, "Andrea", "Lam")}
+
+		andrea, _ := store.FindUser("Andrea", "Lam")
+		meet, _ := store.FindUser("Meet", "Bhagdev")
+		own, _ := store.FindList(andrea.ID, "Andrea's list")
+		other, _ := store.FindList(meet.ID, "Meet's List")
+		otherTask, _ := store.FindTask(other.ID, "Mow the lawn")
+
+		tests := []struct {
+			method, path, body string
+			code               int
+		}{
+			{"GET", fmt.Sprintf("/api/v1/users/%d", andrea.ID), "", http.StatusOK},
+			{"GET", fmt.Sprintf("/api/v1/lists/%d", own.ID), "", http.StatusOK},
+			{"GET", fmt.Sprintf("/api/v1/users/%d", meet.ID), "", http.StatusForbidden},
+			{"GET", fmt.Sprintf("/api/v1/users/%d/lists", meet.ID), "", http.StatusForbidden},
+			{"POST", fmt.Sprintf("/api/v1/users/%d/lists", meet.ID), `{"title": "Mine now"}`, http.StatusForbidden},
+			{"GET", fmt.Sprintf("/api/v1/lists/%d", other.ID), "", http.StatusNotFound},
+			{"PATCH", fmt.Sprintf("/api/v1/lists/%d", other.ID), `{"title": "Mine now"}`, http.StatusNotFound},
+			{"DELETE", fmt.Sprintf("/api/v1/lists/%d", other.ID), "", http.StatusNotFound},
+			{"GET", fmt.Sprintf("/api/v1/lists/%d/tasks", other.ID), "", http.StatusNotFound},
+			{"POST", fmt.Sprintf("/api/v1/lists/%d/tasks", other.ID), `{"title": "Sneaky"}`, http.StatusNotFound},
+			{"GET", fmt.Sprintf("/api/v1/tasks/%d", otherTask.ID), "", http.StatusNotFound},
+			{"PATCH", fmt.Sprintf("/api/v1/tasks/%d", otherTask.ID), `{"completed": true}`, http.StatusNotFound},
+			{"DELETE", fmt.Sprintf("/api/v1/tasks/%d", otherTask.ID), "", http.StatusNotFound},
+		}
+		for _, test := range tests {
+			if w := c.call(test.method, test.path, test.body); w.Code != test.code {
+				t.Errorf("%s %s: %d, want %d", test.method, test.path, w.Code, test.code)
+			}
+		}
+
+		// nothing of Meet's was changed
+		if lists, _ := store.Lists(meet.ID); len(lists) != 1 || lists[0].Title != "Meet's List" {
+			t.Errorf("Meet's lists changed: %v", lists)
+		}
+		if task, err := store.GetTask(otherTask.ID); err != nil || task.Completed {
+			t.Errorf("Meet's task changed: %+v, %v", task, err)
+		}
+		if tasks, _ := store.Tasks(other.ID); len(tasks) != 1 {
+			t.Errorf("task added to Meet's list: %v", tasks)
+		}
+	})
+}
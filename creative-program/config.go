@@ -0,0 +1,295 @@
+package main
+
+/*
+	## Configuration
+	Settings are read in layers, each overriding the last:
+
+	1. the defaults below (a demo SQL Server on localhost)
+	2. a JSON config file named by -config or TASKS_CONFIG
+	3. TASKS_* environment variables
+	4. command line flags
+
+	| flag             | environment           | purpose                                  |
+	| ---------------- | --------------------- | ---------------------------------------- |
+	| -config          | TASKS_CONFIG          | JSON file holding any of these settings  |
+	| -store           | TASKS_STORE           | mssql, postgres, sqlite or memory        |
+	| -dsn             | TASKS_DSN             | full connection string (overrides below) |
+	| -db-host         | TASKS_DB_HOST         | database server                          |
+	| -db-port         | TASKS_DB_PORT         | database port (0 for the usual one)      |
+	| -db-name         | TASKS_DB_NAME         | database name (file for sqlite)          |
+	| -db-user         | TASKS_DB_USER         | database login                           |
+	| -db-password     | TASKS_DB_PASSWORD     | database password                        |
+	| -addr            | TASKS_ADDR            | address the server listens on            |
+	| -templates       | TASKS_TEMPLATES       | directory holding the html templates     |
+	| -static          | TASKS_STATIC          | directory holding tasks.css              |
+	| -seed            | TASKS_SEED            | load demo data on start                  |
+	| -session-key     | TASKS_SESSION_KEY     | secret for signing session cookies       |
+	| -tls-cert        | TASKS_TLS_CERT        | PEM certificate (chain) to serve HTTPS   |
+	| -tls-key         | TASKS_TLS_KEY         | PEM private key for -tls-cert            |
+	| -tls-self-signed | TASKS_TLS_SELF_SIGNED | make a certificate for development       |
+	| -http-addr       | TASKS_HTTP_ADDR       | plain HTTP address redirecting to HTTPS  |
+	| -hsts-max-age    | TASKS_HSTS_MAX_AGE    | seconds browsers keep to HTTPS (0 off)   |
+	| -smtp-addr       | TASKS_SMTP_ADDR       | mail server for reminders, host:port     |
+	| -smtp-user       | TASKS_SMTP_USER       | mail server login ("" for none)          |
+	| -smtp-password   | TASKS_SMTP_PASSWORD   | mail server password                     |
+	| -mail-from       | TASKS_MAIL_FROM       | sender of reminder emails                |
+	| -log-format      | TASKS_LOG_FORMAT      | text (logfmt) or json                    |
+	| -log-level       | TASKS_LOG_LEVEL       | debug, info, warn or error               |
+
+	Without -smtp-addr no reminders are sent (see remind.go), and without
+	-tls-cert and -tls-key or -tls-self-signed the server speaks plain HTTP
+	(see tls.go). Logs go to standard error (see logging.go).
+
+	Keep passwords and the session key out of shared config files; the
+	environment is a better home for them.
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds everything that differs between deployments.
+type Config struct {
+	File       string `json:"-"`
+	Store      string `json:"store"`
+	DSN        string `json:"dsn"`
+	DBHost     string `json:"db_host"`
+	DBPort     int    `json:"db_port"`
+	DBName     string `json:"db_name"`
+	DBUser     string `json:"db_user"`
+	DBPassword string `json:"db_password"`
+	Addr       string `json:"addr"`
+	Templates  string `json:"templates"`
+	Static     string `json:"static"`
+	Seed       bool   `json:"seed"`
+	SessionKey string `json:"session_key"`
+
+	TLSCert       string `json:"tls_cert"`
+	TLSKey        string `json:"tls_key"`
+	TLSSelfSigned bool   `json:"tls_self_signed"`
+	HTTPAddr      string `json:"http_addr"`
+	HSTSMaxAge    int    `json:"hsts_max_age"`
+
+	SMTPAddr     string `json:"smtp_addr"`
+	SMTPUser     string `json:"smtp_user"`
+	SMTPPassword string `json:"smtp_password"`
+	MailFrom     string `json:"mail_from"`
+
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+}
+
+// DefaultConfig matches the original demo setup.
+func DefaultConfig() Config {
+	return Config{
+		Store:     "mssql",
+		DBHost:    "localhost",
+		DBName:    "SampleDB",
+		Addr:      ":8080",
+		Templates: ".",
+		Static:    ".",
+		MailFrom:  "tasks@localhost",
+
+		HSTSMaxAge: 365 * 24 * 60 * 60,
+		LogFormat:  "text",
+		LogLevel:   "info",
+	}
+}
+
+// setting ties a Config field to its flag and environment variable.
+type setting struct {
+	name  string
+	ptr   interface{}
+	usage string
+}
+
+// env is the environment variable for a setting.
+func (s setting) env() string {
+	return "TASKS_" + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+// settings lists every configurable field of c.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"config", &c.File, "JSON file holding any of these settings"},
+		{"store", &c.Store, "where to keep tasks: mssql, postgres, sqlite or memory"},
+		{"dsn", &c.DSN, "full connection string (overrides the db-* settings)"},
+		{"db-host", &c.DBHost, "database server"},
+		{"db-port", &c.DBPort, "database port (0 for the usual one)"},
+		{"db-name", &c.DBName, "database name (file for sqlite)"},
+		{"db-user", &c.DBUser, "database login"},
+		{"db-password", &c.DBPassword, "database password"},
+		{"addr", &c.Addr, "address the server listens on"},
+		{"templates", &c.Templates, "directory holding the app's HTML templates"},
+		{"static", &c.Static, "directory holding tasks.css"},
+		{"seed", &c.Seed, "load demo data on start"},
+		{"session-key", &c.SessionKey, "secret for signing session cookies"},
+		{"tls-cert", &c.TLSCert, "PEM certificate (chain) to serve HTTPS with"},
+		{"tls-key", &c.TLSKey, "PEM private key for tls-cert"},
+		{"tls-self-signed", &c.TLSSelfSigned, "make a self-signed certificate for development (kept in tls-cert and tls-key if set)"},
+		{"http-addr", &c.HTTPAddr, "plain HTTP address that redirects to HTTPS (none for no redirects)"},
+		{"hsts-max-age", &c.HSTSMaxAge, "seconds browsers should only use HTTPS for (0 to not ask)"},
+		{"smtp-addr", &c.SMTPAddr, "mail server for reminders, host:port (none to send none)"},
+		{"smtp-user", &c.SMTPUser, "mail server login (none to send without one)"},
+		{"smtp-password", &c.SMTPPassword, "mail server password"},
+		{"mail-from", &c.MailFrom, "sender of reminder emails"},
+		{"log-format", &c.LogFormat, "how to write logs: text (logfmt) or json"},
+		{"log-level", &c.LogLevel, "least important logs to write: debug, info, warn or error"},
+	}
+}
+
+// flagSet binds c's settings to command line flags.
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("tasks", flag.ContinueOnError)
+	for _, s := range c.settings() {
+		switch p := s.ptr.(type) {
+		case *string:
+			fs.StringVar(p, s.name, *p, s.usage+" ($"+s.env()+")")
+		case *int:
+			fs.IntVar(p, s.name, *p, s.usage+" ($"+s.env()+")")
+		case *bool:
+			fs.BoolVar(p, s.name, *p, s.usage+" ($"+s.env()+")")
+		}
+	}
+	return fs
+}
+
+// loadEnv overrides c with any TASKS_* environment variables that are set.
+func (c *Config) loadEnv() error {
+	for _, s := range c.settings() {
+		v, ok := os.LookupEnv(s.env())
+		if !ok {
+			continue
+		}
+		var err error
+		switch p := s.ptr.(type) {
+		case *string:
+			*p = v
+		case *int:
+			*p, err = strconv.Atoi(v)
+		case *bool:
+			*p, err = strconv.ParseBool(v)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.env(), err)
+		}
+	}
+	return nil
+}
+
+// loadFile overrides c with the settings in a JSON file.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// LoadConfig layers the defaults, config file, environment and args.
+// rest holds the arguments left after the flags.
+func LoadConfig(args []string) (cfg Config, rest []string, err error) {
+	// first pass only finds the config file
+	cfg = DefaultConfig()
+	cfg.loadEnv()
+	if err := cfg.flagSet().Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	file := cfg.File
+	cfg = DefaultConfig()
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return cfg, nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, nil, err
+	}
+	fs := cfg.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+	cfg.File = file
+
+	switch cfg.Store {
+	case "mssql", "postgres", "sqlite", "memory":
+	default:
+		return cfg, nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, nil, fmt.Errorf("tls-cert and tls-key go together")
+	}
+	if _, err := newLogger(io.Discard, cfg.LogFormat, cfg.LogLevel); err != nil {
+		return cfg, nil, err
+	}
+	if cfg.HTTPAddr != "" && !cfg.TLS() {
+		return cfg, nil, fmt.Errorf("http-addr redirects to HTTPS, so needs tls-cert or tls-self-signed")
+	}
+	return cfg, fs.Args(), nil
+}
+
+// TLS reports whether the server speaks HTTPS.
+func (c Config) TLS() bool {
+	return c.TLSCert != "" || c.TLSSelfSigned
+}
+
+// Dialect is the gorm dialect for the configured store.
+func (c Config) Dialect() string {
+	if c.Store == "sqlite" {
+		return "sqlite3"
+	}
+	return c.Store
+}
+
+// ConnectionString builds the driver's DSN from the db-* settings unless one
+// was given outright.
+func (c Config) ConnectionString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+
+	switch c.Store {
+	case "mssql":
+		port := c.DBPort
+		if port == 0 {
+			port = 1433
+		}
+		dsn := fmt.Sprintf("server=%s;port=%d;database=%s", c.DBHost, port, c.DBName)
+		if c.DBUser != "" {
+			dsn += fmt.Sprintf(";user id=%s;password=%s", c.DBUser, c.DBPassword)
+		}
+		return dsn
+	case "postgres":
+		port := c.DBPort
+		if port == 0 {
+			port = 5432
+		}
+		u := url.URL{
+			Scheme: "postgres",
+			Host:   fmt.Sprintf("%s:%d", c.DBHost, port),
+			Path:   "/" + c.DBName,
+		}
+		if c.DBUser != "" {
+			u.User = url.UserPassword(c.DBUser, c.DBPassword)
+		}
+		return u.String()
+	case "sqlite":
+		return c.DBName
+	}
+	return ""
+}
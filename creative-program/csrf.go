@@ -0,0 +1,79 @@
+package main
+
+/*
+	## Cross-Site Request Forgery
+	Browsers send the session cookie with any request to the app, even one
+	another site's form or <img> tag makes. So nothing changes on a GET:
+	marking, moving and deleting are POSTs, and routes() answers 405 to
+	GETs for them.
+
+	Every POST (or other method that changes things) from a logged in
+	browser must also carry its session's CSRF token, which another site
+	can't read. The pages put it in a hidden "csrf" field in each of their
+	forms; API clients get it as "csrf_token" from /api/v1/session and send
+	it back in an X-CSRF-Token header. Requests without it get 403
+	Forbidden.
+
+	| method              | needs a token                            |
+	| ------------------- | ---------------------------------------- |
+	| GET, HEAD, OPTIONS  | never; they don't change anything        |
+	| POST, PATCH, DELETE | when sent with a session cookie          |
+
+	The token is the session cookie signed again for this purpose, so each
+	login has its own, which lapses with it. Registering and logging in
+	happen before there's a session to take a token from, so they don't
+	need one.
+*/
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net/http"
+)
+
+const (
+	// csrfField is the form field pages send the token in.
+	csrfField = "csrf"
+	// csrfHeader is the header API clients send the token in.
+	csrfHeader = "X-CSRF-Token"
+)
+
+// errBadCSRF is returned for a change without its session's token.
+var errBadCSRF = errors.New("missing or stale CSRF token; reload the page and try again")
+
+// csrfToken is the token for the session in a session cookie's value.
+func csrfToken(session string) string {
+	return sign("csrf." + session)
+}
+
+// pageToken is the token for the request's session, for the forms on the
+// page it's answered with ("" if there's no session).
+func pageToken(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return csrfToken(c.Value)
+}
+
+// safeMethod reports whether requests with a method only read.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCSRF makes sure a request that changes things carries the token for
+// its session, in the header or the form.
+func checkCSRF(r *http.Request) error {
+	if safeMethod(r.Method) {
+		return nil
+	}
+	want := pageToken(r)
+	got := r.Header.Get(csrfHeader)
+	if got == "" {
+		got = r.PostFormValue(csrfField)
+	}
+	if want == "" || !hmac.Equal([]byte(got), []byte(want)) {
+		return errBadCSRF
+	}
+	return nil
+}
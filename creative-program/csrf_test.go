@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea, luis := loginAs(t, "Andrea", "Lam"), loginAs(t, "Luis", "Bosquez")
+		send := func(c *http.Cookie, method, path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(c)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		task, _ := store.FindTask(list.ID, "Do laundry")
+		mark := idPath(t, "mark", "Andrea's list", "Do laundry")
+		completed := func() bool {
+			task, _ := store.GetTask(task.ID)
+			return task.Completed
+		}
+
+		// a link or <img> tag can't change anything
+		for _, path := range []string{mark, idPath(t, "delete", "Andrea's list", "Do laundry"), idPath(t, "move", "Andrea's list"), idPath(t, "delete", "Andrea's list"), "/logout/"} {
+			if w := send(andrea, "GET", path, nil); w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("GET %s: %d", path, w.Code)
+			}
+		}
+
+		// nor can a form on another site, which can't know the token
+		for name, form := range map[string]url.Values{
+			"no token":        nil,
+			"wrong token":     {csrfField: {"forged"}},
+			"another's token": {csrfField: {csrfToken(luis.Value)}},
+		} {
+			if w := send(andrea, "POST", mark, form); w.Code != http.StatusForbidden {
+				t.Errorf("mark with %s: %d", name, w.Code)
+			}
+		}
+		if w := send(andrea, "POST", "/logout/", nil); w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+			t.Errorf("logout without token: %d", w.Code)
+		}
+		if completed() {
+			t.Fatal("a forged request marked the task")
+		}
+
+		// the page's own forms carry it
+		w := send(andrea, "GET", "/view/", nil)
+		field := fmt.Sprintf(`name=csrf value="%s"`, csrfToken(andrea.Value))
+		body := w.Body.String()
+		if strings.Count(body, field) < 5 || strings.Contains(body, `href="`+mark) || !strings.Contains(body, `formaction="`+mark) {
+			t.Errorf("view: %s", body)
+		}
+		if w := send(andrea, "POST", mark, url.Values{csrfField: {csrfToken(andrea.Value)}}); w.Code != http.StatusFound || !completed() {
+			t.Errorf("mark with token: %d", w.Code)
+		}
+		for _, path := range []string{idPath(t, "edit", "Andrea's list"), idPath(t, "share", "Andrea's list"), "/feeds/", "/import/", "/account/", "/webhooks/"} {
+			if w := send(andrea, "GET", path, nil); !strings.Contains(w.Body.String(), field) {
+				t.Errorf("GET %s has no token: %d %s", path, w.Code, w.Body)
+			}
+		}
+
+		// logging in needs no token, as there's no session to take it from
+		w = send(&http.Cookie{Name: "other", Value: "x"}, "POST", "/login/", url.Values{"first name": {"Andrea"}, "last name": {"Lam"}, "password": {"password"}})
+		if w.Code == http.StatusForbidden {
+			t.Errorf("login: %d", w.Code)
+		}
+		if w := send(andrea, "POST", "/logout/", url.Values{csrfField: {csrfToken(andrea.Value)}}); w.Code != http.StatusFound {
+			t.Errorf("logout with token: %d", w.Code)
+		}
+	})
+}
+
+func TestAPICSRF(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		call := func(method, path, body, token string, c *http.Cookie) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, path, strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			if token != "" {
+				r.Header.Set(csrfHeader, token)
+			}
+			if c != nil {
+				r.AddCookie(c)
+			}
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		w := call("POST", "/api/v1/session", `{"first_name": "Luis", "last_name": "Bosquez", "password": "password"}`, "", nil)
+		var login apiUser
+		json.Unmarshal(w.Body.Bytes(), &login)
+		if w.Code != http.StatusOK || login.CSRFToken == "" || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("login: %d %s", w.Code, w.Body)
+		}
+		session := w.Result().Cookies()[0]
+		lists := fmt.Sprintf("/api/v1/users/%d/lists", login.ID)
+
+		var who apiUser
+		w = call("GET", "/api/v1/session", "", "", session)
+		json.Unmarshal(w.Body.Bytes(), &who)
+		if who.CSRFToken != login.CSRFToken {
+			t.Errorf("session token %q, want %q", who.CSRFToken, login.CSRFToken)
+		}
+		if w := call("GET", lists, "", "", session); w.Code != http.StatusOK {
+			t.Errorf("reading without token: %d", w.Code)
+		}
+		if w := call("POST", lists, `{"title": "Forged"}`, "", session); w.Code != http.StatusForbidden {
+			t.Errorf("adding without token: %d", w.Code)
+		}
+		if w := call("POST", lists, `{"title": "Real"}`, login.CSRFToken, session); w.Code != http.StatusCreated {
+			t.Errorf("adding with token: %d %s", w.Code, w.Body)
+		}
+		if w := call("DELETE", "/api/v1/session", "", "", session); w.Code != http.StatusForbidden {
+			t.Errorf("logout without token: %d", w.Code)
+		}
+	})
+}
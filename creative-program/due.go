@@ -0,0 +1,122 @@
+package main
+
+/*
+	## Due Dates
+	A task's due date is optional. It is either a whole day (due by the end
+	of it) or a day and a time of day. Forms send the two parts separately
+	(from <input type=date> and <input type=time>); the JSON API joins them
+	like <input type=datetime-local> does:
+
+	| form date  | form time | API due_date     | due                     |
+	| ---------- | --------- | ---------------- | ----------------------- |
+	|            |           | ""               | never                   |
+	| 2020-04-01 |           | 2020-04-01       | by the end of April 1st |
+	| 2020-04-01 | 15:30     | 2020-04-01T15:30 | by 3:30 PM on April 1st |
+
+	Times are in the server's time zone.
+*/
+
+import (
+	"strings"
+	"time"
+)
+
+// layouts for the parts of a due date
+const (
+	dateFormat     = "2006-01-02"
+	clockFormat    = "15:04"
+	dateTimeFormat = dateFormat + "T" + clockFormat
+)
+
+// parseDue reads a due date written as "", a date, or a date and time.
+func parseDue(s string) (due *time.Time, hasTime bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false, nil
+	}
+	if t, err := time.ParseInLocation(dateFormat, s, time.Local); err == nil {
+		return &t, false, nil
+	}
+	if t, err := time.ParseInLocation(dateTimeFormat, s, time.Local); err == nil {
+		return &t, true, nil
+	}
+	return nil, false, badInput("due date must look like YYYY-MM-DD or YYYY-MM-DDTHH:MM")
+}
+
+// parseDueForm reads a due date from a form's separate date and time.
+func parseDueForm(date, clock string) (*time.Time, bool, error) {
+	date, clock = strings.TrimSpace(date), strings.TrimSpace(clock)
+	if date == "" && clock != "" {
+		return nil, false, badInput("a due time needs a due date")
+	}
+	if clock != "" {
+		date += "T" + clock
+	}
+	return parseDue(date)
+}
+
+// SetDue sets (or with "" clears) a task's due date.
+func (t *Task) SetDue(s string) error {
+	due, hasTime, err := parseDue(s)
+	if err != nil {
+		return err
+	}
+	t.DueDate, t.HasDueTime = due, hasTime
+	return nil
+}
+
+// DueString writes the due date the way parseDue reads it.
+func (t Task) DueString() string {
+	if t.DueDate == nil {
+		return ""
+	}
+	if t.HasDueTime {
+		return t.DueDate.Format(dateTimeFormat)
+	}
+	return t.DueDate.Format(dateFormat)
+}
+
+// DueDay is the date part of the due date (for <input type=date>).
+func (t Task) DueDay() string {
+	if t.DueDate == nil {
+		return ""
+	}
+	return t.DueDate.Format(dateFormat)
+}
+
+// DueClock is the time part of the due date (for <input type=time>).
+func (t Task) DueClock() string {
+	if t.DueDate == nil || !t.HasDueTime {
+		return ""
+	}
+	return t.DueDate.Format(clockFormat)
+}
+
+// Due describes the due date for people.
+func (t Task) Due() string {
+	if t.DueDate == nil {
+		return ""
+	}
+	if t.HasDueTime {
+		return t.DueDate.Format("Mon Jan 2, 2006 at 3:04 PM")
+	}
+	return t.DueDate.Format("Mon Jan 2, 2006")
+}
+
+// deadline is the moment a task becomes overdue.
+func (t Task) deadline() time.Time {
+	if t.HasDueTime {
+		return *t.DueDate
+	}
+	return t.DueDate.AddDate(0, 0, 1) // the end of the day
+}
+
+// OverdueAt reports whether an unfinished task's deadline has passed by now.
+func (t Task) OverdueAt(now time.Time) bool {
+	return !t.Completed && t.DueDate != nil && now.After(t.deadline())
+}
+
+// Overdue reports whether an unfinished task's deadline has passed.
+func (t Task) Overdue() bool {
+	return t.OverdueAt(time.Now())
+}
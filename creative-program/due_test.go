@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDue(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		hasTime bool
+		bad     bool
+	}{
+		{"", "", false, false},
+		{"2020-04-01", "2020-04-01", false, false},
+		{" 2020-04-01T15:30 ", "2020-04-01T15:30", true, false},
+		{"Not Today", "", false, true},
+		{"2020-02-30", "", false, true},
+		{"04/01/2020", "", false, true},
+	}
+	for _, test := range tests {
+		var task Task
+		err := task.SetDue(test.in)
+		if _, ok := err.(badInput); ok != test.bad {
+			t.Errorf("SetDue(%q) error = %v", test.in, err)
+			continue
+		}
+		if got := task.DueString(); got != test.want || task.HasDueTime != test.hasTime {
+			t.Errorf("SetDue(%q) = %q (time %t), want %q (time %t)", test.in, got, task.HasDueTime, test.want, test.hasTime)
+		}
+	}
+
+	if _, _, err := parseDueForm("", "15:30"); err == nil {
+		t.Error("a time without a date should be rejected")
+	}
+	if due, hasTime, err := parseDueForm("2020-04-01", "15:30"); err != nil || !hasTime || due.Hour() != 15 {
+		t.Errorf("parseDueForm = %v, %t, %v", due, hasTime, err)
+	}
+}
+
+func TestOverdue(t *testing.T) {
+	day := time.Date(2020, 4, 1, 0, 0, 0, 0, time.Local)
+	at := time.Date(2020, 4, 1, 15, 30, 0, 0, time.Local)
+	allDay := Task{DueDate: &day}
+	timed := Task{DueDate: &at, HasDueTime: true}
+
+	tests := []struct {
+		task Task
+		now  time.Time
+		want bool
+	}{
+		{allDay, day.Add(23 * time.Hour), false},
+		{allDay, day.AddDate(0, 0, 1).Add(time.Minute), true},
+		{timed, at.Add(-time.Minute), false},
+		{timed, at.Add(time.Minute), true},
+		{Task{}, at, false},
+		{Task{DueDate: &day, Completed: true}, day.AddDate(1, 0, 0), false},
+	}
+	for _, test := range tests {
+		if got := test.task.OverdueAt(test.now); got != test.want {
+			t.Errorf("%s due %s, at %s: overdue = %t", test.task.Title, test.task.DueString(), test.now, got)
+		}
+	}
+}
+
+func TestSortTasks(t *testing.T) {
+	early := time.Date(2020, 4, 1, 9, 0, 0, 0, time.Local)
+	day := time.Date(2020, 4, 1, 0, 0, 0, 0, time.Local)
+	later := time.Date(2020, 5, 1, 0, 0, 0, 0, time.Local)
+
+	tasks := []Task{
+		{Title: "undated"},
+		{Title: "May", DueDate: &later},
+		{Title: "April 1st", DueDate: &day},
+		{Title: "April 1st at 9", DueDate: &early, HasDueTime: true},
+	}
+	for i := range tasks {
+		tasks[i].ID = uint(i + 1)
+	}
+
+	sortTasks(tasks, "due")
+	want := []string{"April 1st at 9", "April 1st", "May", "undated"}
+	for i, title := range want {
+		if tasks[i].Title != title {
+			t.Fatalf("sorted by due = %v, want %v", tasks, want)
+		}
+	}
+
+	sortTasks(tasks, "")
+	if tasks[0].Title != "undated" {
+		t.Errorf("default sort should restore the order added: %v", tasks)
+	}
+}
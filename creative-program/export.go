@@ -0,0 +1,678 @@
+package main
+
+/*
+	## Import & Export
+	A user's own lists can be downloaded, for backups or for moving them to
+	another server, and loaded back in. Lists shared with the user belong to
+	someone else and are left out. There are two formats of its own, and
+	todo.txt (see todo.go):
+
+	JSON keeps everything, with steps nested under their tasks and the
+	user's tag colours alongside:
+
+		{
+		  "version": 1,
+		  "exported_at": "2020-04-01T15:30:00Z",
+		  "tags": [{"name": "home", "color": "#4a90d9"}],
+		  "lists": [{
+		    "title": "Andrea's list",
+		    "tasks": [{
+		      "title": "Do laundry",
+		      "details": "",
+		      "due_date": "2017-03-30",
+		      "repeat": "FREQ=WEEKLY",
+		      "completed": false,
+		      "priority": "high",
+		      "auto_complete": false,
+		      "tags": ["home"],
+		      "steps": []
+		    }]
+		  }]
+		}
+
+	CSV has a row for each task, and one with no task for each empty list,
+	for spreadsheets. Steps name their parent by title, after it:
+
+	| list          | task       | parent | details | due_date   | repeat      | completed | priority | auto_complete | tags |
+	| ------------- | ---------- | ------ | ------- | ---------- | ----------- | --------- | -------- | ------------- | ---- |
+	| Andrea's list | Do laundry |        |         | 2017-03-30 | FREQ=WEEKLY | false     | high     | false         | home |
+
+	Only the list and task columns are needed when importing, in any order.
+	Dates, repeat rules and priorities are written as in the JSON API.
+
+	Importing merges by default: lists are matched to the user's by title
+	and tasks to the list's by title, matches are overwritten with the
+	file's fields, and the rest are added at the end. Lists and tasks
+	missing from the file are left alone. Replacing deletes all of the
+	user's own lists first. A dry run checks the file and counts what would
+	change without changing anything, and the whole file is checked before
+	anything is written. Completed tasks are imported as they are, without
+	adding the next occurrence of repeating ones.
+*/
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the version of the JSON format written by export
+const exportVersion = 1
+
+// maxImport is the largest file import reads, in bytes.
+const maxImport = 10 << 20
+
+// columns of the CSV format, in the order export writes them
+var csvColumns = []string{"list", "task", "parent", "details", "due_date", "repeat", "completed", "priority", "auto_complete", "tags"}
+
+type (
+	// exportFile is the JSON format of a user's lists.
+	exportFile struct {
+		Version    int          `json:"version"`
+		ExportedAt time.Time    `json:"exported_at"`
+		Tags       []exportTag  `json:"tags"`
+		Lists      []exportList `json:"lists"`
+
+		// partial files (from todo.txt) only set some of a task's fields,
+		// so tasks keep their details, repeat rules and parents
+		partial bool
+	}
+
+	// exportTag is a tag in an exportFile.
+	exportTag struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	}
+
+	// exportList is a list in an exportFile.
+	exportList struct {
+		Title string       `json:"title"`
+		Tasks []exportTask `json:"tasks"`
+	}
+
+	// exportTask is a task in an exportFile, with its steps.
+	exportTask struct {
+		Title        string       `json:"title"`
+		Details      string       `json:"details"`
+		DueDate      string       `json:"due_date"` // see due.go
+		Repeat       string       `json:"repeat"`   // see recur.go
+		Completed    bool         `json:"completed"`
+		Priority     string       `json:"priority"` // see order.go
+		AutoComplete bool         `json:"auto_complete"`
+		Tags         []string     `json:"tags"`
+		Steps        []exportTask `json:"steps"`
+	}
+
+	// importSummary counts what an import changed, or would change.
+	importSummary struct {
+		DryRun       bool `json:"dry_run"`
+		ListsDeleted int  `json:"lists_deleted"`
+		ListsCreated int  `json:"lists_created"`
+		ListsMerged  int  `json:"lists_merged"`
+		TasksCreated int  `json:"tasks_created"`
+		TasksUpdated int  `json:"tasks_updated"`
+	}
+)
+
+// exportTasks nests the tasks with a parent, keeping their order.
+func exportTasks(tasks []Task, parent uint) []exportTask {
+	out := []exportTask{}
+	for _, t := range tasks {
+		if t.ParentID != parent {
+			continue
+		}
+		tags := []string{}
+		for _, tag := range t.Tags {
+			tags = append(tags, tag.Name)
+		}
+		out = append(out, exportTask{
+			Title:        t.Title,
+			Details:      t.Details,
+			DueDate:      t.DueString(),
+			Repeat:       t.Recurrence,
+			Completed:    t.Completed,
+			Priority:     t.PriorityName(),
+			AutoComplete: t.AutoComplete,
+			Tags:         tags,
+			Steps:        exportTasks(tasks, t.ID),
+		})
+	}
+	return out
+}
+
+// exportLists gathers the user's own lists, their tasks and the user's tags.
+func exportLists(user User) (exportFile, error) {
+	file := exportFile{Version: exportVersion, ExportedAt: time.Now().UTC(), Tags: []exportTag{}, Lists: []exportList{}}
+	tags, err := store.Tags(user.ID)
+	if err != nil {
+		return file, err
+	}
+	for _, tag := range tags {
+		file.Tags = append(file.Tags, exportTag{tag.Name, tag.Color})
+	}
+	lists, err := store.Lists(user.ID)
+	if err != nil {
+		return file, err
+	}
+	for _, list := range lists {
+		tasks, err := store.Tasks(list.ID)
+		if err == nil {
+			err = loadTags(user, tasks)
+		}
+		if err != nil {
+			return file, err
+		}
+		file.Lists = append(file.Lists, exportList{list.Title, exportTasks(tasks, 0)})
+	}
+	return file, nil
+}
+
+// writeCSV writes the lists in the CSV format.
+func writeCSV(w io.Writer, file exportFile) error {
+	out := csv.NewWriter(w)
+	out.Write(csvColumns)
+	var rows func(list, parent string, tasks []exportTask)
+	rows = func(list, parent string, tasks []exportTask) {
+		for _, t := range tasks {
+			out.Write([]string{
+				list, t.Title, parent, t.Details, t.DueDate, t.Repeat,
+				strconv.FormatBool(t.Completed), t.Priority,
+				strconv.FormatBool(t.AutoComplete), strings.Join(t.Tags, ", "),
+			})
+			rows(list, t.Title, t.Steps)
+		}
+	}
+	for _, l := range file.Lists {
+		if len(l.Tasks) == 0 {
+			out.Write([]string{l.Title, "", "", "", "", "", "", "", "", ""})
+		}
+		rows(l.Title, "", l.Tasks)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// readCSV reads lists written in the CSV format.
+func readCSV(r io.Reader) (exportFile, error) {
+	file := exportFile{Version: exportVersion}
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1 // short rows leave the rest blank
+	header, err := in.Read()
+	if err == io.EOF {
+		return file, badInput("the CSV file is empty")
+	} else if err != nil {
+		return file, badInput("bad CSV: " + err.Error())
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"list", "task"} {
+		if _, ok := col[name]; !ok {
+			return file, badInput(fmt.Sprintf("the CSV file has no %q column", name))
+		}
+	}
+
+	lists := map[string]*exportList{}
+	var order []string
+	for row := 2; ; row++ {
+		cells, err := in.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return file, badInput("bad CSV: " + err.Error())
+		}
+		cell := func(name string) string {
+			if i, ok := col[name]; ok && i < len(cells) {
+				return strings.TrimSpace(cells[i])
+			}
+			return ""
+		}
+		flag := func(name string) (bool, error) {
+			if s := cell(name); s != "" {
+				b, err := strconv.ParseBool(s)
+				if err != nil {
+					return false, badInput(name + " must be true or false")
+				}
+				return b, nil
+			}
+			return false, nil
+		}
+
+		title := cell("list")
+		list, ok := lists[title]
+		if !ok {
+			list = &exportList{Title: title}
+			lists[title] = list
+			order = append(order, title)
+		}
+		if cell("task") == "" {
+			continue // an empty list
+		}
+		task := exportTask{
+			Title:    cell("task"),
+			Details:  cell("details"),
+			DueDate:  cell("due_date"),
+			Repeat:   cell("repeat"),
+			Priority: cell("priority"),
+			Tags:     []string{},
+		}
+		if task.Completed, err = flag("completed"); err == nil {
+			task.AutoComplete, err = flag("auto_complete")
+		}
+		if err == nil {
+			task.Tags, err = parseTagNames(cell("tags"))
+		}
+		if err != nil {
+			return file, badInput(fmt.Sprintf("row %d: %s", row, err))
+		}
+		if parent := cell("parent"); parent == "" {
+			list.Tasks = append(list.Tasks, task)
+		} else if p := findStep(list.Tasks, parent); p != nil {
+			p.Steps = append(p.Steps, task)
+		} else {
+			return file, badInput(fmt.Sprintf("row %d: parent %q must come earlier in list %q", row, parent, title))
+		}
+	}
+	for _, title := range order {
+		file.Lists = append(file.Lists, *lists[title])
+	}
+	return file, nil
+}
+
+// findStep finds a task or step by title among tasks.
+func findStep(tasks []exportTask, title string) *exportTask {
+	for i := range tasks {
+		if tasks[i].Title == title {
+			return &tasks[i]
+		}
+		if t := findStep(tasks[i].Steps, title); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// readImport reads lists in the JSON, CSV or todo.txt format. If format
+// is "" it's guessed from the first character (todo.txt must be asked for).
+func readImport(user User, r io.Reader, format string) (exportFile, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxImport+1))
+	if err != nil {
+		return exportFile{}, err
+	}
+	if len(data) > maxImport {
+		return exportFile{}, badInput(fmt.Sprintf("files may be at most %d MB", maxImport>>20))
+	}
+	if format == "" {
+		format = "csv"
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			format = "json"
+		}
+	}
+
+	switch format {
+	case "csv":
+		return readCSV(bytes.NewReader(data))
+	case "todotxt":
+		items, err := readTodo(bytes.NewReader(data))
+		if err != nil {
+			return exportFile{}, err
+		}
+		return todoFile(user, items)
+	case "json":
+		var file exportFile
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&file); err != nil {
+			return file, badInput("bad JSON: " + err.Error())
+		}
+		for i := range file.Tags {
+			if file.Tags[i].Color == "" {
+				file.Tags[i].Color = defaultColor
+			}
+		}
+		if file.Version > exportVersion {
+			return file, badInput(fmt.Sprintf("the file is version %d; this server reads up to version %d", file.Version, exportVersion))
+		}
+		return file, nil
+	}
+	return exportFile{}, badInput("format must be json, csv or todotxt")
+}
+
+// fill sets a task's fields from the file, returning its tag names.
+func (t exportTask) fill(task *Task, partial bool) ([]string, error) {
+	task.Title = strings.TrimSpace(t.Title)
+	task.Completed = t.Completed
+	var err error
+	if task.Priority, err = parsePriority(t.Priority); err != nil {
+		return nil, err
+	}
+	err = task.SetDue(t.DueDate)
+	if !partial {
+		task.Details = t.Details
+		task.AutoComplete = t.AutoComplete
+		if err == nil {
+			err = task.SetRepeat(t.Repeat)
+		}
+	}
+	if err == nil {
+		err = task.Validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTagNames(strings.Join(t.Tags, ","))
+}
+
+// check validates everything in the file, so an import doesn't stop half
+// way through.
+func (file exportFile) check() error {
+	for _, tag := range file.Tags {
+		if err := (Tag{Name: tag.Name, Color: tag.Color}).Validate(); err != nil {
+			return badInput(fmt.Sprintf("tag %q: %s", tag.Name, err))
+		}
+	}
+	lists := map[string]bool{}
+	for _, l := range file.Lists {
+		title := strings.TrimSpace(l.Title)
+		if err := (TaskList{Title: title}).Validate(); err != nil {
+			return badInput(fmt.Sprintf("list %q: %s", l.Title, err))
+		}
+		if lists[title] {
+			return badInput(fmt.Sprintf("list %q appears twice", title))
+		}
+		lists[title] = true
+
+		tasks := map[string]bool{}
+		var walk func([]exportTask) error
+		walk = func(ts []exportTask) error {
+			for _, t := range ts {
+				var task Task
+				if _, err := t.fill(&task, file.partial); err != nil {
+					return badInput(fmt.Sprintf("list %q, task %q: %s", title, t.Title, err))
+				}
+				if tasks[task.Title] {
+					return badInput(fmt.Sprintf("list %q has task %q twice", title, task.Title))
+				}
+				tasks[task.Title] = true
+				if err := walk(t.Steps); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := walk(l.Tasks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importLists loads the file into the user's lists, merging them or
+// replacing them all. With dryRun set nothing is changed, but the summary
+// counts what would be.
+func importLists(user User, file exportFile, replace, dryRun bool) (importSummary, error) {
+	sum := importSummary{DryRun: dryRun}
+	if err := file.check(); err != nil {
+		return sum, err
+	}
+
+	if replace {
+		lists, err := store.Lists(user.ID)
+		if err != nil {
+			return sum, err
+		}
+		for _, list := range lists {
+			if !dryRun {
+				if err := store.DeleteList(list); err != nil {
+					return sum, err
+				}
+			}
+			sum.ListsDeleted++
+		}
+	}
+
+	// tags keep the file's colours, made now so tagTask finds them
+	for _, t := range file.Tags {
+		tag, err := store.FindTag(user.ID, t.Name)
+		if err == ErrNotFound {
+			tag = Tag{UserID: user.ID, Name: t.Name}
+		} else if err != nil {
+			return sum, err
+		}
+		if tag.Color == t.Color || dryRun {
+			continue
+		}
+		if tag.Color = t.Color; tag.ID == 0 {
+			err = store.CreateTag(&tag)
+		} else {
+			err = store.SaveTag(&tag)
+		}
+		if err != nil {
+			return sum, err
+		}
+	}
+
+	for _, l := range file.Lists {
+		list := TaskList{Title: strings.TrimSpace(l.Title), UserID: user.ID}
+		err := ErrNotFound
+		if !replace {
+			var found TaskList
+			if found, err = store.FindList(user.ID, list.Title); err == nil {
+				list = found
+			}
+		}
+		switch err {
+		case nil:
+			sum.ListsMerged++
+		case ErrNotFound:
+			sum.ListsCreated++
+			if !dryRun {
+				if err := store.CreateList(&list); err != nil {
+					return sum, err
+				}
+			}
+		default:
+			return sum, err
+		}
+		if err := importTasks(user, list, 0, l.Tasks, file.partial, &sum, dryRun); err != nil {
+			return sum, err
+		}
+	}
+	return sum, nil
+}
+
+// importTasks merges tasks into a list under a parent. Parents go before
+// their steps, so a step never ends up under itself.
+func importTasks(user User, list TaskList, parent uint, tasks []exportTask, partial bool, sum *importSummary, dryRun bool) error {
+	for _, t := range tasks {
+		task := Task{TaskListID: list.ID, ParentID: parent}
+		err := ErrNotFound
+		if list.ID != 0 {
+			var found Task
+			if found, err = store.FindTask(list.ID, strings.TrimSpace(t.Title)); err == nil {
+				task = found
+			}
+		}
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		names, ferr := t.fill(&task, partial)
+		if ferr != nil {
+			return ferr
+		}
+
+		if err == nil {
+			sum.TasksUpdated++
+			if !dryRun && !partial && task.ParentID != parent {
+				if err = checkParent(task, parent); err == nil {
+					task.ParentID = parent
+					task.Position, err = lastPosition(task)
+				}
+			}
+			if !dryRun && err == nil {
+				err = store.SaveTask(&task)
+			}
+		} else {
+			sum.TasksCreated++
+			err = nil
+			if !dryRun {
+				err = store.CreateTask(&task)
+			}
+		}
+		if err == nil && !dryRun {
+			err = tagTask(user, &task, names)
+		}
+		if err == nil {
+			err = importTasks(user, list, task.ID, t.Steps, partial, sum, dryRun)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseImportMode reads whether to merge (the default) or replace.
+func parseImportMode(s string) (replace bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "merge":
+		return false, nil
+	case "replace":
+		return true, nil
+	}
+	return false, badInput("mode must be merge or replace")
+}
+
+// writeExport sends the user's lists as a download in a format.
+func writeExport(w http.ResponseWriter, user User, format string, attach bool) error {
+	if format == "" {
+		format = "json"
+	}
+	ext := map[string]string{"json": "json", "csv": "csv", "todotxt": "txt"}[format]
+	if ext == "" {
+		return badInput("format must be json, csv or todotxt")
+	}
+	file, err := exportLists(user)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = writeCSV(&buf, file)
+	case "todotxt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var lists []TaskList
+		var items []todoItem
+		if lists, err = store.Lists(user.ID); err == nil {
+			items, err = todoItems(user, lists)
+		}
+		if err == nil {
+			err = writeTodo(&buf, items)
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(file)
+	}
+	if err != nil {
+		return err
+	}
+	if attach {
+		name := fmt.Sprintf("tasks-%s-%s.%s", user.FirstName, file.ExportedAt.Format(dateFormat), ext)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// exportHandler Sends the user's lists as a JSON (GET /export/), CSV
+// (?format=csv) or todo.txt (?format=todotxt) download.
+func exportHandler(w http.ResponseWriter, r *http.Request, user User) {
+	if err := writeExport(w, user, r.FormValue("format"), true); err != nil {
+		storeError(w, r, err)
+	}
+}
+
+// importPage is what import.html shows.
+type importPage struct {
+	Error   string
+	Replace bool
+	Summary *importSummary
+	CSRF    string // the session's token, for the form (see csrf.go)
+}
+
+// renderImport shows the import and export page.
+func renderImport(w http.ResponseWriter, r *http.Request, status int, page importPage) {
+	page.CSRF = pageToken(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "import.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// importHandler Shows the import and export page (GET /import/), or
+// imports an uploaded file (POST), only counting the changes if "preview"
+// is set. Shows the user what changed.
+func importHandler(w http.ResponseWriter, r *http.Request, user User) {
+	if r.Method != http.MethodPost {
+		renderImport(w, r, http.StatusOK, importPage{})
+		return
+	}
+
+	var page importPage
+	var err error
+	var file exportFile
+	page.Replace, err = parseImportMode(r.FormValue("mode"))
+	if err == nil {
+		f, header, ferr := r.FormFile("file")
+		if ferr == nil {
+			defer f.Close()
+			format := r.FormValue("format")
+			if format == "" {
+				switch name := strings.ToLower(header.Filename); {
+				case strings.HasSuffix(name, ".csv"):
+					format = "csv"
+				case strings.HasSuffix(name, ".txt"):
+					format = "todotxt"
+				}
+			}
+			file, err = readImport(user, f, format)
+		} else {
+			err = badInput("choose a file to import")
+		}
+	}
+	var sum importSummary
+	preview := r.FormValue("preview") != ""
+	before := audience(user) // a replace may delete lists others were in
+	if err == nil {
+		sum, err = importLists(user, file, page.Replace, preview)
+	}
+	if _, ok := err.(badInput); ok {
+		page.Error = err.Error()
+		renderImport(w, r, http.StatusBadRequest, page)
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if !preview {
+		refresh(append(before, audience(user)...)...)
+	}
+
+	page.Summary = &sum
+	renderImport(w, r, http.StatusOK, page)
+}
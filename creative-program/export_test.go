@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	file := exportFile{Lists: []exportList{
+		{Title: "Chores", Tasks: []exportTask{{
+			Title:    "Clean, then tidy",
+			Details:  "kitchen\n\"and\" hall",
+			DueDate:  "2020-04-01T15:30",
+			Priority: "high",
+			Tags:     []string{"home", "weekly"},
+			Steps:    []exportTask{{Title: "Sweep", Completed: true, Tags: []string{}}},
+		}}},
+		{Title: "Empty"},
+	}}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, file); err != nil {
+		t.Fatal("writeCSV: ", err)
+	}
+	got, err := readCSV(&buf)
+	if err != nil {
+		t.Fatal("readCSV: ", err)
+	}
+	if len(got.Lists) != 2 || got.Lists[1].Title != "Empty" || len(got.Lists[1].Tasks) != 0 {
+		t.Fatalf("lists = %+v", got.Lists)
+	}
+	task := got.Lists[0].Tasks[0]
+	if task.Title != "Clean, then tidy" || task.Details != file.Lists[0].Tasks[0].Details || task.DueDate != "2020-04-01T15:30" ||
+		strings.Join(task.Tags, ";") != "home;weekly" || len(task.Steps) != 1 || !task.Steps[0].Completed {
+		t.Errorf("task = %+v", task)
+	}
+
+	for _, bad := range []string{
+		"",
+		"title\nx\n",
+		"list,task,parent\nChores,Sweep,Clean\n",
+		"list,task,completed\nChores,Sweep,maybe\n",
+	} {
+		if _, err := readCSV(strings.NewReader(bad)); err == nil {
+			t.Errorf("readCSV(%q) should fail", bad)
+		}
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		luis := loginAs(t, "Luis", "Bosquez")
+		user, _ := store.FindUser("Luis", "Bosquez")
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{luis}}
+		list, _ := store.FindList(user.ID, "Luis's List")
+		tv, _ := store.FindTask(list.ID, "Watch TV")
+		c.call("POST", fmt.Sprintf("/api/v1/tasks/%d/subtasks", tv.ID), `{"title": "Find remote", "tags": ["home"]}`)
+
+		w := c.call("GET", fmt.Sprintf("/api/v1/users/%d/export", user.ID), "")
+		var file exportFile
+		json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&file)
+		if w.Code != http.StatusOK || len(file.Lists) != 2 || len(file.Lists[0].Tasks) != 2 || file.Lists[0].Tasks[1].Steps[0].Title != "Find remote" {
+			t.Fatalf("export: %d %s", w.Code, w.Body)
+		}
+		exported := w.Body.String()
+
+		// a dry run counts without changing anything
+		w = c.call("POST", fmt.Sprintf("/api/v1/users/%d/import?dry_run=true&mode=replace", user.ID), exported)
+		var sum importSummary
+		json.NewDecoder(w.Body).Decode(&sum)
+		if w.Code != http.StatusOK || sum != (importSummary{DryRun: true, ListsDeleted: 2, ListsCreated: 2, TasksCreated: 3}) {
+			t.Errorf("dry run: %d %+v", w.Code, sum)
+		}
+		if lists, _ := store.Lists(user.ID); len(lists) != 2 || lists[0].ID != list.ID {
+			t.Errorf("dry run changed lists: %v", lists)
+		}
+
+		// merging the export back changes nothing but counts every task
+		w = c.call("POST", fmt.Sprintf("/api/v1/users/%d/import", user.ID), exported)
+		json.NewDecoder(w.Body).Decode(&sum)
+		if w.Code != http.StatusOK || sum != (importSummary{ListsMerged: 2, TasksUpdated: 3}) {
+			t.Errorf("merge: %d %+v", w.Code, sum)
+		}
+		if tasks, _ := store.Tasks(list.ID); len(tasks) != 3 {
+			t.Errorf("tasks after merge: %v", tasks)
+		}
+
+		// replacing from CSV swaps everything out
+		csv := "list,task,parent,priority,tags\nNew list,Plan,,high,work\nNew list,Step,Plan,,\n"
+		w = c.call("POST", fmt.Sprintf("/api/v1/users/%d/import?mode=replace&format=csv", user.ID), csv)
+		json.NewDecoder(w.Body).Decode(&sum)
+		if w.Code != http.StatusOK || sum != (importSummary{ListsDeleted: 2, ListsCreated: 1, TasksCreated: 2}) {
+			t.Errorf("replace: %d %+v", w.Code, sum)
+		}
+		lists, _ := store.Lists(user.ID)
+		if len(lists) != 1 || lists[0].Title != "New list" {
+			t.Fatalf("lists after replace: %v", lists)
+		}
+		plan, _ := store.FindTask(lists[0].ID, "Plan")
+		step, _ := store.FindTask(lists[0].ID, "Step")
+		if tags, _ := taskTags(user, plan.ID); plan.Priority != highPriority || len(tags) != 1 || step.ParentID != plan.ID {
+			t.Errorf("imported tasks: %+v %+v %v", plan, step, tags)
+		}
+
+		// bad files are refused before anything is written
+		for _, bad := range []string{
+			`{"lists": [{"title": "a/b"}]}`,
+			`{"lists": [{"title": "L", "tasks": [{"title": "x"}, {"title": "x"}]}]}`,
+			`{"lists": [{"title": "L", "tasks": [{"title": "x", "due_date": "someday"}]}]}`,
+			`{"version": 99, "lists": []}`,
+			`{"lists": [], "extra": true}`,
+		} {
+			if w := c.call("POST", fmt.Sprintf("/api/v1/users/%d/import?mode=replace", user.ID), bad); w.Code != http.StatusBadRequest {
+				t.Errorf("import %s: %d", bad, w.Code)
+			}
+		}
+		if lists, _ := store.Lists(user.ID); len(lists) != 1 {
+			t.Errorf("a bad import changed lists: %v", lists)
+		}
+
+		// and through the pages
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/export/?format=csv", nil)
+		r.AddCookie(luis)
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "list,task,parent") || !strings.Contains(w.Header().Get("Content-Disposition"), ".csv") {
+			t.Errorf("export page: %d %v %s", w.Code, w.Header(), w.Body)
+		}
+
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "lists.csv")
+		part.Write([]byte("list,task\nNew list,Another\n"))
+		form.WriteField("preview", "Preview")
+		form.Close()
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("POST", "/import/", &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		withSession(r, luis)
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "add 1 tasks") {
+			t.Errorf("import preview: %d %s", w.Code, w.Body)
+		}
+		if _, err := store.FindTask(lists[0].ID, "Another"); err != ErrNotFound {
+			t.Errorf("preview added a task: %v", err)
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package main
+
+/*
+	## Calendar Feeds
+	Calendar apps can subscribe to a user's due tasks as an iCalendar
+	(RFC 5545) feed. The feed's URL holds a secret token instead of a
+	session, since calendar apps can't log in:
+
+	| feed                         | tasks                                      |
+	| ---------------------------- | ------------------------------------------ |
+	| /calendar/user-token.ics     | every due task in the user's lists, shared ones too |
+	| /calendar/list-token.ics     | every due task in one list                 |
+	| ...?as=todos                 | the same as to-dos instead of events       |
+
+	Tokens are made the first time the user opens /feeds, where the URLs are
+	listed. Anyone holding a URL can read the feed, so /feeds can also swap
+	a token for a new one, which stops the old URL working. Only a list's
+	owners see (and reset) its feed; other members get its tasks through
+	their own feed for as long as they stay in the list.
+
+	Feeds are made fresh from the store on every request. Each task is an
+	all-day event on its due date, or an instant at its due time, marked ✓
+	once finished. As to-dos, tasks carry their due date, status, priority
+	and parent task instead. Repeating tasks aren't given an RRULE, since
+	each occurrence is a task of its own (see recur.go).
+
+	Responses carry an ETag made from the feed, so a client polling often
+	gets 304 Not Modified until something changes. There's no Last-Modified,
+	since deleting a task doesn't leave anything newer behind.
+*/
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// formats for iCalendar dates and times
+const (
+	icalDate     = "20060102"
+	icalDateTime = "20060102T150405Z" // in UTC
+)
+
+// uidDomain ends each task's UID, making it unique across calendars.
+const uidDomain = "cs372-todo"
+
+// how long calendar apps may keep a feed before asking again
+const feedMaxAge = 5 * time.Minute
+
+// iCalendar priorities for the app's (see order.go); 0 means undefined
+var icalPriority = map[int]int{
+	noPriority:     0,
+	lowPriority:    9,
+	mediumPriority: 5,
+	highPriority:   1,
+}
+
+// newFeedToken makes a random, unguessable token for a feed's URL.
+func newFeedToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
+
+// userFeedToken gives the user a feed token if they don't have one, or a
+// new one if reset is set.
+func userFeedToken(user *User, reset bool) error {
+	if user.FeedToken != "" && !reset {
+		return nil
+	}
+	user.FeedToken = newFeedToken()
+	return store.SaveUser(user)
+}
+
+// listFeedToken gives the list a feed token if it doesn't have one, or a
+// new one if reset is set.
+func listFeedToken(list *TaskList, reset bool) error {
+	if list.FeedToken != "" && !reset {
+		return nil
+	}
+	list.FeedToken = newFeedToken()
+	return store.SaveList(list)
+}
+
+// icalText escapes a TEXT value.
+var icalText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "")
+
+// calendar writes an iCalendar stream.
+type calendar struct {
+	b strings.Builder
+}
+
+// line writes a content line, folding it so no line is longer than 75
+// bytes. Folds never split a UTF-8 character.
+func (c *calendar) line(name, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		c.b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74 // after the leading space
+	}
+	c.b.WriteString(line + "\r\n")
+}
+
+// text writes a line with a TEXT value.
+func (c *calendar) text(name, value string) {
+	c.line(name, icalText.Replace(value))
+}
+
+// due writes a task's due date as a date or a UTC time.
+func (c *calendar) due(name string, task Task) {
+	if task.HasDueTime {
+		c.line(name, task.DueDate.UTC().Format(icalDateTime))
+	} else {
+		c.line(name+";VALUE=DATE", task.DueDate.Format(icalDate))
+	}
+}
+
+// taskUID names a task in every feed it appears in.
+func taskUID(id uint) string {
+	return fmt.Sprintf("task-%d@%s", id, uidDomain)
+}
+
+// event writes a task as a VEVENT on its due date.
+func (c *calendar) event(task Task, list TaskList) {
+	c.line("BEGIN", "VEVENT")
+	c.common(task, list)
+	summary := task.Title
+	if task.Completed {
+		summary = "✓ " + summary
+	}
+	c.text("SUMMARY", summary)
+	c.due("DTSTART", task)
+	if !task.HasDueTime {
+		c.line("DTEND;VALUE=DATE", task.DueDate.AddDate(0, 0, 1).Format(icalDate))
+	}
+	c.line("TRANSP", "TRANSPARENT") // tasks don't make the user busy
+	c.line("END", "VEVENT")
+}
+
+// todo writes a task as a VTODO due on its due date.
+func (c *calendar) todo(task Task, list TaskList) {
+	c.line("BEGIN", "VTODO")
+	c.common(task, list)
+	c.text("SUMMARY", task.Title)
+	c.due("DUE", task)
+	if task.Completed {
+		c.line("STATUS", "COMPLETED")
+		c.line("COMPLETED", task.UpdatedAt.UTC().Format(icalDateTime))
+		c.line("PERCENT-COMPLETE", "100")
+	} else {
+		c.line("STATUS", "NEEDS-ACTION")
+	}
+	if p := icalPriority[task.Priority]; p != 0 {
+		c.line("PRIORITY", fmt.Sprint(p))
+	}
+	if task.ParentID != 0 {
+		c.line("RELATED-TO", taskUID(task.ParentID))
+	}
+	c.line("END", "VTODO")
+}
+
+// common writes the properties events and to-dos share.
+func (c *calendar) common(task Task, list TaskList) {
+	c.line("UID", taskUID(task.ID))
+	// the feed is regenerated each time, so stamp it with the task's last
+	// change rather than now, keeping the ETag steady
+	c.line("DTSTAMP", task.UpdatedAt.UTC().Format(icalDateTime))
+	c.line("LAST-MODIFIED", task.UpdatedAt.UTC().Format(icalDateTime))
+	if task.Details != "" {
+		c.text("DESCRIPTION", task.Details)
+	}
+	c.text("CATEGORIES", list.Title)
+}
+
+// writeCalendar makes a feed named name of the due tasks in lists.
+func writeCalendar(name string, lists []TaskList, todos bool) (string, error) {
+	var c calendar
+	c.line("BEGIN", "VCALENDAR")
+	c.line("VERSION", "2.0")
+	c.line("PRODID", "-//CS 372 Project//To-Do List//EN")
+	c.line("CALSCALE", "GREGORIAN")
+	c.text("X-WR-CALNAME", name)
+	for _, list := range lists {
+		tasks, err := store.Tasks(list.ID)
+		if err != nil {
+			return "", err
+		}
+		for _, task := range tasks {
+			switch {
+			case task.DueDate == nil:
+			case todos:
+				c.todo(task, list)
+			default:
+				c.event(task, list)
+			}
+		}
+	}
+	c.line("END", "VCALENDAR")
+	return c.b.String(), nil
+}
+
+// feedCalendar finds the feed with a token: a user's or a list's.
+func feedCalendar(token string, todos bool) (string, error) {
+	if user, err := store.FindUserByFeed(token); err != ErrNotFound {
+		if err != nil {
+			return "", err
+		}
+		lists, err := userLists(user)
+		if err != nil {
+			return "", err
+		}
+		return writeCalendar("Tasks for "+userName(user), lists, todos)
+	}
+	list, err := store.FindListByFeed(token)
+	if err != nil {
+		return "", err
+	}
+	return writeCalendar(list.Title, []TaskList{list}, todos)
+}
+
+// calendarHandler Serves the feed for a token (GET /calendar/token.ics). It
+// needs no session; the token is the password.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := feedCalendar(pathParam(r, "token"), r.FormValue("as") == "todos")
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(feedMaxAge.Seconds())))
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	// ServeContent answers If-None-Match with 304 and handles HEAD
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+}
+
+type (
+	// feed is a feed's URLs as feeds.html shows them.
+	feed struct {
+		Title  string
+		List   string // "" for the user's own feed
+		URL    string
+		Webcal template.URL // the same, for calendar apps to open
+	}
+
+	// feedsPage is what feeds.html shows.
+	feedsPage struct {
+		CSRF  string // the session's token, for the forms (see csrf.go)
+		Feeds []feed
+	}
+)
+
+// feedURLs makes the URLs for a feed token, on the host the user asked.
+func feedURLs(r *http.Request, f feed, token string) feed {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: "/calendar/" + token + ".ics"}
+	f.URL = u.String()
+	u.Scheme = "webcal"
+	f.Webcal = template.URL(u.String()) // html/template distrusts webcal:
+	return f
+}
+
+// feedsHandler Shows the user's calendar feeds (GET /feeds/), making any
+// missing tokens, or resets the token of the user's feed or a list's
+// (POST, with "list" set to its title for a list).
+// Redirects user to the updated feeds.
+func feedsHandler(w http.ResponseWriter, r *http.Request, user User) {
+	if r.Method == http.MethodPost {
+		var err error
+		if title := r.FormValue("list"); title != "" {
+			var list TaskList
+			if list, err = userListByTitle(user, title, ownerAccess); err == nil {
+				err = listFeedToken(&list, true)
+			}
+		} else {
+			err = userFeedToken(&user, true)
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/feeds/", http.StatusFound)
+		return
+	}
+
+	lists, err := userLists(user)
+	if err == nil {
+		err = userFeedToken(&user, false)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	// feeds.html shows the user's feed then those of the lists they own
+	page := feedsPage{CSRF: pageToken(r)}
+	page.Feeds = []feed{feedURLs(r, feed{Title: "All my tasks"}, user.FeedToken)}
+	for _, list := range lists {
+		if have, err := listAccess(user, list); err != nil || have < ownerAccess {
+			continue
+		}
+		if err := listFeedToken(&list, false); err != nil {
+			storeError(w, r, err)
+			return
+		}
+		page.Feeds = append(page.Feeds, feedURLs(r, feed{Title: list.Title, List: list.Title}, list.FeedToken))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "feeds.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarLines(t *testing.T) {
+	var c calendar
+	c.text("SUMMARY", "Buy milk, eggs; and\nflour \\ sugar")
+	if got := c.b.String(); got != `SUMMARY:Buy milk\, eggs\; and\nflour \\ sugar`+"\r\n" {
+		t.Errorf("escaped = %q", got)
+	}
+
+	c = calendar{}
+	c.text("DESCRIPTION", strings.Repeat("é", 100))
+	lines := strings.Split(strings.TrimSuffix(c.b.String(), "\r\n"), "\r\n")
+	unfolded := ""
+	for i, l := range lines {
+		if len(l) > 75 {
+			t.Errorf("line %d is %d bytes", i, len(l))
+		}
+		if i > 0 {
+			if !strings.HasPrefix(l, " ") {
+				t.Errorf("line %d isn't a continuation: %q", i, l)
+			}
+			l = l[1:]
+		}
+		unfolded += l
+	}
+	if unfolded != "DESCRIPTION:"+strings.Repeat("é", 100) {
+		t.Errorf("unfolded = %q", unfolded)
+	}
+}
+
+func TestCalendarFeeds(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea := loginAs(t, "Andrea", "Lam")
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		due, _ := time.ParseInLocation(dateTimeFormat, "2020-04-01T15:30", time.Local)
+		store.CreateTask(&Task{Title: "Call mum", Details: "about Sunday", DueDate: &due, HasDueTime: true, Completed: true, Priority: highPriority, TaskListID: list.ID})
+		store.CreateTask(&Task{Title: "Some day", TaskListID: list.ID})
+		get := func(path string, c *http.Cookie, header http.Header) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", path, nil)
+			for k, v := range header {
+				r.Header[k] = v
+			}
+			if c != nil {
+				r.AddCookie(c)
+			}
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		// the feeds page makes the tokens
+		if w := get("/feeds/", andrea, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "webcal://example.com/calendar/") {
+			t.Fatalf("feeds page: %d %s", w.Code, w.Body)
+		}
+		user, _ = store.GetUser(user.ID)
+		list, _ = store.GetList(list.ID)
+		if user.FeedToken == "" || list.FeedToken == "" || user.FeedToken == list.FeedToken {
+			t.Fatalf("tokens %q, %q", user.FeedToken, list.FeedToken)
+		}
+
+		w := get("/calendar/"+user.FeedToken+".ics", nil, nil)
+		body := w.Body.String()
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/calendar; charset=utf-8" || w.Header().Get("ETag") == "" {
+			t.Fatalf("user feed: %d %v", w.Code, w.Header())
+		}
+		for _, want := range []string{
+			"BEGIN:VCALENDAR\r\n",
+			"X-WR-CALNAME:Tasks for Andrea Lam\r\n",
+			"SUMMARY:Do laundry\r\nDTSTART;VALUE=DATE:20170330\r\nDTEND;VALUE=DATE:20170331\r\n",
+			"SUMMARY:✓ Call mum\r\nDTSTART:" + due.UTC().Format(icalDateTime) + "\r\n",
+			"DESCRIPTION:about Sunday\r\nCATEGORIES:Andrea's list\r\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("user feed lacks %q:\n%s", want, body)
+			}
+		}
+		if strings.Contains(body, "Some day") || strings.Contains(body, "Mow the lawn") {
+			t.Errorf("user feed has tasks it shouldn't:\n%s", body)
+		}
+
+		// polling again without changes gets nothing new
+		if w := get("/calendar/"+user.FeedToken+".ics", nil, http.Header{"If-None-Match": {w.Header().Get("ETag")}}); w.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match: %d", w.Code)
+		}
+
+		body = get("/calendar/"+list.FeedToken+".ics?as=todos", nil, nil).Body.String()
+		for _, want := range []string{
+			"X-WR-CALNAME:Andrea's list\r\n",
+			"BEGIN:VTODO\r\n",
+			"DUE;VALUE=DATE:20170330\r\nSTATUS:NEEDS-ACTION\r\n",
+			"STATUS:COMPLETED\r\n",
+			"PRIORITY:1\r\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("list feed lacks %q:\n%s", want, body)
+			}
+		}
+
+		if w := get("/calendar/nope.ics", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("bad token: %d", w.Code)
+		}
+
+		// resetting a token retires the old URL
+		old := list.FeedToken
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/feeds/", strings.NewReader(url.Values{"list": {"Andrea's list"}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		withSession(r, andrea)
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("reset: %d %s", w.Code, w.Body)
+		}
+		if w := get("/calendar/"+old+".ics", nil, nil); w.Code != http.StatusNotFound {
+			t.Errorf("old token: %d", w.Code)
+		}
+
+		// other users can't reset Andrea's list
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("POST", "/feeds/", strings.NewReader(url.Values{"list": {"Andrea's list"}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		withSession(r, loginAs(t, "Luis", "Bosquez"))
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("reset by another user: %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+/*
+	## Live Updates
+	Open /view pages follow changes made in other tabs, through the API, or
+	by the other people in a shared list, without being reloaded. Each page
+	listens on /events, a stream of server-sent events for the logged in
+	user, one per change to something they can see:
+
+		data: {"event": "task.completed", "list": 3, "task": 12}
+
+	Changes to lists and tasks use the webhook events (see webhook.go), and
+	anything else that changes the page, like sharing, tags or an import,
+	sends a "refresh" with no list. The page then fetches itself again and
+	swaps in the list that changed, or all of them, leaving alone a list the
+	user is typing into until they're done.
+
+	Events come from an in-process hub, so they reach pages served by the
+	same server: todo.txt syncs, run as their own command, show up on the
+	next reload. A page that falls too far behind is dropped, and reloads
+	everything when its browser reconnects.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	liveBuffer    = 16               // events a page can fall behind by
+	liveKeepAlive = 25 * time.Second // between comments on a quiet stream
+	liveRetry     = 3000             // milliseconds before browsers reconnect
+)
+
+type (
+	// liveEvent is a change, as the event stream tells pages about it.
+	liveEvent struct {
+		Event string `json:"event"`
+		List  uint   `json:"list,omitempty"`
+		Task  uint   `json:"task,omitempty"`
+	}
+
+	// liveHub passes events on to the pages of the users they're for.
+	liveHub struct {
+		mu   sync.Mutex
+		subs map[uint]map[chan liveEvent]bool // by user ID
+	}
+)
+
+// live is the server's hub.
+var live = newLiveHub()
+
+func newLiveHub() *liveHub {
+	return &liveHub{subs: map[uint]map[chan liveEvent]bool{}}
+}
+
+// subscribe starts passing a user's events to a new channel. The channel
+// is closed by unsubscribe, or if it falls behind.
+func (h *liveHub) subscribe(userID uint) (ch chan liveEvent, unsubscribe func()) {
+	ch = make(chan liveEvent, liveBuffer)
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[chan liveEvent]bool{}
+	}
+	h.subs[userID][ch] = true
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.drop(userID, ch)
+	}
+}
+
+// drop closes and forgets a subscription, if it's still there. The caller
+// holds h.mu.
+func (h *liveHub) drop(userID uint, ch chan liveEvent) {
+	if !h.subs[userID][ch] {
+		return
+	}
+	delete(h.subs[userID], ch)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+	close(ch)
+}
+
+// publish passes an event to each user's pages without waiting on them.
+// Pages too far behind to take it are dropped.
+func (h *liveHub) publish(e liveEvent, userIDs ...uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := map[uint]bool{}
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		for ch := range h.subs[id] {
+			select {
+			case ch <- e:
+			default:
+				h.drop(id, ch)
+			}
+		}
+	}
+}
+
+// refresh tells users' pages to reload all their lists, for changes that
+// aren't to one list or task.
+func refresh(userIDs ...uint) {
+	live.publish(liveEvent{Event: "refresh"}, userIDs...)
+}
+
+// refreshList tells everyone in a list to reload their pages, and anyone
+// else given, such as a member just removed or invited.
+func refreshList(list TaskList, also ...uint) {
+	ids, err := listUsers(list)
+	if err != nil {
+		slog.Error("failed to find who to refresh", "list", list.ID, "err", err)
+	}
+	refresh(append(ids, also...)...)
+}
+
+// refreshMember tells everyone in a membership's list, and the member, to
+// reload their pages.
+func refreshMember(m Member) {
+	list, err := store.GetList(m.TaskListID)
+	if err != nil {
+		slog.Error("failed to find who to refresh", "list", m.TaskListID, "err", err)
+		refresh(m.UserID)
+		return
+	}
+	refreshList(list, m.UserID)
+}
+
+// audience is everyone who can see one of the user's lists: the user, and
+// the others in the lists they own or belong to.
+func audience(user User) []uint {
+	ids := []uint{user.ID}
+	lists, err := userLists(user)
+	for i := 0; err == nil && i < len(lists); i++ {
+		var in []uint
+		in, err = listUsers(lists[i])
+		ids = append(ids, in...)
+	}
+	if err != nil {
+		slog.Error("failed to find who to refresh", "user", user.ID, "err", err)
+	}
+	return ids
+}
+
+// eventsHandler Streams the user's live events (GET /events/) until they
+// leave the page.
+func eventsHandler(w http.ResponseWriter, r *http.Request, user User) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := live.subscribe(user.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // for proxies that would hold it back
+	fmt.Fprintf(w, "retry: %d\n\n", liveRetry)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return // fell behind; the page reloads when it reconnects
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": still here\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
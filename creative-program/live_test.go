@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveHub(t *testing.T) {
+	h := newLiveHub()
+	a, unsubscribe := h.subscribe(1)
+	b, _ := h.subscribe(2)
+
+	h.publish(liveEvent{Event: "refresh"}, 1, 1, 3)
+	if len(a) != 1 || len(b) != 0 {
+		t.Errorf("queued %d for user 1 and %d for user 2", len(a), len(b))
+	}
+	if e := <-a; e.Event != "refresh" {
+		t.Errorf("got %+v", e)
+	}
+
+	// a page that falls behind is dropped rather than waited on
+	for i := 0; i <= liveBuffer; i++ {
+		h.publish(liveEvent{Event: "task.updated", List: 1, Task: uint(i)}, 2)
+	}
+	n := 0
+	for range b {
+		n++
+	}
+	if n != liveBuffer {
+		t.Errorf("behind page got %d events, want %d", n, liveBuffer)
+	}
+	if _, ok := h.subs[2]; ok {
+		t.Error("dropped page still subscribed")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-a; ok {
+		t.Error("channel open after unsubscribing")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		srv := httptest.NewServer(testServer(t))
+		defer srv.Close()
+		andrea, luis := loginAs(t, "Andrea", "Lam"), loginAs(t, "Luis", "Bosquez")
+		send := func(c *http.Cookie, method, path string, form url.Values) {
+			req, _ := http.NewRequest(method, srv.URL+(&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(req, c)
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		task, _ := store.FindTask(list.ID, "Do laundry")
+		luisUser, _ := store.FindUser("Luis", "Bosquez")
+		store.CreateMember(&Member{TaskListID: list.ID, UserID: luisUser.ID, Role: "editor", Accepted: true})
+
+		req, _ := http.NewRequest("GET", srv.URL+"/events/", nil)
+		req.AddCookie(andrea)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+			t.Fatalf("events: %d %s", resp.StatusCode, ct)
+		}
+
+		lines := make(chan string, liveBuffer)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if line := scanner.Text(); line != "" {
+					lines <- line
+				}
+			}
+			close(lines)
+		}()
+		next := func() string {
+			t.Helper()
+			select {
+			case line := <-lines:
+				return line
+			case <-time.After(5 * time.Second):
+				t.Fatal("no event")
+				return ""
+			}
+		}
+		if line := next(); line != fmt.Sprintf("retry: %d", liveRetry) {
+			t.Errorf("first line %q", line)
+		}
+
+		// Luis's change shows up on Andrea's page
+		send(luis, "POST", idPath(t, "mark", "Andrea's list", "Do laundry"), nil)
+		var e liveEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(next(), "data: ")), &e); err != nil || e != (liveEvent{"task.completed", list.ID, task.ID}) {
+			t.Errorf("after marking: %+v, %v", e, err)
+		}
+		// ...along with the next occurrence of the weekly task
+		if line := next(); !strings.Contains(line, `"event":"task.created"`) {
+			t.Errorf("after marking: %q", line)
+		}
+
+		send(andrea, "POST", idPath(t, "move", "Andrea's list"), url.Values{"to": {"down"}})
+		if line := next(); line != fmt.Sprintf(`data: {"event":"list.updated","list":%d}`, list.ID) {
+			t.Errorf("after moving: %q", line)
+		}
+		send(luis, "POST", "/add/", url.Values{"list title": {"Luis's secrets"}})
+		send(andrea, "POST", "/add/", url.Values{"list title": {"Garden"}})
+		if line := next(); !strings.Contains(line, `"event":"list.created"`) {
+			t.Errorf("after adding a list: %q (heard about Luis's own?)", line)
+		}
+		send(andrea, "POST", idPath(t, "share", "Andrea's list"), url.Values{"member": {"999"}, "remove": {"on"}})
+		send(andrea, "POST", idPath(t, "share", "Garden"), url.Values{"first name": {"Meet"}, "last name": {"Bhagdev"}, "role": {"viewer"}})
+		if line := next(); line != `data: {"event":"refresh"}` {
+			t.Errorf("after inviting: %q", line)
+		}
+	})
+}
+
+func TestViewListIDs(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/view/", nil)
+		r.AddCookie(loginAs(t, "Andrea", "Lam"))
+		mux.ServeHTTP(w, r)
+
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		body := w.Body.String()
+		if !strings.Contains(body, fmt.Sprintf(`id="list-%d"`, list.ID)) || !strings.Contains(body, `new EventSource("/events/")`) {
+			t.Errorf("view: %s", body)
+		}
+	})
+}
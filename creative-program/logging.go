@@ -0,0 +1,238 @@
+package main
+
+/*
+	## Logging
+	Everything the server logs goes through log/slog as one line per event,
+	in logfmt (-log-format text, the default) or JSON (-log-format json),
+	with a time, a level and the event's fields:
+
+		time=2020-04-01T09:30:00.000Z level=INFO msg=request request_id=3f9c0a1b2d4e5f60 method=POST path=/tasks/12/mark status=302 bytes=0 duration_ms=4.2 user=7
+
+	| level | logged                                                     |
+	| ----- | ---------------------------------------------------------- |
+	| DEBUG | handler detail, and every SQL statement the stores run     |
+	| INFO  | each request once answered, startup, registrations         |
+	| WARN  | settings that leave the server less safe or less useful    |
+	| ERROR | 5xx answers with the error behind them, store and database |
+	|       | errors, failed reminders and webhook queueing              |
+
+	-log-level picks the lowest level written (info by default).
+
+	Each request gets an ID, taken from its X-Request-ID header if a proxy
+	in front set a sensible one, or made up otherwise. It's sent back in
+	X-Request-ID and is on every line logged about the request, so a user's
+	report of an error can be matched to what the server saw.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries a request's ID in and out.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID is what an ID from a client must look like to be kept.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type (
+	// logKey finds a request's logger in its context.
+	logKey struct{}
+
+	// accessRecord is a response as the access log sees it. Handlers add
+	// to it through noteUser and noteError.
+	accessRecord struct {
+		http.ResponseWriter
+		status int
+		bytes  int
+		user   uint
+		err    error
+	}
+
+	// gormLogger passes gorm's logging on to slog.
+	gormLogger struct {
+		log *slog.Logger
+	}
+)
+
+// parseLogLevel reads a -log-level setting.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// newLogger makes a logger writing to w in a format ("text" or "json"),
+// leaving out anything below level.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+}
+
+// setupLogging sends all logging, including the log package's, to
+// standard error as configured.
+func setupLogging(cfg Config) error {
+	logger, err := newLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// fatal logs an error the server can't carry on from, and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// newRequestID makes a random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLog is the logger for a request, which adds its ID to each line.
+func requestLog(r *http.Request) *slog.Logger {
+	if l, ok := r.Context().Value(logKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// logRequests gives each request an ID and logger, and logs it once it's
+// been answered.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		logger := slog.Default().With("request_id", id)
+		rec := &accessRecord{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), logKey{}, logger)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote", r.RemoteAddr,
+		}
+		if rec.user != 0 {
+			attrs = append(attrs, "user", rec.user)
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		if rec.err != nil {
+			attrs = append(attrs, "err", rec.err)
+		}
+		logger.Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+func (a *accessRecord) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessRecord) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += n
+	return n, err
+}
+
+// Flush passes on flushes, for /events (see live.go).
+func (a *accessRecord) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (a *accessRecord) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// noteUser puts the logged in user on a request's access log line.
+func noteUser(w http.ResponseWriter, user User) {
+	if a, ok := w.(*accessRecord); ok {
+		a.user = user.ID
+	}
+}
+
+// noteError puts the error behind a failed request on its access log
+// line, or logs it on its own if there's no access log.
+func noteError(w http.ResponseWriter, err error) {
+	if a, ok := w.(*accessRecord); ok {
+		a.err = err
+		return
+	}
+	slog.Error("request failed", "err", err)
+}
+
+// Print logs one of gorm's messages: "sql" for a statement (only in
+// LogMode(true)), "error" or "log" for a database error.
+func (g gormLogger) Print(v ...interface{}) {
+	if len(v) < 2 {
+		g.log.Info(fmt.Sprint(v...))
+		return
+	}
+	kind, source := v[0], v[1]
+	switch {
+	case kind == "sql" && len(v) >= 6:
+		g.log.Debug("sql", "source", source, "duration_ms", durationMillis(v[2]), "query", strings.Join(strings.Fields(fmt.Sprint(v[3])), " "), "rows", v[5])
+	default:
+		for _, x := range v[2:] {
+			if err, ok := x.(error); ok {
+				g.log.Error("database error", "source", source, "err", err)
+				return
+			}
+		}
+		g.log.Info("database", "source", source, "msg", fmt.Sprint(v[2:]...))
+	}
+}
+
+// durationMillis is a time.Duration from gorm in milliseconds.
+func durationMillis(v interface{}) float64 {
+	d, _ := v.(time.Duration)
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs sends logging to a buffer as JSON for the rest of a test,
+// and returns a function to read the lines back.
+func captureLogs(t *testing.T) func() []map[string]interface{} {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	return func() []map[string]interface{} {
+		var lines []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var m map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				t.Fatalf("not JSON: %q", line)
+			}
+			lines = append(lines, m)
+		}
+		return lines
+	}
+}
+
+// accessLine finds the access log line for a request ID.
+func accessLine(lines []map[string]interface{}, id string) map[string]interface{} {
+	for _, l := range lines {
+		if l["msg"] == "request" && l["request_id"] == id {
+			return l
+		}
+	}
+	return nil
+}
+
+func TestLogRequests(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		c := loginAs(t, "Andrea", "Lam")
+		logs := captureLogs(t)
+		user, _ := store.FindUser("Andrea", "Lam")
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/view/", nil)
+		r.Header.Set(requestIDHeader, "from-proxy.1")
+		r.AddCookie(c)
+		mux.ServeHTTP(w, r)
+		if got := w.Header().Get(requestIDHeader); got != "from-proxy.1" {
+			t.Errorf("%s = %q", requestIDHeader, got)
+		}
+		line := accessLine(logs(), "from-proxy.1")
+		if line == nil || line["level"] != "INFO" || line["method"] != "GET" || line["path"] != "/view/" ||
+			line["status"] != 200.0 || line["user"] != float64(user.ID) || line["bytes"] != float64(w.Body.Len()) {
+			t.Errorf("access line: %v", line)
+		}
+
+		// an unlikely ID is replaced with one of our own
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/welcome/", nil)
+		r.Header.Set(requestIDHeader, "bad id\n")
+		mux.ServeHTTP(w, r)
+		id := w.Header().Get(requestIDHeader)
+		if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) {
+			t.Fatalf("%s = %q", requestIDHeader, id)
+		}
+		if line := accessLine(logs(), id); line == nil || line["user"] != nil {
+			t.Errorf("access line: %v", line)
+		}
+	})
+}
+
+func TestLogErrors(t *testing.T) {
+	logs := captureLogs(t)
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLog(r).Debug("about to fail")
+		apiStoreError(w, errors.New("disk full"))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/lists", nil))
+
+	id := w.Header().Get(requestIDHeader)
+	lines := logs()
+	if len(lines) != 2 || lines[0]["msg"] != "about to fail" || lines[0]["request_id"] != id {
+		t.Fatalf("lines: %v", lines)
+	}
+	if line := lines[1]; line["level"] != "ERROR" || line["status"] != 500.0 || line["err"] != "disk full" {
+		t.Errorf("access line: %v", line)
+	}
+}
+
+func TestAccessRecordFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &accessRecord{ResponseWriter: w}
+	rec.Write([]byte("data: x\n\n"))
+	rec.Flush()
+	if !w.Flushed || rec.status != http.StatusOK || rec.bytes != 9 {
+		t.Errorf("flushed %v, status %d, bytes %d", w.Flushed, rec.status, rec.bytes)
+	}
+}
+
+func TestGormLogger(t *testing.T) {
+	logs := captureLogs(t)
+	g := gormLogger{slog.Default()}
+	g.Print("sql", "store.go:10", 3*time.Millisecond, "SELECT *\n\tFROM users  WHERE id = ?", []interface{}{7}, int64(1))
+	g.Print("error", "store.go:20", errors.New("no such table: users"))
+
+	lines := logs()
+	if len(lines) != 2 {
+		t.Fatalf("lines: %v", lines)
+	}
+	if l := lines[0]; l["level"] != "DEBUG" || l["query"] != "SELECT * FROM users WHERE id = ?" || l["duration_ms"] != 3.0 || l["rows"] != 1.0 {
+		t.Errorf("sql: %v", l)
+	}
+	if l := lines[1]; l["level"] != "ERROR" || l["source"] != "store.go:20" || l["err"] != "no such table: users" {
+		t.Errorf("error: %v", l)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	for _, c := range [][2]string{{"xml", "info"}, {"text", "loud"}} {
+		if _, err := newLogger(nil, c[0], c[1]); err == nil {
+			t.Errorf("%q: expected an error", c)
+		}
+	}
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("left out")
+	logger.Warn("kept", "user", 7)
+	if out := buf.String(); strings.Contains(out, "left out") || !strings.Contains(out, "level=WARN msg=kept user=7") {
+		t.Errorf("logged %q", out)
+	}
+}
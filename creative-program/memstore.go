@@ -0,0 +1,580 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// memStore keeps the model in memory. It is safe for concurrent use.
+type memStore struct {
+	mu      sync.Mutex
+	nextID  uint
+	users   map[uint]User
+	lists   map[uint]TaskList
+	tasks   map[uint]Task
+	tags    map[uint]Tag
+	tagged  map[taskTag]bool
+	members map[uint]Member
+	hooks   map[uint]Webhook
+	sent    map[uint]Delivery
+}
+
+// newMemStore makes an empty in-memory store.
+func newMemStore() *memStore {
+	return &memStore{
+		users:   make(map[uint]User),
+		lists:   make(map[uint]TaskList),
+		tasks:   make(map[uint]Task),
+		tags:    make(map[uint]Tag),
+		tagged:  make(map[taskTag]bool),
+		members: make(map[uint]Member),
+		hooks:   make(map[uint]Webhook),
+		sent:    make(map[uint]Delivery),
+	}
+}
+
+// stamp gives a new record an ID and creation time.
+func (s *memStore) stamp(m *gorm.Model) {
+	s.nextID++
+	now := time.Now()
+	m.ID, m.CreatedAt, m.UpdatedAt = s.nextID, now, now
+}
+
+func (s *memStore) GetUser(id uint) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[id]; ok {
+		return u, nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memStore) FindUser(first, last string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.FirstName == first && u.LastName == last {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&user.Model)
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *memStore) SaveUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+	user.UpdatedAt = time.Now()
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *memStore) FindUserByFeed(token string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if token != "" && u.FeedToken == token {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memStore) GetList(id uint) (TaskList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.lists[id]; ok {
+		return l, nil
+	}
+	return TaskList{}, ErrNotFound
+}
+
+func (s *memStore) Lists(userID uint) ([]TaskList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lists []TaskList
+	for _, l := range s.lists {
+		if l.UserID == userID {
+			lists = append(lists, l)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		a, b := lists[i], lists[j]
+		return a.Position < b.Position || a.Position == b.Position && a.ID < b.ID
+	})
+	return lists, nil
+}
+
+func (s *memStore) FindList(userID uint, title string) (TaskList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.lists {
+		if l.UserID == userID && l.Title == title {
+			return l, nil
+		}
+	}
+	return TaskList{}, ErrNotFound
+}
+
+func (s *memStore) FindListByFeed(token string) (TaskList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.lists {
+		if token != "" && l.FeedToken == token {
+			return l, nil
+		}
+	}
+	return TaskList{}, ErrNotFound
+}
+
+func (s *memStore) CreateList(list *TaskList) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&list.Model)
+	list.Position = 0
+	for _, l := range s.lists {
+		if l.UserID == list.UserID && l.Position >= list.Position {
+			list.Position = l.Position + 1
+		}
+	}
+	s.lists[list.ID] = *list
+	return nil
+}
+
+func (s *memStore) SaveList(list *TaskList) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lists[list.ID]; !ok {
+		return ErrNotFound
+	}
+	list.UpdatedAt = time.Now()
+	s.lists[list.ID] = *list
+	return nil
+}
+
+func (s *memStore) DeleteList(list TaskList) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.tasks {
+		if t.TaskListID == list.ID {
+			s.untag(id)
+			delete(s.tasks, id)
+		}
+	}
+	for id, m := range s.members {
+		if m.TaskListID == list.ID {
+			delete(s.members, id)
+		}
+	}
+	delete(s.lists, list.ID)
+	return nil
+}
+
+func (s *memStore) GetTask(id uint) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tasks[id]; ok {
+		return t, nil
+	}
+	return Task{}, ErrNotFound
+}
+
+func (s *memStore) Tasks(listID uint) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.tasks {
+		if t.TaskListID == listID {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		return a.Position < b.Position || a.Position == b.Position && a.ID < b.ID
+	})
+	return tasks, nil
+}
+
+func (s *memStore) FindTask(listID uint, title string) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.TaskListID == listID && t.Title == title {
+			return t, nil
+		}
+	}
+	return Task{}, ErrNotFound
+}
+
+func (s *memStore) CreateTask(task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&task.Model)
+	task.Position = 0
+	for _, t := range s.tasks {
+		if t.TaskListID == task.TaskListID && t.ParentID == task.ParentID && t.Position >= task.Position {
+			task.Position = t.Position + 1
+		}
+	}
+	s.tasks[task.ID] = *task
+	return nil
+}
+
+func (s *memStore) SaveTask(task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.tasks[task.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	task.UpdatedAt = time.Now()
+	task.RemindedFor = old.RemindedFor
+	s.tasks[task.ID] = *task
+	return nil
+}
+
+func (s *memStore) DeleteTask(task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.tasks {
+		if t.TaskListID == task.TaskListID {
+			tasks = append(tasks, t)
+		}
+	}
+	for _, id := range subtree(tasks, task.ID) {
+		s.untag(id)
+		delete(s.tasks, id)
+	}
+	return nil
+}
+
+func (s *memStore) Reminders() ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []Task
+	for _, t := range s.tasks {
+		if t.Reminder != "" && !t.Completed {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks, nil
+}
+
+func (s *memStore) MarkReminded(taskID uint, at *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[taskID]
+	if !ok {
+		return ErrNotFound
+	}
+	t.RemindedFor = at
+	s.tasks[taskID] = t
+	return nil
+}
+
+// untag takes every tag off a task.
+func (s *memStore) untag(taskID uint) {
+	for tt := range s.tagged {
+		if tt.TaskID == taskID {
+			delete(s.tagged, tt)
+		}
+	}
+}
+
+func (s *memStore) GetTag(id uint) (Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tags[id]; ok {
+		return t, nil
+	}
+	return Tag{}, ErrNotFound
+}
+
+// sortTags orders tags by name.
+func sortTags(tags []Tag) {
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+}
+
+func (s *memStore) Tags(userID uint) ([]Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tags []Tag
+	for _, t := range s.tags {
+		if t.UserID == userID {
+			tags = append(tags, t)
+		}
+	}
+	sortTags(tags)
+	return tags, nil
+}
+
+func (s *memStore) FindTag(userID uint, name string) (Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tags {
+		if t.UserID == userID && t.Name == name {
+			return t, nil
+		}
+	}
+	return Tag{}, ErrNotFound
+}
+
+func (s *memStore) CreateTag(tag *Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&tag.Model)
+	s.tags[tag.ID] = *tag
+	return nil
+}
+
+func (s *memStore) SaveTag(tag *Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tags[tag.ID]; !ok {
+		return ErrNotFound
+	}
+	tag.UpdatedAt = time.Now()
+	s.tags[tag.ID] = *tag
+	return nil
+}
+
+func (s *memStore) DeleteTag(tag Tag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tt := range s.tagged {
+		if tt.TagID == tag.ID {
+			delete(s.tagged, tt)
+		}
+	}
+	delete(s.tags, tag.ID)
+	return nil
+}
+
+func (s *memStore) TaskTags(taskID uint) ([]Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tags []Tag
+	for tt := range s.tagged {
+		if tt.TaskID == taskID {
+			tags = append(tags, s.tags[tt.TagID])
+		}
+	}
+	sortTags(tags)
+	return tags, nil
+}
+
+func (s *memStore) SetTaskTags(taskID uint, tagIDs []uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.untag(taskID)
+	for _, id := range tagIDs {
+		s.tagged[taskTag{TaskID: taskID, TagID: id}] = true
+	}
+	return nil
+}
+
+func (s *memStore) TaggedTasks(tagID uint) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tasks []Task
+	for tt := range s.tagged {
+		if t, ok := s.tasks[tt.TaskID]; ok && tt.TagID == tagID {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.TaskListID != b.TaskListID {
+			return a.TaskListID < b.TaskListID
+		}
+		return a.Position < b.Position || a.Position == b.Position && a.ID < b.ID
+	})
+	return tasks, nil
+}
+
+func (s *memStore) GetMember(id uint) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.members[id]; ok {
+		return m, nil
+	}
+	return Member{}, ErrNotFound
+}
+
+// findMembers returns the members matching keep, oldest first.
+func (s *memStore) findMembers(keep func(Member) bool) []Member {
+	var members []Member
+	for _, m := range s.members {
+		if keep(m) {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+func (s *memStore) Members(listID uint) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findMembers(func(m Member) bool { return m.TaskListID == listID }), nil
+}
+
+func (s *memStore) Memberships(userID uint) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findMembers(func(m Member) bool { return m.UserID == userID }), nil
+}
+
+func (s *memStore) FindMember(listID, userID uint) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.members {
+		if m.TaskListID == listID && m.UserID == userID {
+			return m, nil
+		}
+	}
+	return Member{}, ErrNotFound
+}
+
+func (s *memStore) CreateMember(member *Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&member.Model)
+	s.members[member.ID] = *member
+	return nil
+}
+
+func (s *memStore) SaveMember(member *Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[member.ID]; !ok {
+		return ErrNotFound
+	}
+	member.UpdatedAt = time.Now()
+	s.members[member.ID] = *member
+	return nil
+}
+
+func (s *memStore) DeleteMember(member Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members, member.ID)
+	return nil
+}
+
+func (s *memStore) GetWebhook(id uint) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hooks[id]; ok {
+		return h, nil
+	}
+	return Webhook{}, ErrNotFound
+}
+
+func (s *memStore) Webhooks(userID uint) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hooks []Webhook
+	for _, h := range s.hooks {
+		if h.UserID == userID {
+			hooks = append(hooks, h)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks, nil
+}
+
+func (s *memStore) CreateWebhook(hook *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&hook.Model)
+	s.hooks[hook.ID] = *hook
+	return nil
+}
+
+func (s *memStore) SaveWebhook(hook *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hooks[hook.ID]; !ok {
+		return ErrNotFound
+	}
+	hook.UpdatedAt = time.Now()
+	s.hooks[hook.ID] = *hook
+	return nil
+}
+
+func (s *memStore) DeleteWebhook(hook Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, d := range s.sent {
+		if d.WebhookID == hook.ID {
+			delete(s.sent, id)
+		}
+	}
+	delete(s.hooks, hook.ID)
+	return nil
+}
+
+func (s *memStore) Deliveries(webhookID uint) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []Delivery
+	for _, d := range s.sent {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	return deliveries, nil
+}
+
+func (s *memStore) PendingDeliveries(now time.Time) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []Delivery
+	for _, d := range s.sent {
+		if d.NextAttempt != nil && !d.NextAttempt.After(now) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+func (s *memStore) CreateDelivery(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamp(&delivery.Model)
+	s.sent[delivery.ID] = *delivery
+	return nil
+}
+
+func (s *memStore) SaveDelivery(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sent[delivery.ID]; !ok {
+		return ErrNotFound
+	}
+	delivery.UpdatedAt = time.Now()
+	s.sent[delivery.ID] = *delivery
+	return nil
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
@@ -0,0 +1,471 @@
+package main
+
+/*
+	## Migrations
+	The SQL stores evolve their tables in place through numbered migrations
+	instead of dropping and recreating them. Each migration that has been
+	applied is recorded as a row in the schema_version table, so starting
+	the server only runs the ones a database hasn't seen yet.
+
+	Each migration declares the tables as they looked at that version (not
+	the current model), so old migrations keep working as the model grows.
+	New migrations go at the end of the list; never edit an applied one.
+	Likewise they copy any parsing they do rather than calling the app's.
+
+	Migrations from 3 on drop columns, which SQLite can only do from 3.35.
+	go-sqlite3 builds its own SQLite in, so it must be a release bundling
+	3.35 or newer; Migrate refuses to start on an older one.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migration is one step in the schema's history.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+// schemaVersion records an applied migration.
+type schemaVersion struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName names the table holding applied migrations.
+func (schemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// migrations is the schema's full history, oldest first.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create users, task lists and tasks",
+		// AutoMigrate (rather than CreateTable) adopts tables made before
+		// migrations existed.
+		up: func(tx *gorm.DB) error {
+			type User struct {
+				gorm.Model
+				FirstName string `gorm:"primary_key"`
+				LastName  string `gorm:"primary_key"`
+			}
+			type TaskList struct {
+				gorm.Model
+				Title  string `gorm:"primary_key"`
+				UserID uint
+			}
+			type Task struct {
+				gorm.Model
+				Title      string `gorm:"primary_key"`
+				Details    string
+				DueDate    string
+				Completed  bool
+				TaskListID uint
+			}
+			return tx.AutoMigrate(&User{}, &TaskList{}, &Task{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists("tasks", "task_lists", "users").Error
+		},
+	},
+	{
+		version: 2,
+		name:    "add password hashes to users",
+		up: func(tx *gorm.DB) error {
+			type User struct {
+				PasswordHash string
+			}
+			return tx.AutoMigrate(&User{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Table("users").DropColumn("password_hash").Error
+		},
+	},
+	{
+		version: 3,
+		name:    "store due dates as times",
+		// due dates that can't be read are kept at the end of the details
+		up: func(tx *gorm.DB) error {
+			// parseDue as it was at this version
+			parseDue := func(s string) (time.Time, bool, error) {
+				if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+					return t, false, nil
+				}
+				t, err := time.ParseInLocation("2006-01-02T15:04", s, time.Local)
+				return t, true, err
+			}
+
+			type Task struct {
+				DueAt      *time.Time
+				HasDueTime bool
+			}
+			if err := tx.AutoMigrate(&Task{}).Error; err != nil {
+				return err
+			}
+
+			var old []struct {
+				ID      uint
+				DueDate string
+				Details string
+			}
+			if err := tx.Table("tasks").Select("id, due_date, details").Scan(&old).Error; err != nil {
+				return err
+			}
+			for _, t := range old {
+				if strings.TrimSpace(t.DueDate) == "" {
+					continue
+				}
+				changes := map[string]interface{}{}
+				if due, hasTime, err := parseDue(strings.TrimSpace(t.DueDate)); err == nil {
+					changes["due_at"], changes["has_due_time"] = due, hasTime
+				} else {
+					changes["details"] = strings.TrimSpace(t.Details + "\n(was due " + t.DueDate + ")")
+				}
+				if err := tx.Table("tasks").Where("id = ?", t.ID).Updates(changes).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Table("tasks").DropColumn("due_date").Error
+		},
+		down: func(tx *gorm.DB) error {
+			type Task struct {
+				DueDate string
+			}
+			if err := tx.AutoMigrate(&Task{}).Error; err != nil {
+				return err
+			}
+
+			var old []struct {
+				ID         uint
+				DueAt      *time.Time
+				HasDueTime bool
+			}
+			if err := tx.Table("tasks").Select("id, due_at, has_due_time").Where("due_at IS NOT NULL").Scan(&old).Error; err != nil {
+				return err
+			}
+			for _, t := range old {
+				due := t.DueAt.Format("2006-01-02")
+				if t.HasDueTime {
+					due = t.DueAt.Format("2006-01-02T15:04")
+				}
+				if err := tx.Table("tasks").Where("id = ?", t.ID).Update("due_date", due).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Table("tasks").DropColumn("due_at").Error; err != nil {
+				return err
+			}
+			return tx.Table("tasks").DropColumn("has_due_time").Error
+		},
+	},
+	{
+		version: 4,
+		name:    "add repeat rules to tasks",
+		up: func(tx *gorm.DB) error {
+			type Task struct {
+				Recurrence string
+			}
+			return tx.AutoMigrate(&Task{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Table("tasks").DropColumn("recurrence").Error
+		},
+	},
+	{
+		version: 5,
+		name:    "add subtasks",
+		up: func(tx *gorm.DB) error {
+			type Task struct {
+				ParentID     uint `gorm:"index"`
+				AutoComplete bool
+			}
+			return tx.AutoMigrate(&Task{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Table("tasks").RemoveIndex("idx_tasks_parent_id").Error; err != nil {
+				return err
+			}
+			if err := tx.Table("tasks").DropColumn("parent_id").Error; err != nil {
+				return err
+			}
+			return tx.Table("tasks").DropColumn("auto_complete").Error
+		},
+	},
+	{
+		version: 6,
+		name:    "add task priorities and positions for tasks and lists",
+		// existing tasks and lists keep the order they were added in
+		up: func(tx *gorm.DB) error {
+			type Task struct {
+				Priority int
+				Position int
+			}
+			type TaskList struct {
+				Position int
+			}
+			if err := tx.AutoMigrate(&Task{}, &TaskList{}).Error; err != nil {
+				return err
+			}
+
+			var tasks []struct{ ID, TaskListID, ParentID uint }
+			if err := tx.Table("tasks").Select("id, task_list_id, parent_id").Order("id").Scan(&tasks).Error; err != nil {
+				return err
+			}
+			next := map[[2]uint]int{}
+			for _, t := range tasks {
+				group := [2]uint{t.TaskListID, t.ParentID}
+				if err := tx.Table("tasks").Where("id = ?", t.ID).Update("position", next[group]).Error; err != nil {
+					return err
+				}
+				next[group]++
+			}
+
+			var lists []struct{ ID, UserID uint }
+			if err := tx.Table("task_lists").Select("id, user_id").Order("id").Scan(&lists).Error; err != nil {
+				return err
+			}
+			nextList := map[uint]int{}
+			for _, l := range lists {
+				if err := tx.Table("task_lists").Where("id = ?", l.ID).Update("position", nextList[l.UserID]).Error; err != nil {
+					return err
+				}
+				nextList[l.UserID]++
+			}
+			return nil
+		},
+		down: func(tx *gorm.DB) error {
+			for _, column := range []string{"priority", "position"} {
+				if err := tx.Table("tasks").DropColumn(column).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Table("task_lists").DropColumn("position").Error
+		},
+	},
+	{
+		version: 7,
+		name:    "add tags",
+		up: func(tx *gorm.DB) error {
+			type Tag struct {
+				gorm.Model
+				UserID uint `gorm:"index"`
+				Name   string
+				Color  string
+			}
+			type TaskTag struct {
+				TaskID uint `gorm:"primary_key;auto_increment:false"`
+				TagID  uint `gorm:"primary_key;auto_increment:false;index"`
+			}
+			return tx.AutoMigrate(&Tag{}, &TaskTag{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists("task_tags", "tags").Error
+		},
+	},
+	{
+		version: 8,
+		name:    "add shared list members",
+		up: func(tx *gorm.DB) error {
+			type Member struct {
+				gorm.Model
+				TaskListID uint `gorm:"index"`
+				UserID     uint `gorm:"index"`
+				Role       string
+				Accepted   bool
+			}
+			return tx.AutoMigrate(&Member{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists("members").Error
+		},
+	},
+	{
+		version: 9,
+		name:    "add calendar feed tokens to users and lists",
+		up: func(tx *gorm.DB) error {
+			type User struct {
+				FeedToken string `gorm:"index"`
+			}
+			type TaskList struct {
+				FeedToken string `gorm:"index"`
+			}
+			return tx.AutoMigrate(&User{}, &TaskList{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Table("users").RemoveIndex("idx_users_feed_token").Error; err != nil {
+				return err
+			}
+			if err := tx.Table("task_lists").RemoveIndex("idx_task_lists_feed_token").Error; err != nil {
+				return err
+			}
+			if err := tx.Table("users").DropColumn("feed_token").Error; err != nil {
+				return err
+			}
+			return tx.Table("task_lists").DropColumn("feed_token").Error
+		},
+	},
+	{
+		version: 10,
+		name:    "add reminders to tasks and email addresses to users",
+		up: func(tx *gorm.DB) error {
+			type User struct {
+				Email        string
+				RemindersOff bool
+			}
+			type Task struct {
+				Reminder    string
+				RemindedFor *time.Time
+			}
+			return tx.AutoMigrate(&User{}, &Task{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			for _, col := range []string{"email", "reminders_off"} {
+				if err := tx.Table("users").DropColumn(col).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Table("tasks").DropColumn("reminder").Error; err != nil {
+				return err
+			}
+			return tx.Table("tasks").DropColumn("reminded_for").Error
+		},
+	},
+	{
+		version: 11,
+		name:    "add webhooks and their deliveries",
+		up: func(tx *gorm.DB) error {
+			type Webhook struct {
+				gorm.Model
+				UserID uint `gorm:"index"`
+				URL    string
+				Secret string
+				Events string
+				Active bool
+			}
+			type Delivery struct {
+				gorm.Model
+				WebhookID   uint `gorm:"index"`
+				Event       string
+				Payload     string `gorm:"type:text"`
+				Attempts    int
+				Status      int
+				Error       string
+				Delivered   bool
+				NextAttempt *time.Time `gorm:"index"`
+			}
+			return tx.AutoMigrate(&Webhook{}, &Delivery{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists("deliveries", "webhooks").Error
+		},
+	},
+}
+
+// latestVersion is the version of the newest migration.
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion reports the newest migration applied to db.
+func SchemaVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&schemaVersion{}).Error; err != nil {
+		return 0, err
+	}
+	var v schemaVersion
+	err := db.Order("version desc").First(&v).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
+	return v.Version, err
+}
+
+// Migrate moves db's schema up or down to the target version.
+// Each step runs in its own transaction.
+func Migrate(db *gorm.DB, target int) error {
+	if target < 0 || target > latestVersion() {
+		return fmt.Errorf("no migration %d (latest is %d)", target, latestVersion())
+	}
+
+	if err := checkSQLite(db); err != nil {
+		return err
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		slog.Info("migrating up", "version", m.version, "name", m.name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaVersion{m.version, m.name, time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %v", m.version, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		slog.Info("migrating down", "version", m.version, "name", m.name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaVersion{Version: m.version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %v", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// minSQLite is the oldest SQLite that can drop columns.
+var minSQLite = [3]int{3, 35, 0}
+
+// checkSQLite makes sure an SQLite database can run the migrations.
+func checkSQLite(db *gorm.DB) error {
+	if db.Dialect().GetName() != "sqlite3" {
+		return nil
+	}
+	var version string
+	if err := db.Raw("SELECT sqlite_version()").Row().Scan(&version); err != nil {
+		return err
+	}
+	if oldSQLite(version) {
+		return fmt.Errorf("SQLite %s can't drop columns; migrating needs %d.%d or newer (update github.com/mattn/go-sqlite3)", version, minSQLite[0], minSQLite[1])
+	}
+	return nil
+}
+
+// oldSQLite reports whether an SQLite version is older than minSQLite.
+func oldSQLite(version string) bool {
+	var v [3]int
+	fmt.Sscanf(version, "%d.%d.%d", &v[0], &v[1], &v[2])
+	for i := range v {
+		if v[i] != minSQLite[i] {
+			return v[i] < minSQLite[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	cfg := Config{Store: "sqlite", DBName: filepath.Join(t.TempDir(), "tasks.db")}
+
+	s, err := NewStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Example(s); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// reopening must keep the data rather than resetting it
+	s, err = NewStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FindUser("Andrea", "Lam"); err != nil {
+		t.Error("data lost on restart: ", err)
+	}
+	db := s.(*gormStore).db
+
+	if v, err := SchemaVersion(db); err != nil || v != latestVersion() {
+		t.Fatalf("SchemaVersion = %d, %v; want %d", v, err, latestVersion())
+	}
+
+	if err := Migrate(db, 0); err != nil {
+		t.Fatal("down: ", err)
+	}
+	if v, _ := SchemaVersion(db); v != 0 {
+		t.Errorf("version after down = %d", v)
+	}
+	if db.HasTable("tasks") {
+		t.Error("tasks table survived migrating down")
+	}
+
+	if err := Migrate(db, latestVersion()); err != nil {
+		t.Fatal("up: ", err)
+	}
+	if !db.HasTable("tasks") {
+		t.Error("tasks table missing after migrating up")
+	}
+
+	if err := Migrate(db, latestVersion()+1); err == nil {
+		t.Error("expected an error migrating past the latest version")
+	}
+	s.Close()
+}
+
+func TestMigrateDueDates(t *testing.T) {
+	db, err := Connect(Config{Store: "sqlite", DBName: filepath.Join(t.TempDir(), "tasks.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// tasks as they were stored before due dates had a type
+	if err := Migrate(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, due := range []string{"2017-03-30", "2020-04-01T15:30", "Not Today", ""} {
+		err := db.Exec("INSERT INTO tasks (title, details, due_date, completed, task_list_id) VALUES (?, ?, ?, ?, ?)",
+			"task due "+due, "details", due, false, 1).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Migrate(db, 3); err != nil {
+		t.Fatal(err)
+	}
+	var tasks []Task
+	db.Order("id").Find(&tasks)
+	got := []string{}
+	for _, task := range tasks {
+		got = append(got, task.DueString()+"|"+task.Details)
+	}
+	want := []string{
+		"2017-03-30|details",
+		"2020-04-01T15:30|details",
+		"|details\n(was due Not Today)",
+		"|details",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("migrated tasks = %q, want %q", got, want)
+	}
+
+	// and back again
+	if err := Migrate(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	var old []struct{ DueDate string }
+	db.Table("tasks").Order("id").Select("due_date").Scan(&old)
+	if len(old) != 4 || old[0].DueDate != "2017-03-30" || old[1].DueDate != "2020-04-01T15:30" {
+		t.Errorf("due dates after migrating down = %v", old)
+	}
+}
+
+func TestMigratePositions(t *testing.T) {
+	db, err := Connect(Config{Store: "sqlite", DBName: filepath.Join(t.TempDir(), "tasks.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := Migrate(db, 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range []struct {
+		list, parent uint
+	}{{1, 0}, {1, 0}, {2, 0}, {1, 1}, {1, 0}} {
+		err := db.Exec("INSERT INTO tasks (title, task_list_id, parent_id) VALUES (?, ?, ?)", "task", task.list, task.parent).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Migrate(db, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	// positions count up within each list and parent, in the order added
+	var positions []int
+	db.Table("tasks").Order("id").Pluck("position", &positions)
+	if fmt.Sprint(positions) != "[0 1 0 0 2]" {
+		t.Errorf("positions = %v", positions)
+	}
+}
+
+func TestOldSQLite(t *testing.T) {
+	for version, old := range map[string]bool{"3.32.2": true, "3.34.1": true, "3.35.0": false, "3.45.1": false, "4.0.0": false} {
+		if got := oldSQLite(version); got != old {
+			t.Errorf("oldSQLite(%q) = %v", version, got)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+/*
+	## Priorities & Ordering
+	Tasks and lists stay in the order their owner puts them in. Each has a
+	Position: lists among the user's lists, and tasks among the tasks with
+	the same parent (see subtasks.go). New ones go at the end, and moving
+	one renumbers its neighbours 0, 1, 2, ... so positions never collide.
+
+	| to   | moves the task or list      |
+	| ---- | --------------------------- |
+	| up   | one place earlier           |
+	| down | one place later             |
+	| 0..n | to that index (0 is first)  |
+
+	Tasks also have a priority, which /view and the API can sort by
+	(?sort=priority) with the highest first and ties in position order.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// task priorities, lowest first
+const (
+	noPriority = iota
+	lowPriority
+	mediumPriority
+	highPriority
+)
+
+// priorityNames names each priority for forms and the API.
+var priorityNames = []string{"", "low", "medium", "high"}
+
+// parsePriority reads a priority by name ("" for none).
+func parsePriority(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for p, name := range priorityNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, badInput("priority must be low, medium, high or empty")
+}
+
+// PriorityName names the task's priority ("" for none).
+func (t Task) PriorityName() string {
+	if t.Priority < noPriority || t.Priority > highPriority {
+		return ""
+	}
+	return priorityNames[t.Priority]
+}
+
+// sortTasks orders tasks by the named field: "due" puts the soonest due
+// first and undated tasks last (see due.go), "priority" puts the highest
+// first, and anything else keeps their owner's order.
+func sortTasks(tasks []Task, by string) {
+	byPosition := func(a, b Task) bool {
+		return a.Position < b.Position || a.Position == b.Position && a.ID < b.ID
+	}
+	switch by {
+	case "due":
+		sort.SliceStable(tasks, func(i, j int) bool {
+			a, b := tasks[i], tasks[j]
+			switch {
+			case a.DueDate == nil || b.DueDate == nil:
+				return a.DueDate != nil && b.DueDate == nil
+			case !a.deadline().Equal(b.deadline()):
+				return a.deadline().Before(b.deadline())
+			}
+			return false
+		})
+	case "priority":
+		sort.SliceStable(tasks, func(i, j int) bool {
+			a, b := tasks[i], tasks[j]
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			return byPosition(a, b)
+		})
+	default:
+		sort.SliceStable(tasks, func(i, j int) bool { return byPosition(tasks[i], tasks[j]) })
+	}
+}
+
+// moveIndex works out where "up", "down" or an index moves the item now at
+// index i of n.
+func moveIndex(to string, i, n int) (int, error) {
+	switch to {
+	case "up":
+		i--
+	case "down":
+		i++
+	default:
+		var err error
+		if i, err = strconv.Atoi(to); err != nil {
+			return 0, badInput(fmt.Sprintf("can't move to %q (want up, down or an index)", to))
+		}
+	}
+	if i < 0 {
+		return 0, nil
+	}
+	if i >= n {
+		return n - 1, nil
+	}
+	return i, nil
+}
+
+// place moves id to index within ids, returning the new order.
+func place(ids []uint, id uint, index int) []uint {
+	order := []uint{}
+	for _, other := range ids {
+		if other != id {
+			order = append(order, other)
+		}
+	}
+	if index > len(order) {
+		index = len(order)
+	}
+	order = append(order[:index], append([]uint{id}, order[index:]...)...)
+	return order
+}
+
+// siblings lists the tasks with the same parent as task, by position.
+func siblings(task Task) ([]Task, error) {
+	tasks, err := store.Tasks(task.TaskListID)
+	if err != nil {
+		return nil, err
+	}
+	var out []Task
+	for _, t := range tasks {
+		if t.ParentID == task.ParentID {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// moveTask moves a task among its siblings (see moveIndex).
+func moveTask(task Task, to string) error {
+	tasks, err := siblings(task)
+	if err != nil {
+		return err
+	}
+	ids, at := []uint{}, 0
+	for i, t := range tasks {
+		ids = append(ids, t.ID)
+		if t.ID == task.ID {
+			at = i
+		}
+	}
+	index, err := moveIndex(to, at, len(ids))
+	if err != nil {
+		return err
+	}
+
+	positions := map[uint]int{}
+	for i, id := range place(ids, task.ID, index) {
+		positions[id] = i
+	}
+	for _, t := range tasks {
+		if t.Position != positions[t.ID] {
+			t.Position = positions[t.ID]
+			if err := store.SaveTask(&t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveList moves a list among its owner's lists (see moveIndex).
+func moveList(list TaskList, to string) error {
+	lists, err := store.Lists(list.UserID)
+	if err != nil {
+		return err
+	}
+	ids, at := []uint{}, 0
+	for i, l := range lists {
+		ids = append(ids, l.ID)
+		if l.ID == list.ID {
+			at = i
+		}
+	}
+	index, err := moveIndex(to, at, len(ids))
+	if err != nil {
+		return err
+	}
+
+	positions := map[uint]int{}
+	for i, id := range place(ids, list.ID, index) {
+		positions[id] = i
+	}
+	for _, l := range lists {
+		if l.Position != positions[l.ID] {
+			l.Position = positions[l.ID]
+			if err := store.SaveList(&l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lastPosition is the position after a task's current siblings, for tasks
+// moving to a new parent.
+func lastPosition(task Task) (int, error) {
+	tasks, err := siblings(task)
+	if err != nil {
+		return 0, err
+	}
+	last := -1
+	for _, t := range tasks {
+		if t.ID != task.ID && t.Position > last {
+			last = t.Position
+		}
+	}
+	return last + 1, nil
+}
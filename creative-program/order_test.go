@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestMoveIndex(t *testing.T) {
+	tests := []struct {
+		to   string
+		i, n int
+		want int
+	}{
+		{"up", 2, 3, 1},
+		{"up", 0, 3, 0},
+		{"down", 1, 3, 2},
+		{"down", 2, 3, 2},
+		{"0", 2, 3, 0},
+		{"7", 0, 3, 2},
+		{"-1", 1, 3, 0},
+	}
+	for _, test := range tests {
+		if got, err := moveIndex(test.to, test.i, test.n); err != nil || got != test.want {
+			t.Errorf("moveIndex(%q, %d, %d) = %d, %v", test.to, test.i, test.n, got, err)
+		}
+	}
+	if _, err := moveIndex("sideways", 0, 3); err == nil {
+		t.Error("moveIndex should reject sideways")
+	}
+
+	if got := fmt.Sprint(place([]uint{1, 2, 3, 4}, 4, 1)); got != "[1 4 2 3]" {
+		t.Errorf("place = %s", got)
+	}
+}
+
+func TestSortByPriority(t *testing.T) {
+	tasks := []Task{
+		{Model: gorm.Model{ID: 1}, Title: "a", Position: 2},
+		{Model: gorm.Model{ID: 2}, Title: "b", Position: 1, Priority: highPriority},
+		{Model: gorm.Model{ID: 3}, Title: "c", Position: 0},
+		{Model: gorm.Model{ID: 4}, Title: "d", Position: 3, Priority: highPriority},
+		{Model: gorm.Model{ID: 5}, Title: "e", Position: 4, Priority: lowPriority},
+	}
+	titles := func() string {
+		s := ""
+		for _, t := range tasks {
+			s += t.Title
+		}
+		return s
+	}
+
+	sortTasks(tasks, "priority")
+	if got := titles(); got != "bdeca" {
+		t.Errorf("by priority: %s", got)
+	}
+	sortTasks(tasks, "")
+	if got := titles(); got != "cbade" {
+		t.Errorf("by position: %s", got)
+	}
+}
+
+func TestStorePositions(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, title := range []string{"first", "second"} {
+				list := TaskList{Title: title, UserID: 1}
+				if err := s.CreateList(&list); err != nil {
+					t.Fatal(err)
+				}
+				task := Task{Title: title, TaskListID: 1}
+				s.CreateTask(&task)
+				step := Task{Title: title + " step", TaskListID: 1, ParentID: task.ID}
+				s.CreateTask(&step)
+				if list.Position != i || task.Position != i || step.Position != 0 {
+					t.Errorf("%s: list at %d, task at %d, step at %d", title, list.Position, task.Position, step.Position)
+				}
+			}
+
+			lists, _ := s.Lists(1)
+			tasks, _ := s.Tasks(1)
+			if len(lists) != 2 || len(tasks) != 4 {
+				t.Fatalf("lists %v, tasks %v", lists, tasks)
+			}
+
+			// Lists and Tasks come back by position
+			lists[0].Position = 5
+			s.SaveList(&lists[0])
+			if lists, _ = s.Lists(1); lists[0].Title != "second" {
+				t.Errorf("lists = %v", lists)
+			}
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		luis := loginAs(t, "Luis", "Bosquez")
+		post := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", path, nil)
+			withSession(r, luis)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		order := func() string {
+			tasks, _ := store.Tasks(list.ID)
+			s := ""
+			for _, t := range tasks {
+				s += t.Title + ";"
+			}
+			return s
+		}
+
+		if w := post(idPath(t, "move", "Luis's List", "Watch TV") + "?to=up"); w.Code != http.StatusFound {
+			t.Fatalf("move: %d %s", w.Code, w.Body)
+		}
+		if got := order(); got != "Watch TV;Do more laundry;" {
+			t.Errorf("after moving up: %s", got)
+		}
+		if w := post(idPath(t, "move", "Luis's List", "Watch TV") + "?to=left"); w.Code != http.StatusBadRequest {
+			t.Errorf("move left: %d", w.Code)
+		}
+
+		post(idPath(t, "move", "Luis's Other List") + "?to=0")
+		if lists, _ := store.Lists(user.ID); lists[0].Title != "Luis's Other List" {
+			t.Errorf("lists after move = %v", lists)
+		}
+
+		// the API moves by index and sets priorities
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{luis}}
+		laundry, _ := store.FindTask(list.ID, "Do more laundry")
+		w := c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", laundry.ID), `{"position": 0, "priority": "high"}`)
+		var out apiTask
+		json.NewDecoder(w.Body).Decode(&out)
+		if w.Code != http.StatusOK || out.Position != 0 || out.Priority != "high" {
+			t.Errorf("PATCH: %d %+v", w.Code, out)
+		}
+		if got := order(); got != "Do more laundry;Watch TV;" {
+			t.Errorf("after PATCH: %s", got)
+		}
+		if w := c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", laundry.ID), `{"priority": "urgent"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bad priority: %d", w.Code)
+		}
+		if w := c.call("PATCH", fmt.Sprintf("/api/v1/lists/%d", list.ID), `{"position": 0}`); w.Code != http.StatusOK {
+			t.Errorf("move list: %d %s", w.Code, w.Body)
+		}
+		if lists, _ := store.Lists(user.ID); lists[0].ID != list.ID {
+			t.Errorf("lists after PATCH = %v", lists)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package main
+
+/*
+	## Recurring Tasks
+	A task may repeat. Its rule is written like an iCalendar RRULE (RFC 5545)
+	and stored as text, with one extension for chores that are due some time
+	after they were last done rather than on a fixed schedule:
+
+	| rule                                  | next due                        |
+	| ------------------------------------- | ------------------------------- |
+	| FREQ=DAILY                            | the day after                   |
+	| FREQ=DAILY;INTERVAL=3                 | 3 days after                    |
+	| FREQ=WEEKLY                           | a week after                    |
+	| FREQ=WEEKLY;BYDAY=MO,TH               | the next Monday or Thursday     |
+	| FREQ=WEEKLY;INTERVAL=2;BYDAY=SA       | every other Saturday            |
+	| FREQ=MONTHLY                          | a month after                   |
+	| FREQ=DAILY;INTERVAL=10;X-FROM=DONE    | 10 days after it was completed  |
+
+	Completing an occurrence (see completeTask) adds the next one to the same
+	list. Scheduled rules count from the due date and skip occurrences that
+	would already be overdue; X-FROM=DONE rules count from the day it was
+	completed. The finished occurrence keeps its history under a new title
+	ending in its due date, so the next one can have the task's title.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// how often a task repeats
+const (
+	daily   = "DAILY"
+	weekly  = "WEEKLY"
+	monthly = "MONTHLY"
+)
+
+// weekdays in RRULE BYDAY order (weeks start on Monday)
+var weekdays = []struct {
+	code string
+	day  time.Weekday
+}{
+	{"MO", time.Monday}, {"TU", time.Tuesday}, {"WE", time.Wednesday},
+	{"TH", time.Thursday}, {"FR", time.Friday}, {"SA", time.Saturday},
+	{"SU", time.Sunday},
+}
+
+// recurrence is a parsed repeat rule. The zero value never repeats.
+type recurrence struct {
+	Freq     string // "" (never), DAILY, WEEKLY or MONTHLY
+	Interval int    // every Interval days, weeks or months
+	Days     []time.Weekday
+	FromDone bool // count from completion instead of the due date
+}
+
+// parseRecurrence reads a rule written as in the table above.
+func parseRecurrence(s string) (recurrence, error) {
+	var r recurrence
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return r, nil
+	}
+
+	r.Interval = 1
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return recurrence{}, badInput(fmt.Sprintf("repeat rule part %q isn't KEY=VALUE", part))
+		}
+		switch key, value := kv[0], kv[1]; key {
+		case "FREQ":
+			if value != daily && value != weekly && value != monthly {
+				return recurrence{}, badInput("repeat FREQ must be DAILY, WEEKLY or MONTHLY")
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 366 {
+				return recurrence{}, badInput("repeat INTERVAL must be a number from 1 to 366")
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day, ok := weekdayCode(code)
+				if !ok {
+					return recurrence{}, badInput(fmt.Sprintf("repeat BYDAY has unknown day %q", code))
+				}
+				r.Days = append(r.Days, day)
+			}
+		case "X-FROM":
+			if value != "DONE" {
+				return recurrence{}, badInput("repeat X-FROM must be DONE")
+			}
+			r.FromDone = true
+		default:
+			return recurrence{}, badInput(fmt.Sprintf("repeat rule has unknown part %s", key))
+		}
+	}
+
+	switch {
+	case r.Freq == "":
+		return recurrence{}, badInput("repeat rule needs a FREQ")
+	case len(r.Days) > 0 && r.Freq != weekly:
+		return recurrence{}, badInput("repeat BYDAY only works with FREQ=WEEKLY")
+	}
+	r.Days = uniqueDays(r.Days)
+	return r, nil
+}
+
+// weekdayCode looks up a BYDAY code.
+func weekdayCode(code string) (time.Weekday, bool) {
+	for _, w := range weekdays {
+		if w.code == code {
+			return w.day, true
+		}
+	}
+	return 0, false
+}
+
+// dayCode is the BYDAY code of a weekday.
+func dayCode(day time.Weekday) string {
+	for _, w := range weekdays {
+		if w.day == day {
+			return w.code
+		}
+	}
+	return ""
+}
+
+// uniqueDays sorts days Monday first and drops repeats.
+func uniqueDays(days []time.Weekday) []time.Weekday {
+	monday := func(d time.Weekday) int { return (int(d) + 6) % 7 }
+	sort.Slice(days, func(i, j int) bool { return monday(days[i]) < monday(days[j]) })
+	out := days[:0]
+	for i, d := range days {
+		if i == 0 || d != days[i-1] {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// String writes the rule the way parseRecurrence reads it.
+func (r recurrence) String() string {
+	if r.Freq == "" {
+		return ""
+	}
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.Days) > 0 {
+		codes := []string{}
+		for _, d := range r.Days {
+			codes = append(codes, dayCode(d))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.FromDone {
+		parts = append(parts, "X-FROM=DONE")
+	}
+	return strings.Join(parts, ";")
+}
+
+// On reports whether a weekly rule picks the day with the given BYDAY code.
+func (r recurrence) On(code string) bool {
+	day, ok := weekdayCode(code)
+	return ok && r.picks(day)
+}
+
+// picks reports whether a weekly rule picks the day.
+func (r recurrence) picks(day time.Weekday) bool {
+	for _, d := range r.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe writes the rule for people, e.g. "every 2 weeks on Mon, Thu".
+func (r recurrence) Describe() string {
+	unit := map[string]string{daily: "day", weekly: "week", monthly: "month"}[r.Freq]
+	switch {
+	case r.Freq == "":
+		return ""
+	case r.FromDone && r.Interval == 1:
+		return fmt.Sprintf("a %s after it's done", unit)
+	case r.FromDone:
+		return fmt.Sprintf("%d %ss after it's done", r.Interval, unit)
+	}
+
+	s := "every " + unit
+	if r.Interval > 1 {
+		s = fmt.Sprintf("every %d %ss", r.Interval, unit)
+	}
+	if len(r.Days) > 0 {
+		names := []string{}
+		for _, d := range r.Days {
+			names = append(names, d.String()[:3])
+		}
+		s += " on " + strings.Join(names, ", ")
+	}
+	return s
+}
+
+// step moves t forward by one period of the rule.
+func (r recurrence) step(t time.Time) time.Time {
+	switch r.Freq {
+	case daily:
+		return t.AddDate(0, 0, r.Interval)
+	case monthly:
+		return addMonths(t, r.Interval)
+	}
+	if len(r.Days) == 0 {
+		return t.AddDate(0, 0, 7*r.Interval)
+	}
+
+	// the next picked day in a week that is a multiple of Interval weeks on
+	start := weekStart(t)
+	for d := 1; ; d++ {
+		next := t.AddDate(0, 0, d)
+		weeks := int(weekStart(next).Sub(start).Hours()+12) / (7 * 24)
+		if weeks%r.Interval == 0 && r.picks(next.Weekday()) {
+			return next
+		}
+	}
+}
+
+// weekStart is midnight on the Monday of t's week.
+func weekStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+}
+
+// addMonths adds months to t, keeping to the last day of shorter months
+// (a month after January 31st is February 28th or 29th, not March 3rd).
+func addMonths(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+// Rule is the task's parsed repeat rule (checked by Validate).
+func (t Task) Rule() recurrence {
+	r, _ := parseRecurrence(t.Recurrence)
+	return r
+}
+
+// SetRepeat sets (or with "" clears) a task's repeat rule.
+func (t *Task) SetRepeat(s string) error {
+	r, err := parseRecurrence(s)
+	if err != nil {
+		return err
+	}
+	t.Recurrence = r.String()
+	return nil
+}
+
+// Repeats describes the task's repeat rule for people.
+func (t Task) Repeats() string {
+	return t.Rule().Describe()
+}
+
+// parseRepeatForm builds a repeat rule from the edit and add forms: how
+// often ("repeat"), every how many ("every"), on which weekdays ("on") and
+// whether to count from completion ("after done").
+func parseRepeatForm(freq, every string, days []string, fromDone bool) (string, error) {
+	if freq == "" {
+		return "", nil
+	}
+	parts := []string{"FREQ=" + freq}
+	if every = strings.TrimSpace(every); every != "" {
+		parts = append(parts, "INTERVAL="+every)
+	}
+	if len(days) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if fromDone {
+		parts = append(parts, "X-FROM=DONE")
+	}
+	r, err := parseRecurrence(strings.Join(parts, ";"))
+	return r.String(), err
+}
+
+// nextDue works out when the occurrence after t is due, if t was done at now.
+func (t Task) nextDue(now time.Time) time.Time {
+	r := t.Rule()
+	if t.DueDate != nil && !r.FromDone {
+		next := r.step(*t.DueDate)
+		for (Task{DueDate: &next, HasDueTime: t.HasDueTime}).OverdueAt(now) {
+			next = r.step(next)
+		}
+		return next
+	}
+
+	// count from the day it was done, at the time of day it was due
+	y, m, d := now.In(time.Local).Date()
+	hour, min := 0, 0
+	if t.DueDate != nil && t.HasDueTime {
+		hour, min = t.DueDate.Hour(), t.DueDate.Minute()
+	}
+	return r.step(time.Date(y, m, d, hour, min, 0, 0, time.Local))
+}
+
+// doneTitle finds a title for a finished occurrence of a repeating task
+// that no other task in its list has, e.g. "Do laundry (2017-03-30)".
+func doneTitle(t Task, now time.Time) (string, error) {
+	day := t.DueDay()
+	if day == "" {
+		day = now.Format(dateFormat)
+	}
+	for n := 1; ; n++ {
+		suffix := " (" + day + ")"
+		if n > 1 {
+			suffix = fmt.Sprintf(" (%s #%d)", day, n)
+		}
+		base := t.Title
+		if len(base)+len(suffix) > maxTitle {
+			cut := maxTitle - len(suffix)
+			for cut > 0 && !utf8.RuneStart(base[cut]) {
+				cut-- // not partway through a character
+			}
+			base = base[:cut]
+		}
+		_, err := store.FindTask(t.TaskListID, base+suffix)
+		if err == ErrNotFound {
+			return base + suffix, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// completeTask marks a task complete and saves it. If it repeats, the
+// finished occurrence is renamed (see doneTitle) and stops repeating, and
+// the next occurrence, with the same tags, is added to the list and
+// returned.
+func completeTask(task *Task, now time.Time) (*Task, error) {
+	task.Completed = true
+	if task.Rule().Freq == "" {
+		return nil, store.SaveTask(task)
+	}
+
+	next := Task{
+		Title:        task.Title,
+		Details:      task.Details,
+		Priority:     task.Priority,
+		HasDueTime:   task.HasDueTime,
+		Recurrence:   task.Recurrence,
+		TaskListID:   task.TaskListID,
+		ParentID:     task.ParentID,
+		AutoComplete: task.AutoComplete,
+	}
+	due := task.nextDue(now)
+	next.DueDate = &due
+	next.Reminder = nextReminder(*task, due)
+
+	// the rule moves on to the next occurrence
+	title, err := doneTitle(*task, now)
+	if err != nil {
+		return nil, err
+	}
+	task.Title, task.Recurrence = title, ""
+	if err := store.SaveTask(task); err != nil {
+		return nil, err
+	}
+	if err := store.CreateTask(&next); err != nil {
+		return nil, err
+	}
+
+	// ...with the same tags, everyone's (see tags.go)
+	tags, err := store.TaskTags(task.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		ids := make([]uint, len(tags))
+		for i, tag := range tags {
+			ids[i] = tag.ID
+		}
+		if err := store.SetTaskTags(next.ID, ids); err != nil {
+			return nil, err
+		}
+	}
+	return &next, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	tests := []struct {
+		in, want, describe string
+	}{
+		{"", "", ""},
+		{"FREQ=DAILY", "FREQ=DAILY", "every day"},
+		{"freq=daily;interval=3", "FREQ=DAILY;INTERVAL=3", "every 3 days"},
+		{"FREQ=WEEKLY;BYDAY=TH,MO,TH", "FREQ=WEEKLY;BYDAY=MO,TH", "every week on Mon, Thu"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=SA", "FREQ=WEEKLY;INTERVAL=2;BYDAY=SA", "every 2 weeks on Sat"},
+		{"FREQ=MONTHLY;INTERVAL=1", "FREQ=MONTHLY", "every month"},
+		{"FREQ=DAILY;INTERVAL=10;X-FROM=DONE", "FREQ=DAILY;INTERVAL=10;X-FROM=DONE", "10 days after it's done"},
+	}
+	for _, test := range tests {
+		r, err := parseRecurrence(test.in)
+		if err != nil || r.String() != test.want || r.Describe() != test.describe {
+			t.Errorf("parseRecurrence(%q) = %q (%q), %v", test.in, r, r.Describe(), err)
+		}
+	}
+
+	for _, bad := range []string{
+		"DAILY",
+		"FREQ=YEARLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=DAILY;COUNT=3",
+	} {
+		if _, err := parseRecurrence(bad); err == nil {
+			t.Errorf("parseRecurrence(%q) should fail", bad)
+		}
+	}
+
+	if rule, err := parseRepeatForm("WEEKLY", "2", []string{"FR", "MO"}, false); err != nil || rule != "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR" {
+		t.Errorf("parseRepeatForm = %q, %v", rule, err)
+	}
+}
+
+func TestNextDue(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.Local) }
+	thu := day(2020, 4, 2) // a Thursday
+
+	tests := []struct {
+		rule     string
+		due      time.Time
+		done     time.Time
+		wantNext time.Time
+	}{
+		{"FREQ=DAILY", thu, thu, day(2020, 4, 3)},
+		{"FREQ=DAILY;INTERVAL=3", thu, thu, day(2020, 4, 5)},
+		{"FREQ=WEEKLY", thu, thu, day(2020, 4, 9)},
+		{"FREQ=WEEKLY;BYDAY=MO,TH", thu, thu, day(2020, 4, 6)},
+		{"FREQ=WEEKLY;BYDAY=MO,TH", day(2020, 4, 6), thu, day(2020, 4, 9)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU", thu, thu, day(2020, 4, 14)},
+		{"FREQ=MONTHLY", day(2020, 1, 31), day(2020, 1, 31), day(2020, 2, 29)},
+		// done late: skip occurrences that would already be overdue
+		{"FREQ=DAILY", thu, day(2020, 4, 5).Add(12 * time.Hour), day(2020, 4, 5)},
+		{"FREQ=WEEKLY", thu, day(2020, 4, 20), day(2020, 4, 23)},
+		// counted from when it's done
+		{"FREQ=DAILY;INTERVAL=10;X-FROM=DONE", thu, day(2020, 4, 20).Add(9 * time.Hour), day(2020, 4, 30)},
+	}
+	for _, test := range tests {
+		due := test.due
+		task := Task{DueDate: &due, Recurrence: test.rule}
+		if got := task.nextDue(test.done); !got.Equal(test.wantNext) {
+			t.Errorf("%s due %s, done %s: next due %s, want %s",
+				test.rule, task.DueDay(), test.done.Format(dateTimeFormat), got.Format(dateFormat), test.wantNext.Format(dateFormat))
+		}
+	}
+
+	// the time of day carries over
+	at := time.Date(2020, 4, 2, 18, 30, 0, 0, time.Local)
+	task := Task{DueDate: &at, HasDueTime: true, Recurrence: "FREQ=DAILY"}
+	if got := task.nextDue(at); !got.Equal(at.AddDate(0, 0, 1)) {
+		t.Errorf("next due %s", got)
+	}
+}
+
+func TestCompleteRepeating(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{loginAs(t, "Luis", "Bosquez")}}
+
+		w := httptest.NewRecorder()
+		form := url.Values{"title": {"Watch TV"}, "due date": {"2020-04-02"}, "repeat": {"WEEKLY"}, "every": {"1"}, "on": {"TH", "MO"}}
+		r := httptest.NewRequest("POST", idPath(t, "edit", "Luis's List", "Watch TV"), strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		withSession(r, c.cookies[0])
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("edit: %d %s", w.Code, w.Body)
+		}
+
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		task, _ := store.FindTask(list.ID, "Watch TV")
+		task.Priority, task.AutoComplete = highPriority, true
+		chores := Tag{UserID: user.ID, Name: "chores", Color: defaultColor}
+		store.CreateTag(&chores)
+		store.SetTaskTags(task.ID, []uint{chores.ID})
+		if task.Recurrence != "FREQ=WEEKLY;BYDAY=MO,TH" || task.Repeats() != "every week on Mon, Thu" {
+			t.Fatalf("repeat rule not saved: %q", task.Recurrence)
+		}
+
+		next, err := completeTask(&task, time.Date(2020, 4, 2, 12, 0, 0, 0, time.Local))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !task.Completed || task.Title != "Watch TV (2020-04-02)" || task.Recurrence != "" {
+			t.Errorf("finished occurrence = %+v", task)
+		}
+		if next.Title != "Watch TV" || next.DueString() != "2020-04-06" || next.Completed || next.Recurrence != "FREQ=WEEKLY;BYDAY=MO,TH" || next.Priority != highPriority || !next.AutoComplete {
+			t.Errorf("next occurrence = %+v", next)
+		}
+		if tags, err := store.TaskTags(next.ID); err != nil || len(tags) != 1 || tags[0].ID != chores.ID {
+			t.Errorf("next occurrence's tags = %v, %v", tags, err)
+		}
+		if tags, _ := store.TaskTags(task.ID); len(tags) != 1 {
+			t.Errorf("finished occurrence's tags = %v", tags)
+		}
+
+		// finishing through the API links to the next occurrence
+		w = c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", next.ID), `{"completed": true}`)
+		var done apiTask
+		json.NewDecoder(w.Body).Decode(&done)
+		if w.Code != http.StatusOK || !done.Completed || done.Title != "Watch TV (2020-04-06)" {
+			t.Fatalf("complete: %d %+v", w.Code, done)
+		}
+		link := w.Header().Get("Link")
+		var id uint
+		if _, err := fmt.Sscanf(link, "</api/v1/tasks/%d>", &id); err != nil {
+			t.Fatalf("Link %q: %v", link, err)
+		}
+		if third, err := store.GetTask(id); err != nil || third.Title != "Watch TV" || !third.DueDate.After(time.Now()) {
+			t.Errorf("third occurrence = %+v, %v", third, err)
+		}
+
+		// marking a finished occurrence incomplete again adds nothing
+		before, _ := store.Tasks(list.ID)
+		c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", next.ID), `{"completed": false}`)
+		if after, _ := store.Tasks(list.ID); len(after) != len(before) {
+			t.Errorf("%d tasks, then %d", len(before), len(after))
+		}
+	})
+}
+
+func TestDoneTitle(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		testServer(t)
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		now := time.Date(2020, 4, 2, 12, 0, 0, 0, time.Local)
+
+		// a long title is cut short of the date, between characters
+		title, err := doneTitle(Task{Title: strings.Repeat("é", 60), TaskListID: list.ID}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.ValidString(title) || len(title) > maxTitle || !strings.HasSuffix(title, "é (2020-04-02)") {
+			t.Errorf("doneTitle = %q", title)
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package main
+
+/*
+	## Reminders
+	A task can have a reminder, emailed to everyone in its list when it
+	comes due. Reminders are set on the edit page (or as "reminder" in the
+	API) either as a time or as how long before the task is due:
+
+	| reminder         | sent                                                |
+	| ---------------- | --------------------------------------------------- |
+	|                  | never                                               |
+	| 2020-04-01T09:00 | at 9 AM on April 1st                                |
+	| 30m, 2h          | 30 minutes or 2 hours before the task is due        |
+	| 1d, 1w           | a day or a week before                              |
+	| 0m               | when it's due                                       |
+
+	Tasks due on a day rather than at a time count back from 9 AM on that
+	day, and relative reminders follow the due date when it moves. Repeating
+	tasks pass their reminder on to each new occurrence, moving reminders
+	set at a time along with the due date.
+
+	While the server runs, a scheduler checks every minute for reminders
+	that have come due, so ones missed while it was down go out as soon as
+	it's back. Each task remembers the reminder time it last sent, and is
+	marked before the email goes out, so a reminder is sent once even if
+	the server restarts part way; it's only tried again if no email could
+	be sent at all. Finished tasks aren't reminded.
+
+	Emails go through the SMTP server in the smtp-* settings (see
+	config.go); without one, no reminders are sent. Users give their email
+	address on their /account page, where they can also turn reminders off.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how often the scheduler looks for reminders to send
+const reminderInterval = time.Minute
+
+// reminderHour is when tasks due on a day (rather than at a time) are
+// due, for counting back to their reminders.
+const reminderHour = 9
+
+// relativeReminder matches a reminder counted back from the due date.
+var relativeReminder = regexp.MustCompile(`^(\d{1,4})([mhdw])$`)
+
+// units of relative reminders, for people
+var reminderUnits = map[string]string{"m": "minute", "h": "hour", "d": "day", "w": "week"}
+
+// parseReminder reads a reminder written as "", a time, or a count of
+// minutes, hours, days or weeks before the task is due.
+func parseReminder(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || relativeReminder.MatchString(s) {
+		return s, nil
+	}
+	if t, err := time.ParseInLocation(dateTimeFormat, strings.ToUpper(s), time.Local); err == nil {
+		return t.Format(dateTimeFormat), nil
+	}
+	return "", badInput("reminder must look like YYYY-MM-DDTHH:MM, or 30m, 2h, 1d or 1w before it's due")
+}
+
+// SetReminder sets (or with "" clears) a task's reminder.
+func (t *Task) SetReminder(s string) error {
+	r, err := parseReminder(s)
+	if err != nil {
+		return err
+	}
+	t.Reminder = r
+	return nil
+}
+
+// RemindTime is when the task's reminder goes out, if it has one. Relative
+// reminders need a due date.
+func (t Task) RemindTime() (time.Time, bool) {
+	if t.Reminder == "" {
+		return time.Time{}, false
+	}
+	m := relativeReminder.FindStringSubmatch(t.Reminder)
+	if m == nil {
+		at, err := time.ParseInLocation(dateTimeFormat, t.Reminder, time.Local)
+		return at, err == nil
+	}
+	if t.DueDate == nil {
+		return time.Time{}, false
+	}
+	due := *t.DueDate
+	if !t.HasDueTime {
+		due = time.Date(due.Year(), due.Month(), due.Day(), reminderHour, 0, 0, 0, time.Local)
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch m[2] {
+	case "m":
+		return due.Add(-time.Duration(n) * time.Minute), true
+	case "h":
+		return due.Add(-time.Duration(n) * time.Hour), true
+	case "d":
+		return due.AddDate(0, 0, -n), true
+	default:
+		return due.AddDate(0, 0, -7*n), true
+	}
+}
+
+// Reminds describes the task's reminder for people.
+func (t Task) Reminds() string {
+	m := relativeReminder.FindStringSubmatch(t.Reminder)
+	if m == nil {
+		if at, ok := t.RemindTime(); ok {
+			return at.Format("Mon Jan 2, 2006 at 3:04 PM")
+		}
+		return ""
+	}
+	if m[1] == "0" {
+		return "when it's due"
+	}
+	unit := reminderUnits[m[2]]
+	if m[1] != "1" {
+		unit += "s"
+	}
+	return fmt.Sprintf("%s %s before it's due", m[1], unit)
+}
+
+// nextReminder is the reminder for a repeating task's next occurrence,
+// due on due. Reminders at a time move with the due date.
+func nextReminder(task Task, due time.Time) string {
+	at, ok := task.RemindTime()
+	if !ok || relativeReminder.MatchString(task.Reminder) || task.DueDate == nil {
+		return task.Reminder
+	}
+	return at.Add(due.Sub(*task.DueDate)).Format(dateTimeFormat)
+}
+
+// remindDue reports whether a task's reminder should go out by now: it
+// has come round and hasn't been sent for this time yet.
+func (t Task) remindDue(now time.Time) (time.Time, bool) {
+	at, ok := t.RemindTime()
+	if !ok || t.Completed || at.After(now) || t.RemindedFor != nil && t.RemindedFor.Equal(at) {
+		return at, false
+	}
+	return at, true
+}
+
+// notifier sends messages to users.
+type notifier interface {
+	// notify sends a message to an email address.
+	notify(to, subject, body string) error
+}
+
+// smtpNotifier sends email through an SMTP server.
+type smtpNotifier struct {
+	addr string // host:port
+	from string
+	auth smtp.Auth // nil to send without logging in
+}
+
+// newSMTPNotifier sends through the server in cfg's smtp-* settings.
+func newSMTPNotifier(cfg Config) *smtpNotifier {
+	n := &smtpNotifier{addr: cfg.SMTPAddr, from: cfg.MailFrom}
+	if cfg.SMTPUser != "" {
+		host := cfg.SMTPAddr
+		if i := strings.LastIndexByte(host, ':'); i >= 0 {
+			host = host[:i]
+		}
+		n.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+	}
+	return n
+}
+
+func (n *smtpNotifier) notify(to, subject, body string) error {
+	msg := "From: " + n.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.Replace(body, "\n", "\r\n", -1)
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{to}, []byte(msg))
+}
+
+// recipients are the users who hear about a list's reminders: its owner
+// and members, if they've given an address and not turned reminders off.
+func recipients(list TaskList) ([]User, error) {
+	ids, err := listUsers(list)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for _, id := range ids {
+		u, err := store.GetUser(id)
+		if err != nil {
+			return nil, err
+		}
+		if u.Email != "" && !u.RemindersOff {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// remind emails a task's reminder, returning how many were sent.
+func remind(task Task, n notifier) (int, error) {
+	list, err := store.GetList(task.TaskListID)
+	if err != nil {
+		return 0, err
+	}
+	users, err := recipients(list)
+	if err != nil {
+		return 0, err
+	}
+
+	subject := "Reminder: " + task.Title
+	body := fmt.Sprintf("%s, in your list %q, ", task.Title, list.Title)
+	if task.DueDate != nil {
+		body += "is due " + task.Due() + ".\n"
+	} else {
+		body += "needs doing.\n"
+	}
+	if task.Details != "" {
+		body += "\n" + task.Details + "\n"
+	}
+	body += "\nTurn these emails off on your account page.\n"
+
+	sent := 0
+	for _, u := range users {
+		if err = n.notify(u.Email, subject, body); err != nil {
+			slog.Error("failed to send reminder", "user", u.ID, "task", task.ID, "err", err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 && err != nil {
+		return 0, err
+	}
+	return sent, nil
+}
+
+// sendReminders sends every reminder due by now that hasn't been sent.
+func sendReminders(now time.Time, n notifier) error {
+	tasks, err := store.Reminders()
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		at, ok := task.remindDue(now)
+		if !ok {
+			continue
+		}
+		// mark it first, so a crash or a slow server can't send it twice
+		if err := store.MarkReminded(task.ID, &at); err != nil {
+			return err
+		}
+		if _, err := remind(task, n); err != nil {
+			// nobody got it, so try again next time
+			if err := store.MarkReminded(task.ID, task.RemindedFor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runReminders sends reminders as they come due, forever.
+func runReminders(n notifier) {
+	for {
+		if err := sendReminders(time.Now(), n); err != nil {
+			slog.Error("failed to send reminders", "err", err)
+		}
+		time.Sleep(reminderInterval)
+	}
+}
+
+// accountPage is what account.html shows.
+type accountPage struct {
+	Error string
+	Saved bool
+	User  User
+	CSRF  string // the session's token, for the form (see csrf.go)
+}
+
+// setEmail checks and sets a user's email address ("" for none).
+func (u *User) setEmail(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		u.Email = ""
+		return nil
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Name != "" {
+		return badInput("that doesn't look like an email address")
+	}
+	u.Email = addr.Address
+	return nil
+}
+
+// accountHandler Shows the user's email settings (GET /account/) or saves
+// them (POST).
+func accountHandler(w http.ResponseWriter, r *http.Request, user User) {
+	page := accountPage{User: user, CSRF: pageToken(r)}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		err := page.User.setEmail(r.FormValue("email"))
+		page.User.RemindersOff = r.FormValue("reminders") == ""
+		if err == nil {
+			err = store.SaveUser(&page.User)
+		}
+		if _, ok := err.(badInput); ok {
+			page.Error, status = err.Error(), http.StatusBadRequest
+		} else if err != nil {
+			storeError(w, r, err)
+			return
+		} else {
+			page.Saved = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "account.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a local SMTP server that keeps what it's sent.
+type smtpStandIn struct {
+	addr string
+	mu   sync.Mutex
+	mail []string // "to\r\nmessage" per email
+}
+
+// newSMTPStandIn starts an SMTP stand-in for the rest of the test.
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &smtpStandIn{addr: l.Addr().String()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost")
+	var to string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			s.mu.Lock()
+			s.mail = append(s.mail, to+"\r\n"+msg.String())
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default: // EHLO, MAIL, RSET
+			reply("250 OK")
+		}
+	}
+}
+
+// sent returns the emails received so far and forgets them.
+func (s *smtpStandIn) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mail := s.mail
+	s.mail = nil
+	return mail
+}
+
+func TestRemindTime(t *testing.T) {
+	day, _ := time.ParseInLocation(dateFormat, "2020-04-01", time.Local)
+	at, _ := time.ParseInLocation(dateTimeFormat, "2020-04-01T15:30", time.Local)
+	for _, c := range []struct {
+		reminder string
+		due      *time.Time
+		timed    bool
+		want     string // "" for no reminder
+		says     string
+	}{
+		{"", &day, false, "", ""},
+		{"1d", nil, false, "", "1 day before it's due"},
+		{"1d", &day, false, "2020-03-31T09:00", "1 day before it's due"},
+		{"30m", &at, true, "2020-04-01T15:00", "30 minutes before it's due"},
+		{"2H", &at, true, "2020-04-01T13:30", "2 hours before it's due"},
+		{"1w", &day, false, "2020-03-25T09:00", "1 week before it's due"},
+		{"0m", &day, false, "2020-04-01T09:00", "when it's due"},
+		{"2020-03-01t08:15", &day, false, "2020-03-01T08:15", "Sun Mar 1, 2020 at 8:15 AM"},
+		{"2020-03-01T08:15", nil, false, "2020-03-01T08:15", "Sun Mar 1, 2020 at 8:15 AM"},
+	} {
+		task := Task{DueDate: c.due, HasDueTime: c.timed}
+		if err := task.SetReminder(c.reminder); err != nil {
+			t.Errorf("SetReminder(%q): %v", c.reminder, err)
+			continue
+		}
+		got, ok := task.RemindTime()
+		if ok != (c.want != "") || ok && got.Format(dateTimeFormat) != c.want {
+			t.Errorf("RemindTime(%q) = %v, %v; want %q", c.reminder, got, ok, c.want)
+		}
+		if says := task.Reminds(); says != c.says {
+			t.Errorf("Reminds(%q) = %q; want %q", c.reminder, says, c.says)
+		}
+	}
+	for _, bad := range []string{"tomorrow", "-1d", "1y", "2020-02-30T09:00"} {
+		if err := (&Task{}).SetReminder(bad); err == nil {
+			t.Errorf("SetReminder(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestStoreReminders(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			list := TaskList{Title: "Chores", UserID: 1}
+			s.CreateList(&list)
+			tasks := []Task{
+				{Title: "Reminded", Reminder: "1d", TaskListID: list.ID},
+				{Title: "Not reminded", TaskListID: list.ID},
+				{Title: "Done", Reminder: "1d", Completed: true, TaskListID: list.ID},
+			}
+			for i := range tasks {
+				s.CreateTask(&tasks[i])
+			}
+			if got, err := s.Reminders(); err != nil || len(got) != 1 || got[0].ID != tasks[0].ID {
+				t.Fatalf("Reminders = %v, %v", got, err)
+			}
+
+			at := time.Date(2020, 4, 1, 9, 0, 0, 0, time.UTC)
+			if err := s.MarkReminded(tasks[0].ID, &at); err != nil {
+				t.Fatal("MarkReminded: ", err)
+			}
+			got, _ := s.GetTask(tasks[0].ID)
+			if got.RemindedFor == nil || !got.RemindedFor.Equal(at) || !got.UpdatedAt.Equal(tasks[0].UpdatedAt) {
+				t.Errorf("after MarkReminded: %v, updated %v (was %v)", got.RemindedFor, got.UpdatedAt, tasks[0].UpdatedAt)
+			}
+			// a save from a copy read before the mark doesn't undo it
+			tasks[0].Details = "edited"
+			s.SaveTask(&tasks[0])
+			if got, _ := s.GetTask(tasks[0].ID); got.RemindedFor == nil || got.Details != "edited" {
+				t.Errorf("SaveTask lost the mark: %+v", got)
+			}
+			s.MarkReminded(tasks[0].ID, nil)
+			if got, _ := s.GetTask(tasks[0].ID); got.RemindedFor != nil {
+				t.Errorf("unmarked: %v", got.RemindedFor)
+			}
+			if err := s.MarkReminded(999, &at); err != ErrNotFound {
+				t.Errorf("MarkReminded(999) = %v", err)
+			}
+		})
+	}
+}
+
+func TestSendReminders(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		testServer(t)
+		mail := newSMTPStandIn(t)
+		n := newSMTPNotifier(Config{SMTPAddr: mail.addr, MailFrom: "tasks@localhost"})
+
+		andrea, _ := store.FindUser("Andrea", "Lam")
+		andrea.Email = "andrea@example.com"
+		store.SaveUser(&andrea)
+		luis, _ := store.FindUser("Luis", "Bosquez")
+		luis.Email, luis.RemindersOff = "luis@example.com", true
+		store.SaveUser(&luis)
+		meet, _ := store.FindUser("Meet", "Bhagdev")
+		meet.Email = "meet@example.com"
+		store.SaveUser(&meet)
+
+		list, _ := store.FindList(andrea.ID, "Andrea's list")
+		for _, id := range []uint{luis.ID, meet.ID} {
+			store.CreateMember(&Member{TaskListID: list.ID, UserID: id, Role: "viewer", Accepted: id == luis.ID})
+		}
+		task, _ := store.FindTask(list.ID, "Do laundry")
+		task.Reminder = "1d" // 9 AM on 2017-03-29
+		store.SaveTask(&task)
+
+		at := func(s string) time.Time {
+			t, _ := time.ParseInLocation(dateTimeFormat, s, time.Local)
+			return t
+		}
+		if sendReminders(at("2017-03-29T08:59"), n); len(mail.sent()) != 0 {
+			t.Error("reminder sent early")
+		}
+		// Luis turned reminders off and Meet hasn't accepted, so only Andrea
+		sendReminders(at("2017-03-29T09:00"), n)
+		sent := mail.sent()
+		if len(sent) != 1 || !strings.HasPrefix(sent[0], "andrea@example.com\r\n") || !strings.Contains(sent[0], "Subject: Reminder: Do laundry") {
+			t.Fatalf("sent %q", sent)
+		}
+
+		// running again, as after a restart, doesn't send it twice
+		sendReminders(at("2017-03-30T12:00"), n)
+		sendReminders(at("2017-03-30T12:01"), newSMTPNotifier(Config{SMTPAddr: mail.addr}))
+		if sent := mail.sent(); len(sent) != 0 {
+			t.Errorf("sent again: %q", sent)
+		}
+
+		// moving the due date sets the reminder off again
+		task, _ = store.GetTask(task.ID)
+		task.SetDue("2017-04-02")
+		store.SaveTask(&task)
+		sendReminders(at("2017-04-01T09:00"), n)
+		if sent := mail.sent(); len(sent) != 1 {
+			t.Errorf("sent after moving: %q", sent)
+		}
+
+		// reminders nobody could get are tried again
+		task.SetDue("2017-04-05")
+		store.SaveTask(&task)
+		down := newSMTPNotifier(Config{SMTPAddr: "127.0.0.1:1", MailFrom: "tasks@localhost"})
+		if err := sendReminders(at("2017-04-04T10:00"), down); err != nil {
+			t.Fatal("sendReminders: ", err)
+		}
+		sendReminders(at("2017-04-04T10:01"), n)
+		if sent := mail.sent(); len(sent) != 1 {
+			t.Errorf("sent after the server was down: %q", sent)
+		}
+
+		// finished tasks aren't reminded
+		task, _ = store.GetTask(task.ID)
+		task.Reminder = "0m"
+		task.Completed = true
+		store.SaveTask(&task)
+		sendReminders(at("2017-04-06T10:00"), n)
+		if sent := mail.sent(); len(sent) != 0 {
+			t.Errorf("sent for a finished task: %q", sent)
+		}
+	})
+}
+
+func TestReminderForms(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea := loginAs(t, "Andrea", "Lam")
+		send := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(r, andrea)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		if w := send("POST", "/account/", url.Values{"email": {"not an address"}, "reminders": {"on"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("bad email: %d", w.Code)
+		}
+		if w := send("POST", "/account/", url.Values{"email": {" andrea@example.com "}}); w.Code != http.StatusOK {
+			t.Errorf("save account: %d %s", w.Code, w.Body)
+		}
+		if user, _ := store.FindUser("Andrea", "Lam"); user.Email != "andrea@example.com" || !user.RemindersOff {
+			t.Errorf("account = %q, off %v", user.Email, user.RemindersOff)
+		}
+
+		edit := url.Values{"title": {"Do laundry"}, "due date": {"2017-03-30"}, "repeat": {"WEEKLY"}, "reminder": {"2h"}}
+		if w := send("POST", idPath(t, "edit", "Andrea's list", "Do laundry"), edit); w.Code != http.StatusFound {
+			t.Fatalf("edit: %d %s", w.Code, w.Body)
+		}
+		if body := send("GET", "/view/", nil).Body.String(); !strings.Contains(body, "Reminder 2 hours before it&#39;s due") {
+			t.Errorf("view: %s", body)
+		}
+		edit.Set("reminder", "soonish")
+		if w := send("POST", idPath(t, "edit", "Andrea's list", "Do laundry"), edit); w.Code != http.StatusBadRequest {
+			t.Errorf("bad reminder: %d", w.Code)
+		}
+
+		// a weekly task's reminder moves on with it
+		send("POST", idPath(t, "mark", "Andrea's list", "Do laundry"), nil)
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		if next, err := store.FindTask(list.ID, "Do laundry"); err != nil || next.Reminder != "2h" || next.Completed {
+			t.Errorf("next occurrence: %+v, %v", next, err)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+/*
+	## Routing
+	Requests are matched on their method and path against the routes in
+	routes() (see tasks.go). A route's pattern is split on "/" and each part
+	must match in turn:
+
+	| part           | matches                                          |
+	| -------------- | ------------------------------------------------ |
+	| edit           | exactly "edit"                                   |
+	| {list}         | any non-empty part, kept as the "list" parameter |
+	| {id:uint}      | a whole number, kept as "id"                     |
+	| {token}.ics    | a part ending in .ics, kept without it           |
+	| {rest...}      | the rest of the path, if it's the last part      |
+
+	A trailing slash doesn't matter, so /view and /view/ are the same page.
+	Paths no route matches get 404 Not Found, and paths that only match
+	with another method get 405 Method Not Allowed with an Allow header.
+	Routes for GET also answer HEAD.
+
+	Handlers read their parameters with pathParam and pathID. Middleware
+	added with use wraps every request, matched or not, the first added
+	outermost.
+*/
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// router sends requests to the handler for their method and path.
+	router struct {
+		routes     []route
+		middleware []func(http.Handler) http.Handler
+	}
+
+	// route is one pattern and the methods it answers.
+	route struct {
+		methods []string // "*" for any
+		parts   []routePart
+		handler http.Handler
+	}
+
+	// routePart is one "/"-separated part of a pattern.
+	routePart struct {
+		literal string // matched exactly, if there's no param
+		param   string // name of the parameter this part is kept as
+		kind    string // "", "uint" or "..."
+		suffix  string // literal ending after the parameter
+	}
+
+	// routeParams are the parameters taken from a request's path.
+	routeParams map[string]string
+
+	// paramsKey finds a request's routeParams in its context.
+	paramsKey struct{}
+)
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle routes requests with any of the comma separated methods ("*" for
+// all of them) and a path matching pattern to h.
+func (rt *router) handle(methods, pattern string, h http.Handler) {
+	rt.routes = append(rt.routes, route{
+		methods: strings.Split(strings.Replace(methods, " ", "", -1), ","),
+		parts:   parsePattern(pattern),
+		handler: h,
+	})
+}
+
+// handleFunc is handle for handler functions.
+func (rt *router) handleFunc(methods, pattern string, h http.HandlerFunc) {
+	rt.handle(methods, pattern, h)
+}
+
+// use adds middleware around every request.
+func (rt *router) use(mw ...func(http.Handler) http.Handler) {
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+// parsePattern splits a route's pattern into parts. Bad patterns are the
+// programmer's mistake, so they panic.
+func parsePattern(pattern string) []routePart {
+	var parts []routePart
+	segments := splitPath(pattern)
+	for i, s := range segments {
+		open, end := strings.IndexByte(s, '{'), strings.IndexByte(s, '}')
+		if open < 0 {
+			parts = append(parts, routePart{literal: s})
+			continue
+		}
+		if open != 0 || end < 0 {
+			panic("bad route pattern " + pattern)
+		}
+		p := routePart{param: s[1:end], suffix: s[end+1:]}
+		if i := strings.IndexByte(p.param, ':'); i >= 0 {
+			p.param, p.kind = p.param[:i], p.param[i+1:]
+		} else if strings.HasSuffix(p.param, "...") {
+			p.param, p.kind = strings.TrimSuffix(p.param, "..."), "..."
+		}
+		if p.param == "" || (p.kind != "" && p.kind != "uint" && p.kind != "...") ||
+			(p.kind == "..." && (i != len(segments)-1 || p.suffix != "")) {
+			panic("bad route pattern " + pattern)
+		}
+		parts = append(parts, p)
+	}
+	return parts
+}
+
+// splitPath splits a path into its parts, ignoring a trailing slash.
+func splitPath(path string) []string {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// match reports whether the path's parts fit the route, and what
+// parameters they hold.
+func (rt route) match(segments []string) (routeParams, bool) {
+	params := routeParams{}
+	for i, p := range rt.parts {
+		if p.kind == "..." {
+			params[p.param] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		s := segments[i]
+		if p.param == "" {
+			if s != p.literal {
+				return nil, false
+			}
+			continue
+		}
+		if !strings.HasSuffix(s, p.suffix) || len(s) == len(p.suffix) {
+			return nil, false
+		}
+		s = strings.TrimSuffix(s, p.suffix)
+		if p.kind == "uint" {
+			if _, err := strconv.ParseUint(s, 10, 0); err != nil {
+				return nil, false
+			}
+		}
+		params[p.param] = s
+	}
+	return params, len(segments) == len(rt.parts)
+}
+
+// allows reports whether the route answers a method.
+func (rt route) allows(method string) bool {
+	for _, m := range rt.methods {
+		if m == method || m == "*" || (m == http.MethodGet && method == http.MethodHead) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var h http.Handler = http.HandlerFunc(rt.dispatch)
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+	h.ServeHTTP(w, r)
+}
+
+// dispatch finds the request's route and serves it, or says why there's
+// none.
+func (rt *router) dispatch(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+	allowed := map[string]bool{}
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.allows(r.Method) {
+			route.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), paramsKey{}, params)))
+			return
+		}
+		for _, m := range route.methods {
+			allowed[m] = true
+		}
+	}
+	if len(allowed) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var allow []string
+	for m := range allowed {
+		allow = append(allow, m)
+	}
+	sort.Strings(allow)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// pathParam is a parameter from the request's path ("" if its route has
+// none by that name).
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(routeParams)
+	return params[name]
+}
+
+// pathID is a {name:uint} parameter from the request's path (0 if its
+// route has none by that name).
+func pathID(r *http.Request, name string) uint {
+	n, _ := strconv.ParseUint(pathParam(r, name), 10, 0)
+	return uint(n)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	rt := newRouter()
+	var got []string
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, fmt.Sprint(name, " ", pathParam(r, "list"), " ", pathParam(r, "task"), " ", pathParam(r, "token"), " ", pathParam(r, "rest"), " ", pathID(r, "id")))
+		}
+	}
+	rt.handleFunc("GET", "/view", record("view"))
+	rt.handleFunc("GET, POST", "/edit/{list}", record("list"))
+	rt.handleFunc("POST", "/edit/{list}/{task}", record("task"))
+	rt.handleFunc("POST", "/invites/{id:uint}", record("invite"))
+	rt.handleFunc("GET", "/calendar/{token}.ics", record("feed"))
+	rt.handleFunc("*", "/api/{rest...}", record("api"))
+	var order []string
+	for _, name := range []string{"outer", "inner"} {
+		name := name
+		rt.use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+		got          string
+	}{
+		{"GET", "/view/", 200, "view     0"},
+		{"GET", "/view", 200, "view     0"},
+		{"HEAD", "/view/", 200, "view     0"},
+		{"POST", "/edit/Chores", 200, "list Chores    0"},
+		{"POST", "/edit/Chores/Do laundry/", 200, "task Chores Do laundry   0"},
+		{"POST", "/invites/12", 200, "invite     12"},
+		{"GET", "/calendar/abc.ics", 200, "feed   abc  0"},
+		{"DELETE", "/api/v1/tasks/3", 200, "api    v1/tasks/3 0"},
+		{"GET", "/api/", 200, "api     0"},
+		{"GET", "/edit/Chores/Do laundry", 405, ""},
+		{"PUT", "/view/", 405, ""},
+		{"GET", "/edit", 404, ""},
+		{"POST", "/edit//Do laundry", 404, ""},
+		{"POST", "/edit/Chores/Do laundry/more", 404, ""},
+		{"POST", "/invites/abc", 404, ""},
+		{"POST", "/invites/-1", 404, ""},
+		{"GET", "/calendar/abc", 404, ""},
+		{"GET", "/calendar/.ics", 404, ""},
+		{"GET", "/", 404, ""},
+	} {
+		got, order = nil, nil
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(c.method, (&url.URL{Path: c.path}).String(), nil))
+		if w.Code != c.status {
+			t.Errorf("%s %s: %d, want %d", c.method, c.path, w.Code, c.status)
+		}
+		if len(got) > 0 && got[0] != c.got || len(got) == 0 && c.got != "" {
+			t.Errorf("%s %s: ran %q, want %q", c.method, c.path, got, c.got)
+		}
+		if strings.Join(order, " ") != "outer inner" {
+			t.Errorf("%s %s: middleware ran %q", c.method, c.path, order)
+		}
+		if w.Code == http.StatusMethodNotAllowed && w.Header().Get("Allow") == "" {
+			t.Errorf("%s %s: no Allow header", c.method, c.path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("PATCH", "/edit/Chores", nil))
+	if allow := w.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Allow = %q", allow)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea := loginAs(t, "Andrea", "Lam")
+		send := func(method, path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, path, nil)
+			withSession(r, andrea)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		// every page in tasks.go's table is still there, with or without a slash
+		for _, path := range []string{
+			"/welcome", "/view/", "/view?sort=due", "/view/?sort=priority", "/tags/", "/search/?q=laundry",
+			"/feeds/", "/export/?format=csv", "/import", "/account/", "/webhooks", "/tasks.css",
+		} {
+			if w := send("GET", path); w.Code == http.StatusNotFound || w.Code == http.StatusMethodNotAllowed {
+				t.Errorf("GET %s: %d", path, w.Code)
+			}
+		}
+		for _, path := range []string{"/login/", "/register"} {
+			if w := send("GET", path); w.Code != http.StatusFound || w.Header().Get("Location") != "/welcome/" {
+				t.Errorf("GET %s: %d to %q", path, w.Code, w.Header().Get("Location"))
+			}
+		}
+
+		// old title-based paths lead on to the ID-based ones
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		task, _ := store.FindTask(list.ID, "Do laundry")
+		for _, c := range []struct{ method, path, to string }{
+			{"GET", "/share/Andrea%27s%20list", fmt.Sprintf("/lists/%d/share", list.ID)},
+			{"POST", "/move/Andrea%27s%20list?to=down", fmt.Sprintf("/lists/%d/move?to=down", list.ID)},
+			{"POST", "/add/Andrea%27s%20list/", fmt.Sprintf("/lists/%d/add", list.ID)},
+			{"POST", "/mark/Andrea%27s%20list/Do%20laundry", fmt.Sprintf("/tasks/%d/mark", task.ID)},
+			{"POST", "/edit/Andrea%27s%20list/Do%20laundry", fmt.Sprintf("/tasks/%d/edit", task.ID)},
+		} {
+			if w := send(c.method, c.path); w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != c.to {
+				t.Errorf("%s %s: %d to %q, want %q", c.method, c.path, w.Code, w.Header().Get("Location"), c.to)
+			}
+		}
+		for _, path := range []string{"/mark/Andrea%27s%20list/Missing", "/edit/Meet%27s%20List"} {
+			if w := send("POST", path); w.Code != http.StatusNotFound {
+				t.Errorf("POST %s: %d", path, w.Code)
+			}
+		}
+
+		// malformed paths no longer act on nothing
+		for _, c := range []struct {
+			method, path string
+			status       int
+		}{
+			{"GET", "/delete/", http.StatusNotFound},
+			{"GET", "/mark/Andrea%27s%20list", http.StatusNotFound},
+			{"POST", "/add/Andrea%27s%20list/Do%20laundry/again", http.StatusNotFound},
+			{"GET", "/tasks/abc/mark", http.StatusNotFound},
+			{"GET", "/lists/999/edit", http.StatusNotFound},
+			{"POST", "/invites/me", http.StatusNotFound},
+			{"GET", "/add/Andrea%27s%20list", http.StatusMethodNotAllowed},
+			{"DELETE", "/view/", http.StatusMethodNotAllowed},
+			{"GET", "/nowhere", http.StatusNotFound},
+		} {
+			if w := send(c.method, c.path); w.Code != c.status {
+				t.Errorf("%s %s: %d, want %d", c.method, c.path, w.Code, c.status)
+			}
+		}
+	})
+}
@@ -0,0 +1,330 @@
+package main
+
+/*
+	## Search
+	/search (and /api/v1/search) find a user's tasks by the words in their
+	titles, details and list titles. Queries are a few words, all of which
+	must match somewhere:
+
+	| query          | matches                                     |
+	| -------------- | ------------------------------------------- |
+	| laundry        | the word "laundry", in any case             |
+	| laun*          | any word starting with "laun"               |
+	| "more laundry" | the two words next to each other            |
+	| e-mail         | "e" then "mail", like a phrase              |
+
+	Matching is done here rather than by the database so that every store
+	(see store.go) finds the same things; SQL Server's full-text indexes
+	have no match in SQLite or memory, and a user's tasks are few enough to
+	read through. Hits in a title count for more than hits in its list's
+	title, which count for more than hits in the details, and results come
+	back best first with the hits highlighted.
+*/
+
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// limits on searches
+const (
+	maxSearchTerms = 10
+	maxResults     = 50
+	snippetWidth   = 120 // characters of details shown around a hit
+)
+
+// how much a hit counts for in each field
+const (
+	titleWeight   = 5
+	listWeight    = 2
+	detailsWeight = 1
+)
+
+type (
+	// searchTerm is a word, prefix or phrase to look for.
+	searchTerm struct {
+		words  []string // lower case, in order
+		prefix bool     // the last word need only start the text's word
+	}
+
+	// span is where a word (or run of words) sits in a string, in bytes.
+	span struct {
+		start, end int
+		word       string // lower case
+	}
+
+	// snippetPart is a piece of a snippet, highlighted if Hit is set.
+	snippetPart struct {
+		Text string
+		Hit  bool
+	}
+
+	// snippet is some text with the hits marked.
+	snippet []snippetPart
+
+	// searchResult is a task found by search.
+	searchResult struct {
+		Task      Task
+		List      TaskList
+		Score     int
+		Title     snippet
+		ListTitle snippet
+		Details   snippet // empty unless the details matched
+	}
+)
+
+// splitWords finds the words (runs of letters and digits) in s.
+func splitWords(s string) []span {
+	var words []span
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			words = append(words, span{start, i, strings.ToLower(s[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(s), strings.ToLower(s[start:])})
+	}
+	return words
+}
+
+// parseQuery splits a query into the terms to look for.
+func parseQuery(q string) ([]searchTerm, error) {
+	var terms []searchTerm
+	add := func(text string, prefix bool) {
+		var term searchTerm
+		for _, w := range splitWords(text) {
+			term.words = append(term.words, w.word)
+		}
+		if len(term.words) > 0 {
+			term.prefix = prefix
+			terms = append(terms, term)
+		}
+	}
+
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				end = len(q) - 1 // an unclosed phrase runs to the end
+			}
+			add(q[1:end+1], false)
+			q = strings.TrimPrefix(q[end+1:], `"`)
+			continue
+		}
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			end = len(q)
+		}
+		add(q[:end], strings.HasSuffix(q[:end], "*"))
+		q = q[end:]
+	}
+
+	switch {
+	case len(terms) == 0:
+		return nil, badInput("search for at least one word")
+	case len(terms) > maxSearchTerms:
+		return nil, badInput("search for fewer words")
+	}
+	return terms, nil
+}
+
+// find returns where the term appears among words.
+func (term searchTerm) find(words []span) []span {
+	var hits []span
+	last := len(term.words) - 1
+	for i := 0; i+last < len(words); i++ {
+		match := true
+		for j, w := range term.words {
+			got := words[i+j].word
+			if got != w && !(term.prefix && j == last && strings.HasPrefix(got, w)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			hits = append(hits, span{start: words[i].start, end: words[i+last].end})
+		}
+	}
+	return hits
+}
+
+// highlight marks the hits in s. If width is more than 0, only about that
+// many characters around the first hit are kept.
+func highlight(s string, hits []span, width int) snippet {
+	sort.Slice(hits, func(i, j int) bool { return hits[i].start < hits[j].start })
+
+	from, to := 0, len(s)
+	if width > 0 && len(s) > width && len(hits) > 0 {
+		from = hits[0].start - width/3
+		if from <= 0 {
+			from = 0
+		} else if space := strings.IndexFunc(s[from:], unicode.IsSpace); space >= 0 && from+space < hits[0].start {
+			from += space + 1 // start on a whole word
+		}
+		to = from + width
+		if to >= len(s) {
+			to = len(s)
+		} else if space := strings.LastIndexFunc(s[:to], unicode.IsSpace); space > hits[0].end {
+			to = space
+		}
+		if to < hits[0].end {
+			to = hits[0].end
+		}
+		// and don't cut a character in two
+		for from > 0 && !utf8.RuneStart(s[from]) {
+			from--
+		}
+		for to < len(s) && !utf8.RuneStart(s[to]) {
+			to++
+		}
+	}
+
+	var out snippet
+	if from > 0 {
+		out = append(out, snippetPart{Text: "…"})
+	}
+	at := from
+	for _, h := range hits {
+		if h.end <= at || h.start >= to {
+			continue // overlaps an earlier hit, or cut off
+		}
+		if h.start > at {
+			out = append(out, snippetPart{Text: s[at:h.start]})
+		} else {
+			h.start = at
+		}
+		if h.end > to {
+			h.end = to
+		}
+		out = append(out, snippetPart{Text: s[h.start:h.end], Hit: true})
+		at = h.end
+	}
+	if at < to {
+		out = append(out, snippetPart{Text: s[at:to]})
+	}
+	if to < len(s) {
+		out = append(out, snippetPart{Text: "…"})
+	}
+	return out
+}
+
+// HTML writes the snippet as HTML, with hits in <mark> tags.
+func (s snippet) HTML() string {
+	var b strings.Builder
+	for _, p := range s {
+		if p.Hit {
+			b.WriteString("<mark>" + html.EscapeString(p.Text) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(p.Text))
+		}
+	}
+	return b.String()
+}
+
+// match scores a task against the terms, returning false unless every
+// term is found.
+func match(terms []searchTerm, task Task, list TaskList) (searchResult, bool) {
+	result := searchResult{Task: task, List: list}
+	title, listTitle, details := splitWords(task.Title), splitWords(list.Title), splitWords(task.Details)
+	var titleHits, listHits, detailsHits []span
+	for _, term := range terms {
+		inTitle, inList, inDetails := term.find(title), term.find(listTitle), term.find(details)
+		// long details shouldn't drown out a hit in a title
+		detailsCount := len(inDetails)
+		if detailsCount > 3 {
+			detailsCount = 3
+		}
+		score := titleWeight*len(inTitle) + listWeight*len(inList) + detailsWeight*detailsCount
+		if score == 0 {
+			return result, false
+		}
+		// phrases are rarer than single words, so count for more
+		result.Score += score * len(term.words)
+		titleHits = append(titleHits, inTitle...)
+		listHits = append(listHits, inList...)
+		detailsHits = append(detailsHits, inDetails...)
+	}
+
+	result.Title = highlight(task.Title, titleHits, 0)
+	result.ListTitle = highlight(list.Title, listHits, 0)
+	if len(detailsHits) > 0 {
+		result.Details = highlight(task.Details, detailsHits, snippetWidth)
+	}
+	return result, true
+}
+
+// search finds the user's tasks matching a query, best first. Unfinished
+// tasks come before finished ones with the same score.
+func search(user User, q string) ([]searchResult, error) {
+	terms, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := userLists(user)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []searchResult{}
+	for _, list := range lists {
+		tasks, err := store.Tasks(list.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			if result, ok := match(terms, task, list); ok {
+				results = append(results, result)
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return !a.Task.Completed && b.Task.Completed
+	})
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results, nil
+}
+
+// searchPage is what search.html shows.
+type searchPage struct {
+	Query   string
+	Error   string
+	Results []searchResult
+}
+
+// searchHandler Shows the user's tasks matching ?q=.
+func searchHandler(w http.ResponseWriter, r *http.Request, user User) {
+	page := searchPage{Query: r.FormValue("q")}
+	status := http.StatusOK
+	if strings.TrimSpace(page.Query) != "" {
+		var err error
+		page.Results, err = search(user, page.Query)
+		if _, ok := err.(badInput); ok {
+			page.Error, status = err.Error(), http.StatusBadRequest
+		} else if err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "search.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
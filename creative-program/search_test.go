@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"Laundry", "[{[laundry] false}]"},
+		{"laun* tv", "[{[laun] true} {[tv] false}]"},
+		{`"more  Laundry" watch`, "[{[more laundry] false} {[watch] false}]"},
+		{`e-mail "unclosed phrase`, "[{[e mail] false} {[unclosed phrase] false}]"},
+	}
+	for _, test := range tests {
+		terms, err := parseQuery(test.q)
+		if got := fmt.Sprint(terms); err != nil || got != test.want {
+			t.Errorf("parseQuery(%q) = %s, %v; want %s", test.q, got, err, test.want)
+		}
+	}
+	for _, bad := range []string{"", ` "" * `, strings.Repeat("word ", maxSearchTerms+1)} {
+		if _, err := parseQuery(bad); err == nil {
+			t.Errorf("parseQuery(%q) should fail", bad)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	terms, _ := parseQuery(`"more laundry" soa*`)
+	text := "Do more laundry & <don't> forget the soap"
+	words := splitWords(text)
+	hits := append(terms[0].find(words), terms[1].find(words)...)
+	if got := highlight(text, hits, 0).HTML(); got != "Do <mark>more laundry</mark> &amp; &lt;don&#39;t&gt; forget the <mark>soap</mark>" {
+		t.Errorf("highlight = %s", got)
+	}
+
+	long := strings.Repeat("blah ", 40) + "find me " + strings.Repeat("blah ", 40)
+	terms, _ = parseQuery("find")
+	got := highlight(long, terms[0].find(splitWords(long)), 30).HTML()
+	if !strings.HasPrefix(got, "…blah") || !strings.Contains(got, "<mark>find</mark> me") || !strings.HasSuffix(got, "blah…") || len(got) > 60 {
+		t.Errorf("snippet = %q", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		luis := loginAs(t, "Luis", "Bosquez")
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		store.CreateTask(&Task{Title: "Buy soap", Details: "for the laundry", TaskListID: list.ID})
+
+		titles := func(results []searchResult) string {
+			var s []string
+			for _, r := range results {
+				s = append(s, r.Task.Title)
+			}
+			return strings.Join(s, ";")
+		}
+		for q, want := range map[string]string{
+			"laundry":           "Do more laundry;Buy soap", // a title hit beats one in the details
+			"LAUN*":             "Do more laundry;Buy soap",
+			`"more laundry"`:    "Do more laundry",
+			`"laundry more"`:    "",
+			"luis watch":        "Watch TV", // the list's title counts
+			"laundry dishes":    "",
+			"lawn":              "", // Meet's, not Luis's
+			`"the laundry" bu*`: "Buy soap",
+		} {
+			results, err := search(user, q)
+			if got := titles(results); err != nil || got != want {
+				t.Errorf("search(%q) = %s, %v; want %s", q, got, err, want)
+			}
+		}
+
+		get := func(q string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", (&url.URL{Path: "/search/", RawQuery: url.Values{"q": {q}}.Encode()}).String(), nil)
+			r.AddCookie(luis)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+		w := get("soap")
+		if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "Buy <mark>soap</mark>") {
+			t.Errorf("search page: %d %s", w.Code, body)
+		}
+		if w := get(`"`); w.Code != http.StatusBadRequest {
+			t.Errorf("empty search: %d", w.Code)
+		}
+
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{luis}}
+		w = c.call("GET", "/api/v1/search?q=laundry", "")
+		var results []apiSearchResult
+		json.NewDecoder(w.Body).Decode(&results)
+		if w.Code != http.StatusOK || len(results) != 2 || results[1].Snippet != "for the <mark>laundry</mark>" || results[0].Score <= results[1].Score {
+			t.Errorf("API search: %d %+v", w.Code, results)
+		}
+	})
+}
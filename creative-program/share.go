@@ -0,0 +1,267 @@
+package main
+
+/*
+	## Sharing
+	A list belongs to the user who made it, who can share it with other
+	users by name from its /share page. Each member has a role:
+
+	| role   | may                                             |
+	| ------ | ----------------------------------------------- |
+	| viewer | see the list and its tasks                      |
+	| editor | also add, change, complete and delete its tasks |
+	| owner  | also rename, move, delete and share the list    |
+
+	Sharing starts as an invitation, shown at the top of the invited user's
+	/view until they accept or decline it. Accepted lists appear on /view
+	after the user's own lists. Members can leave a list from its /share
+	page, and owners can change roles or remove members there.
+
+	Pages find lists by ID, so a shared list may have the same title as one
+	of the user's own. Where a list is still named by title (old title-based
+	paths, todo.txt sync), the user's own list wins (see userListByTitle).
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// the roles a member can have, and the access each gives (see authz.go)
+var roleAccess = map[string]access{
+	"viewer": readAccess,
+	"editor": writeAccess,
+	"owner":  ownerAccess,
+}
+
+// parseRole checks a role name.
+func parseRole(s string) (string, error) {
+	role := strings.ToLower(strings.TrimSpace(s))
+	if _, ok := roleAccess[role]; !ok {
+		return "", badInput("role must be viewer, editor or owner")
+	}
+	return role, nil
+}
+
+// invite asks another user to join a list with a role.
+func invite(list TaskList, invitee User, role string) (Member, error) {
+	role, err := parseRole(role)
+	if err != nil {
+		return Member{}, err
+	}
+	if invitee.ID == list.UserID {
+		return Member{}, badInput("that user already owns the list")
+	}
+	if _, err := store.FindMember(list.ID, invitee.ID); err != ErrNotFound {
+		if err == nil {
+			err = badInput("that user is already a member or invited")
+		}
+		return Member{}, err
+	}
+	member := Member{TaskListID: list.ID, UserID: invitee.ID, Role: role}
+	return member, store.CreateMember(&member)
+}
+
+// findInvitee finds the user to invite by name.
+func findInvitee(first, last string) (User, error) {
+	user, err := store.FindUser(strings.TrimSpace(first), strings.TrimSpace(last))
+	if err == ErrNotFound {
+		return User{}, badInput("there's no user by that name")
+	}
+	return user, err
+}
+
+// accept joins the user to a list they were invited to.
+func accept(member *Member) error {
+	member.Accepted = true
+	return store.SaveMember(member)
+}
+
+// listUsers returns the IDs of everyone in a list: its owner, then the
+// members who have accepted.
+func listUsers(list TaskList) ([]uint, error) {
+	members, err := store.Members(list.ID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []uint{list.UserID}
+	for _, m := range members {
+		if m.Accepted {
+			ids = append(ids, m.UserID)
+		}
+	}
+	return ids, nil
+}
+
+type (
+	// shareMember is a member as share.html shows them.
+	shareMember struct {
+		Member
+		Name string
+	}
+
+	// sharePage is what share.html shows.
+	sharePage struct {
+		Error   string
+		List    TaskList
+		Owner   string // who made the list
+		IsOwner bool   // whether the user may share it
+		Self    *Member
+		Members []shareMember
+		CSRF    string // the session's token, for the forms (see csrf.go)
+	}
+
+	// invitation is an open invitation as tasks.html shows it.
+	invitation struct {
+		Member
+		List  string
+		Owner string
+	}
+)
+
+// userName is a user's full name.
+func userName(u User) string {
+	return u.FirstName + " " + u.LastName
+}
+
+// renderShare shows a list's members.
+func renderShare(w http.ResponseWriter, r *http.Request, status int, user User, list TaskList, msg string) {
+	page := sharePage{Error: msg, List: list, CSRF: pageToken(r)}
+	have, err := listAccess(user, list)
+	page.IsOwner = have == ownerAccess
+	var owner User
+	if err == nil {
+		owner, err = store.GetUser(list.UserID)
+		page.Owner = userName(owner)
+	}
+	var members []Member
+	if err == nil {
+		members, err = store.Members(list.ID)
+	}
+	for _, m := range members {
+		var u User
+		if u, err = store.GetUser(m.UserID); err != nil {
+			break
+		}
+		if m.UserID == user.ID {
+			self := m
+			page.Self = &self
+		}
+		page.Members = append(page.Members, shareMember{m, userName(u)})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "share.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// shareHandler Shows a list's members (GET /lists/id/share), or invites a
+// user by name, changes a member's role, or removes a member (POST).
+// Members may remove themselves to leave the list; everything else is for
+// owners.
+// Redirects user to the updated members.
+func shareHandler(w http.ResponseWriter, r *http.Request, user User) {
+	list, err := userList(user, pathID(r, "id"), readAccess)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderShare(w, r, http.StatusOK, user, list, "")
+		return
+	}
+
+	redirect := fmt.Sprintf("/lists/%d/share", list.ID)
+	var member Member
+	if id := r.FormValue("member"); id != "" {
+		n, _ := strconv.ParseUint(id, 10, 0)
+		if member, err = userMember(user, uint(n), ownerAccess); err == nil && member.TaskListID != list.ID {
+			err = ErrNotFound
+		}
+		if err == nil && r.FormValue("remove") != "" {
+			err = store.DeleteMember(member)
+			if member.UserID == user.ID {
+				redirect = "/view/"
+			}
+		} else if err == nil {
+			// members can't change their own role
+			if err = authorizeList(user, list, ownerAccess); err == nil {
+				member.Role, err = parseRole(r.FormValue("role"))
+			}
+			if err == nil {
+				err = store.SaveMember(&member)
+			}
+		}
+	} else if err = authorizeList(user, list, ownerAccess); err == nil {
+		var invitee User
+		if invitee, err = findInvitee(r.FormValue("first name"), r.FormValue("last name")); err == nil {
+			member, err = invite(list, invitee, r.FormValue("role"))
+		}
+	}
+	if _, ok := err.(badInput); ok {
+		renderShare(w, r, http.StatusBadRequest, user, list, err.Error())
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	refreshMember(member)
+
+	http.Redirect(w, r, (&url.URL{Path: redirect}).String(), http.StatusFound)
+}
+
+// invitations lists the user's open invitations.
+func invitations(user User) ([]invitation, error) {
+	members, err := store.Memberships(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var invites []invitation
+	for _, m := range members {
+		if m.Accepted {
+			continue
+		}
+		list, err := store.GetList(m.TaskListID)
+		if err != nil {
+			return nil, err
+		}
+		owner, err := store.GetUser(list.UserID)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invitation{m, list.Title, userName(owner)})
+	}
+	return invites, nil
+}
+
+// inviteHandler Accepts or declines an invitation (POST /invites/id).
+// Redirects user to the updated view.
+func inviteHandler(w http.ResponseWriter, r *http.Request, user User) {
+	member, err := store.GetMember(pathID(r, "id"))
+	if err == nil && (member.UserID != user.ID || member.Accepted) {
+		err = ErrNotFound
+	}
+	if err == nil {
+		if r.FormValue("accept") != "" {
+			err = accept(&member)
+		} else {
+			err = store.DeleteMember(member)
+		}
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	refreshMember(member)
+
+	retToView(w, r)
+}
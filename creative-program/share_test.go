@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestStoreMembers(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			list := TaskList{Title: "Groceries", UserID: 1}
+			s.CreateList(&list)
+			for _, m := range []Member{{TaskListID: list.ID, UserID: 2, Role: "editor"}, {TaskListID: list.ID, UserID: 3, Role: "viewer"}} {
+				if err := s.CreateMember(&m); err != nil {
+					t.Fatal("CreateMember: ", err)
+				}
+			}
+
+			member, err := s.FindMember(list.ID, 3)
+			if err != nil || member.Role != "viewer" || member.Accepted {
+				t.Fatalf("FindMember = %+v, %v", member, err)
+			}
+			member.Accepted = true
+			s.SaveMember(&member)
+			if m, _ := s.GetMember(member.ID); !m.Accepted {
+				t.Error("SaveMember did not persist acceptance")
+			}
+			if members, _ := s.Members(list.ID); len(members) != 2 || members[0].UserID != 2 {
+				t.Errorf("Members = %v", members)
+			}
+			if members, _ := s.Memberships(3); len(members) != 1 || members[0].ID != member.ID {
+				t.Errorf("Memberships = %v", members)
+			}
+
+			if err := s.DeleteMember(member); err != nil {
+				t.Fatal("DeleteMember: ", err)
+			}
+			if _, err := s.FindMember(list.ID, 3); err != ErrNotFound {
+				t.Errorf("FindMember after delete: %v", err)
+			}
+			s.DeleteList(list)
+			if members, _ := s.Members(list.ID); len(members) != 0 {
+				t.Errorf("DeleteList left members %v", members)
+			}
+		})
+	}
+}
+
+func TestSharing(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea, luis := loginAs(t, "Andrea", "Lam"), loginAs(t, "Luis", "Bosquez")
+		send := func(c *http.Cookie, method, path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(r, c)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+		invite := func(first, last, role string) url.Values {
+			return url.Values{"first name": {first}, "last name": {last}, "role": {role}}
+		}
+
+		for _, bad := range []url.Values{
+			invite("No", "Body", "editor"),
+			invite("Andrea", "Lam", "editor"),
+			invite("Luis", "Bosquez", "boss"),
+		} {
+			if w := send(andrea, "POST", idPath(t, "share", "Andrea's list"), bad); w.Code != http.StatusBadRequest {
+				t.Errorf("invite %v: %d", bad, w.Code)
+			}
+		}
+		if w := send(andrea, "POST", idPath(t, "share", "Andrea's list"), invite("Luis", "Bosquez", "editor")); w.Code != http.StatusFound {
+			t.Fatalf("invite: %d %s", w.Code, w.Body)
+		}
+		if w := send(andrea, "POST", idPath(t, "share", "Andrea's list"), invite("Luis", "Bosquez", "viewer")); w.Code != http.StatusBadRequest {
+			t.Errorf("second invite: %d", w.Code)
+		}
+
+		// the invitation shows on Luis's view, but the list isn't his yet
+		w := send(luis, "GET", "/view/", nil)
+		if body := w.Body.String(); !strings.Contains(body, "Andrea Lam invited you") || strings.Contains(body, "Do laundry") {
+			t.Errorf("view with invitation: %s", body)
+		}
+		if w := send(luis, "POST", idPath(t, "mark", "Andrea's list", "Do laundry"), nil); w.Code != http.StatusNotFound {
+			t.Errorf("mark before accepting: %d", w.Code)
+		}
+
+		user, _ := store.FindUser("Luis", "Bosquez")
+		invites, _ := invitations(user)
+		if len(invites) != 1 {
+			t.Fatalf("invitations = %v", invites)
+		}
+		id := fmt.Sprint(invites[0].ID)
+		if w := send(andrea, "POST", "/invites/"+id, url.Values{"accept": {"Accept"}}); w.Code != http.StatusNotFound {
+			t.Errorf("accepting someone else's invitation: %d", w.Code)
+		}
+		if w := send(luis, "POST", "/invites/"+id, url.Values{"accept": {"Accept"}}); w.Code != http.StatusFound {
+			t.Fatalf("accept: %d %s", w.Code, w.Body)
+		}
+		if body := send(luis, "GET", "/view/", nil).Body.String(); !strings.Contains(body, "shared by Andrea Lam (editor)") || !strings.Contains(body, "Do laundry") {
+			t.Errorf("view with shared list: %s", body)
+		}
+
+		// editors change tasks but not the list
+		if w := send(luis, "POST", idPath(t, "mark", "Andrea's list", "Do laundry"), nil); w.Code != http.StatusFound {
+			t.Errorf("editor marking: %d %s", w.Code, w.Body)
+		}
+		for _, path := range []string{idPath(t, "delete", "Andrea's list"), idPath(t, "edit", "Andrea's list"), idPath(t, "move", "Andrea's list")} {
+			if w := send(luis, "POST", path, url.Values{"list title": {"Luis's now"}}); w.Code != http.StatusForbidden {
+				t.Errorf("editor %s: %d", path, w.Code)
+			}
+		}
+		if w := send(luis, "POST", idPath(t, "share", "Andrea's list"), invite("Meet", "Bhagdev", "viewer")); w.Code != http.StatusForbidden {
+			t.Errorf("editor inviting: %d", w.Code)
+		}
+
+		// viewers only look
+		send(andrea, "POST", idPath(t, "share", "Andrea's list"), url.Values{"member": {id}, "role": {"viewer"}})
+		if w := send(luis, "POST", idPath(t, "add", "Andrea's list"), url.Values{"title": {"Sneaky"}}); w.Code != http.StatusForbidden {
+			t.Errorf("viewer adding: %d", w.Code)
+		}
+		if w := send(luis, "POST", idPath(t, "share", "Andrea's list"), url.Values{"member": {id}, "role": {"owner"}}); w.Code != http.StatusForbidden {
+			t.Errorf("viewer promoting themselves: %d", w.Code)
+		}
+		if body := send(luis, "GET", idPath(t, "share", "Andrea's list"), nil).Body.String(); !strings.Contains(body, "Luis Bosquez <span class=\"role\">viewer") {
+			t.Errorf("share page: %s", body)
+		}
+
+		// leaving takes the list away again
+		if w := send(luis, "POST", idPath(t, "share", "Andrea's list"), url.Values{"member": {id}, "remove": {"Leave"}}); w.Code != http.StatusFound {
+			t.Errorf("leave: %d", w.Code)
+		}
+		if w := send(luis, "GET", idPath(t, "share", "Andrea's list"), nil); w.Code != http.StatusNotFound {
+			t.Errorf("share page after leaving: %d", w.Code)
+		}
+
+		// declining drops the invitation
+		send(andrea, "POST", idPath(t, "share", "Andrea's list"), invite("Luis", "Bosquez", "viewer"))
+		invites, _ = invitations(user)
+		if w := send(luis, "POST", fmt.Sprint("/invites/", invites[0].ID), url.Values{"decline": {"Decline"}}); w.Code != http.StatusFound {
+			t.Errorf("decline: %d", w.Code)
+		}
+		if invites, _ = invitations(user); len(invites) != 0 {
+			t.Errorf("invitations after declining = %v", invites)
+		}
+
+		// pages find lists by ID, so one titled like Luis's own is fine
+		send(luis, "POST", "/add/", url.Values{"list title": {"Andrea's list"}})
+		send(andrea, "POST", idPath(t, "share", "Andrea's list"), invite("Luis", "Bosquez", "viewer"))
+		invites, _ = invitations(user)
+		if w := send(luis, "POST", fmt.Sprint("/invites/", invites[0].ID), url.Values{"accept": {"Accept"}}); w.Code != http.StatusFound {
+			t.Errorf("accepting a list titled like Luis's own: %d", w.Code)
+		}
+		lists, _ := userLists(user)
+		same := 0
+		for _, l := range lists {
+			if l.Title == "Andrea's list" {
+				same++
+			}
+		}
+		if same != 2 {
+			t.Errorf("Luis's lists = %v", lists)
+		}
+	})
+}
+
+func TestSharingAPI(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		ac := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{loginAs(t, "Andrea", "Lam")}}
+		lc := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{loginAs(t, "Luis", "Bosquez")}}
+		andrea, _ := store.FindUser("Andrea", "Lam")
+		luis, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(andrea.ID, "Andrea's list")
+		task, _ := store.FindTask(list.ID, "Do laundry")
+
+		w := ac.call("POST", fmt.Sprintf("/api/v1/lists/%d/members", list.ID), fmt.Sprintf(`{"user_id": %d, "role": "editor"}`, luis.ID))
+		var member apiMember
+		json.NewDecoder(w.Body).Decode(&member)
+		if w.Code != http.StatusCreated || member.FirstName != "Luis" || member.Accepted {
+			t.Fatalf("invite: %d %+v", w.Code, member)
+		}
+
+		w = lc.call("GET", fmt.Sprintf("/api/v1/users/%d/invites", luis.ID), "")
+		var invites []apiMember
+		json.NewDecoder(w.Body).Decode(&invites)
+		if w.Code != http.StatusOK || len(invites) != 1 || invites[0].ListTitle != "Andrea's list" {
+			t.Errorf("invites: %d %+v", w.Code, invites)
+		}
+		if w := ac.call("PATCH", fmt.Sprintf("/api/v1/members/%d", member.ID), `{"accepted": true}`); w.Code != http.StatusBadRequest {
+			t.Errorf("owner accepting for Luis: %d", w.Code)
+		}
+		if w := lc.call("PATCH", fmt.Sprintf("/api/v1/members/%d", member.ID), `{"accepted": true}`); w.Code != http.StatusOK {
+			t.Fatalf("accept: %d %s", w.Code, w.Body)
+		}
+
+		w = lc.call("GET", fmt.Sprintf("/api/v1/users/%d/lists", luis.ID), "")
+		var lists []apiList
+		json.NewDecoder(w.Body).Decode(&lists)
+		if len(lists) != 3 || lists[2].ID != list.ID {
+			t.Errorf("Luis's lists: %+v", lists)
+		}
+		if w := lc.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", task.ID), `{"details": "Whites only", "tags": ["mine"]}`); w.Code != http.StatusOK {
+			t.Errorf("editor PATCHing a task: %d %s", w.Code, w.Body)
+		}
+		if w := lc.call("PATCH", fmt.Sprintf("/api/v1/lists/%d", list.ID), `{"title": "Luis's now"}`); w.Code != http.StatusForbidden {
+			t.Errorf("editor renaming the list: %d", w.Code)
+		}
+		if w := lc.call("POST", fmt.Sprintf("/api/v1/lists/%d/members", list.ID), `{"first_name": "Meet", "last_name": "Bhagdev", "role": "viewer"}`); w.Code != http.StatusForbidden {
+			t.Errorf("editor inviting: %d", w.Code)
+		}
+
+		// each member keeps their own tags on a shared task
+		ac.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", task.ID), `{"tags": ["hers"]}`)
+		var got apiTask
+		json.NewDecoder(lc.call("GET", fmt.Sprintf("/api/v1/tasks/%d", task.ID), "").Body).Decode(&got)
+		if strings.Join(got.Tags, ",") != "mine" {
+			t.Errorf("Luis's tags: %v", got.Tags)
+		}
+		json.NewDecoder(ac.call("GET", fmt.Sprintf("/api/v1/tasks/%d", task.ID), "").Body).Decode(&got)
+		if strings.Join(got.Tags, ",") != "hers" || got.Details != "Whites only" {
+			t.Errorf("Andrea's task: %+v", got)
+		}
+
+		if w := ac.call("PATCH", fmt.Sprintf("/api/v1/members/%d", member.ID), `{"role": "viewer"}`); w.Code != http.StatusOK {
+			t.Errorf("change role: %d %s", w.Code, w.Body)
+		}
+		if w := lc.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", task.ID), `{"completed": true}`); w.Code != http.StatusForbidden {
+			t.Errorf("viewer PATCHing a task: %d", w.Code)
+		}
+		if w := lc.call("DELETE", fmt.Sprintf("/api/v1/members/%d", member.ID), ""); w.Code != http.StatusNoContent {
+			t.Errorf("leave: %d", w.Code)
+		}
+		if w := lc.call("GET", fmt.Sprintf("/api/v1/lists/%d", list.ID), ""); w.Code != http.StatusNotFound {
+			t.Errorf("list after leaving: %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,486 @@
+package main
+
+/*
+	## Storage
+	Handlers never talk to a database directly. Instead they go through a
+	TaskStore, which hides whether Users, TaskLists, Tasks, Tags, Members and
+	Webhooks live in SQL
+	Server, Postgres, an embedded SQLite file, or plain memory. The store is
+	chosen by the -store setting (see config.go).
+
+	| store    | backed by                                   |
+	| -------- | ------------------------------------------- |
+	| mssql    | a SQL Server database                       |
+	| postgres | a Postgres database                         |
+	| sqlite   | a local file (no database service required) |
+	| memory   | maps in this process (lost on exit)         |
+
+	The memory store is handy for tests and for trying the app on a laptop.
+*/
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	// register sql drivers
+	_ "github.com/jinzhu/gorm/dialects/mssql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// ErrNotFound is returned by a TaskStore when no matching record exists.
+var ErrNotFound = errors.New("record not found")
+
+// TaskStore is the set of operations the handlers perform on the model.
+type TaskStore interface {
+	// GetUser finds a user by ID.
+	GetUser(id uint) (User, error)
+	// FindUser finds a user by name.
+	FindUser(first, last string) (User, error)
+	// CreateUser adds a new user.
+	CreateUser(user *User) error
+	// SaveUser updates an existing user.
+	SaveUser(user *User) error
+	// FindUserByFeed finds the user with a calendar feed token.
+	FindUserByFeed(token string) (User, error)
+
+	// GetList finds a list by ID.
+	GetList(id uint) (TaskList, error)
+	// Lists returns all of a user's lists, by position.
+	Lists(userID uint) ([]TaskList, error)
+	// FindList finds one of a user's lists by title.
+	FindList(userID uint, title string) (TaskList, error)
+	// FindListByFeed finds the list with a calendar feed token.
+	FindListByFeed(token string) (TaskList, error)
+	// CreateList adds a new list after the user's others.
+	CreateList(list *TaskList) error
+	// SaveList updates an existing list.
+	SaveList(list *TaskList) error
+	// DeleteList deletes a list and all tasks and members within.
+	DeleteList(list TaskList) error
+
+	// GetTask finds a task by ID.
+	GetTask(id uint) (Task, error)
+	// Tasks returns all tasks in a list, by position.
+	Tasks(listID uint) ([]Task, error)
+	// FindTask finds a task in a list by title.
+	FindTask(listID uint, title string) (Task, error)
+	// CreateTask adds a new task after the others with the same parent.
+	CreateTask(task *Task) error
+	// SaveTask updates an existing task, except for RemindedFor, which only
+	// MarkReminded changes.
+	SaveTask(task *Task) error
+	// DeleteTask deletes a task and all its subtasks.
+	DeleteTask(task Task) error
+	// Reminders returns the unfinished tasks with reminders, from every list.
+	Reminders() ([]Task, error)
+	// MarkReminded records the reminder time last sent for a task, leaving
+	// its UpdatedAt alone.
+	MarkReminded(taskID uint, at *time.Time) error
+
+	// GetTag finds a tag by ID.
+	GetTag(id uint) (Tag, error)
+	// Tags returns all of a user's tags, by name.
+	Tags(userID uint) ([]Tag, error)
+	// FindTag finds one of a user's tags by name.
+	FindTag(userID uint, name string) (Tag, error)
+	// CreateTag adds a new tag.
+	CreateTag(tag *Tag) error
+	// SaveTag updates an existing tag.
+	SaveTag(tag *Tag) error
+	// DeleteTag deletes a tag, taking it off every task.
+	DeleteTag(tag Tag) error
+	// TaskTags returns the tags on a task, by name.
+	TaskTags(taskID uint) ([]Tag, error)
+	// SetTaskTags replaces the tags on a task.
+	SetTaskTags(taskID uint, tagIDs []uint) error
+	// TaggedTasks returns the tasks carrying a tag, from every list.
+	TaggedTasks(tagID uint) ([]Task, error)
+
+	// GetMember finds a membership by ID.
+	GetMember(id uint) (Member, error)
+	// Members returns a list's members and invitations, oldest first.
+	Members(listID uint) ([]Member, error)
+	// Memberships returns a user's memberships and invitations, oldest first.
+	Memberships(userID uint) ([]Member, error)
+	// FindMember finds a user's membership of (or invitation to) a list.
+	FindMember(listID, userID uint) (Member, error)
+	// CreateMember adds a new membership or invitation.
+	CreateMember(member *Member) error
+	// SaveMember updates an existing membership.
+	SaveMember(member *Member) error
+	// DeleteMember ends a membership or withdraws an invitation.
+	DeleteMember(member Member) error
+
+	// GetWebhook finds a webhook by ID.
+	GetWebhook(id uint) (Webhook, error)
+	// Webhooks returns a user's webhooks, oldest first.
+	Webhooks(userID uint) ([]Webhook, error)
+	// CreateWebhook adds a new webhook.
+	CreateWebhook(hook *Webhook) error
+	// SaveWebhook updates an existing webhook.
+	SaveWebhook(hook *Webhook) error
+	// DeleteWebhook deletes a webhook and its deliveries.
+	DeleteWebhook(hook Webhook) error
+	// Deliveries returns a webhook's deliveries, newest first.
+	Deliveries(webhookID uint) ([]Delivery, error)
+	// PendingDeliveries returns the deliveries due a try by now, oldest first.
+	PendingDeliveries(now time.Time) ([]Delivery, error)
+	// CreateDelivery adds a new delivery.
+	CreateDelivery(delivery *Delivery) error
+	// SaveDelivery updates an existing delivery.
+	SaveDelivery(delivery *Delivery) error
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// NewStore opens the store named by cfg.Store.
+func NewStore(cfg Config) (TaskStore, error) {
+	if cfg.Store == "memory" {
+		return newMemStore(), nil
+	}
+	db, err := Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newGormStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// gormStore keeps the model in a SQL database via gorm.
+type gormStore struct {
+	db *gorm.DB
+}
+
+// newGormStore wraps a gorm connection, bringing its schema up to date.
+func newGormStore(db *gorm.DB) (*gormStore, error) {
+	if err := Migrate(db, latestVersion()); err != nil {
+		return nil, err
+	}
+	return &gormStore{db}, nil
+}
+
+// notFound converts gorm's missing record error to ErrNotFound.
+func notFound(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *gormStore) GetUser(id uint) (User, error) {
+	var user User
+	err := s.db.First(&user, id).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) FindUser(first, last string) (User, error) {
+	var user User
+	err := s.db.Where("first_name = ? AND last_name = ?", first, last).First(&user).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) CreateUser(user *User) error {
+	return s.db.Create(user).Error
+}
+
+func (s *gormStore) SaveUser(user *User) error {
+	return s.db.Save(user).Error
+}
+
+func (s *gormStore) FindUserByFeed(token string) (User, error) {
+	var user User
+	if token == "" {
+		return user, ErrNotFound
+	}
+	err := s.db.Where("feed_token = ?", token).First(&user).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) GetList(id uint) (TaskList, error) {
+	var list TaskList
+	err := s.db.First(&list, id).Error
+	return list, notFound(err)
+}
+
+func (s *gormStore) Lists(userID uint) ([]TaskList, error) {
+	var lists []TaskList
+	err := s.db.Where("user_id = ?", userID).Order("position, id").Find(&lists).Error
+	return lists, err
+}
+
+func (s *gormStore) FindList(userID uint, title string) (TaskList, error) {
+	var list TaskList
+	err := s.db.Where("user_id = ? AND title = ?", userID, title).First(&list).Error
+	return list, notFound(err)
+}
+
+func (s *gormStore) FindListByFeed(token string) (TaskList, error) {
+	var list TaskList
+	if token == "" {
+		return list, ErrNotFound
+	}
+	err := s.db.Where("feed_token = ?", token).First(&list).Error
+	return list, notFound(err)
+}
+
+// nextPosition finds the position after the last row of a table matching
+// the query.
+func (s *gormStore) nextPosition(table, query string, args ...interface{}) (int, error) {
+	var last struct{ Position *int }
+	err := s.db.Table(table).Select("MAX(position) AS position").
+		Where(query+" AND deleted_at IS NULL", args...).Scan(&last).Error
+	if err != nil || last.Position == nil {
+		return 0, err
+	}
+	return *last.Position + 1, nil
+}
+
+func (s *gormStore) CreateList(list *TaskList) error {
+	var err error
+	if list.Position, err = s.nextPosition("task_lists", "user_id = ?", list.UserID); err != nil {
+		return err
+	}
+	return s.db.Create(list).Error
+}
+
+func (s *gormStore) SaveList(list *TaskList) error {
+	return s.db.Save(list).Error
+}
+
+func (s *gormStore) DeleteList(list TaskList) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		tasks := tx.Table("tasks").Select("id").Where("task_list_id = ?", list.ID).SubQuery()
+		if err := tx.Where("task_id IN ?", tasks).Delete(&taskTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_list_id = ?", list.ID).Delete(&Task{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_list_id = ?", list.ID).Delete(&Member{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&list).Error
+	})
+}
+
+func (s *gormStore) GetTask(id uint) (Task, error) {
+	var task Task
+	err := s.db.First(&task, id).Error
+	return task, notFound(err)
+}
+
+func (s *gormStore) Tasks(listID uint) ([]Task, error) {
+	var tasks []Task
+	err := s.db.Where("task_list_id = ?", listID).Order("position, id").Find(&tasks).Error
+	return tasks, err
+}
+
+func (s *gormStore) FindTask(listID uint, title string) (Task, error) {
+	var task Task
+	err := s.db.Where("task_list_id = ? AND title = ?", listID, title).First(&task).Error
+	return task, notFound(err)
+}
+
+func (s *gormStore) CreateTask(task *Task) error {
+	var err error
+	task.Position, err = s.nextPosition("tasks", "task_list_id = ? AND parent_id = ?", task.TaskListID, task.ParentID)
+	if err != nil {
+		return err
+	}
+	return s.db.Create(task).Error
+}
+
+func (s *gormStore) SaveTask(task *Task) error {
+	return s.db.Omit("reminded_for").Save(task).Error
+}
+
+func (s *gormStore) DeleteTask(task Task) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var tasks []Task
+		if err := tx.Where("task_list_id = ?", task.TaskListID).Find(&tasks).Error; err != nil {
+			return err
+		}
+		ids := subtree(tasks, task.ID)
+		if err := tx.Where("task_id IN (?)", ids).Delete(&taskTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN (?)", ids).Delete(&Task{}).Error
+	})
+}
+
+func (s *gormStore) Reminders() ([]Task, error) {
+	var tasks []Task
+	err := s.db.Where("reminder <> '' AND completed = ?", false).Order("id").Find(&tasks).Error
+	return tasks, err
+}
+
+func (s *gormStore) MarkReminded(taskID uint, at *time.Time) error {
+	res := s.db.Model(&Task{}).Where("id = ?", taskID).UpdateColumn("reminded_for", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) GetTag(id uint) (Tag, error) {
+	var tag Tag
+	err := s.db.First(&tag, id).Error
+	return tag, notFound(err)
+}
+
+func (s *gormStore) Tags(userID uint) ([]Tag, error) {
+	var tags []Tag
+	err := s.db.Where("user_id = ?", userID).Order("name").Find(&tags).Error
+	return tags, err
+}
+
+func (s *gormStore) FindTag(userID uint, name string) (Tag, error) {
+	var tag Tag
+	err := s.db.Where("user_id = ? AND name = ?", userID, name).First(&tag).Error
+	return tag, notFound(err)
+}
+
+func (s *gormStore) CreateTag(tag *Tag) error {
+	return s.db.Create(tag).Error
+}
+
+func (s *gormStore) SaveTag(tag *Tag) error {
+	return s.db.Save(tag).Error
+}
+
+func (s *gormStore) DeleteTag(tag Tag) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tag_id = ?", tag.ID).Delete(&taskTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	})
+}
+
+func (s *gormStore) TaskTags(taskID uint) ([]Tag, error) {
+	var tags []Tag
+	err := s.db.Joins("JOIN task_tags ON task_tags.tag_id = tags.id").
+		Where("task_tags.task_id = ?", taskID).Order("tags.name").Find(&tags).Error
+	return tags, err
+}
+
+func (s *gormStore) SetTaskTags(taskID uint, tagIDs []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("task_id = ?", taskID).Delete(&taskTag{}).Error; err != nil {
+			return err
+		}
+		for _, id := range tagIDs {
+			if err := tx.Create(&taskTag{TaskID: taskID, TagID: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) TaggedTasks(tagID uint) ([]Task, error) {
+	var tasks []Task
+	err := s.db.Joins("JOIN task_tags ON task_tags.task_id = tasks.id").
+		Where("task_tags.tag_id = ?", tagID).Order("task_list_id, position, id").Find(&tasks).Error
+	return tasks, err
+}
+
+func (s *gormStore) GetMember(id uint) (Member, error) {
+	var member Member
+	err := s.db.First(&member, id).Error
+	return member, notFound(err)
+}
+
+func (s *gormStore) Members(listID uint) ([]Member, error) {
+	var members []Member
+	err := s.db.Where("task_list_id = ?", listID).Order("id").Find(&members).Error
+	return members, err
+}
+
+func (s *gormStore) Memberships(userID uint) ([]Member, error) {
+	var members []Member
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&members).Error
+	return members, err
+}
+
+func (s *gormStore) FindMember(listID, userID uint) (Member, error) {
+	var member Member
+	err := s.db.Where("task_list_id = ? AND user_id = ?", listID, userID).First(&member).Error
+	return member, notFound(err)
+}
+
+func (s *gormStore) CreateMember(member *Member) error {
+	return s.db.Create(member).Error
+}
+
+func (s *gormStore) SaveMember(member *Member) error {
+	return s.db.Save(member).Error
+}
+
+func (s *gormStore) DeleteMember(member Member) error {
+	return s.db.Delete(&member).Error
+}
+
+func (s *gormStore) GetWebhook(id uint) (Webhook, error) {
+	var hook Webhook
+	err := s.db.First(&hook, id).Error
+	return hook, notFound(err)
+}
+
+func (s *gormStore) Webhooks(userID uint) ([]Webhook, error) {
+	var hooks []Webhook
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&hooks).Error
+	return hooks, err
+}
+
+func (s *gormStore) CreateWebhook(hook *Webhook) error {
+	return s.db.Create(hook).Error
+}
+
+func (s *gormStore) SaveWebhook(hook *Webhook) error {
+	return s.db.Save(hook).Error
+}
+
+func (s *gormStore) DeleteWebhook(hook Webhook) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&hook).Error
+	})
+}
+
+func (s *gormStore) Deliveries(webhookID uint) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := s.db.Where("webhook_id = ?", webhookID).Order("id desc").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (s *gormStore) PendingDeliveries(now time.Time) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := s.db.Where("next_attempt <= ?", now).Order("id").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (s *gormStore) CreateDelivery(delivery *Delivery) error {
+	return s.db.Create(delivery).Error
+}
+
+func (s *gormStore) SaveDelivery(delivery *Delivery) error {
+	return s.db.Save(delivery).Error
+}
+
+func (s *gormStore) Close() error {
+	return s.db.Close()
+}
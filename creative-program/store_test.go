@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStores opens one of each store that runs without a database service.
+func testStores(t *testing.T) map[string]TaskStore {
+	return map[string]TaskStore{"memory": openTestStore(t, "memory"), "sqlite": openTestStore(t, "sqlite")}
+}
+
+// openTestStore opens an empty store of a kind in testStores.
+func openTestStore(t *testing.T, kind string) TaskStore {
+	t.Helper()
+	if kind == "memory" {
+		return newMemStore()
+	}
+	s, err := NewStore(Config{Store: kind, DBName: filepath.Join(t.TempDir(), "tasks.db")})
+	if err != nil {
+		t.Fatal("open ", kind, ": ", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStores(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := Example(s); err != nil {
+				t.Fatal("Example: ", err)
+			}
+
+			user, err := s.FindUser("Luis", "Bosquez")
+			if err != nil || !user.CheckPassword(demoPassword) {
+				t.Fatalf("FindUser = %v, %v", user, err)
+			}
+			if _, err := s.FindUser("No", "Body"); err != ErrNotFound {
+				t.Errorf("FindUser of missing user: %v", err)
+			}
+
+			lists, err := s.Lists(user.ID)
+			if err != nil || len(lists) != 2 {
+				t.Fatalf("Lists = %v, %v", lists, err)
+			}
+
+			list, err := s.FindList(user.ID, "Luis's List")
+			if err != nil {
+				t.Fatal("FindList: ", err)
+			}
+			task, err := s.FindTask(list.ID, "Watch TV")
+			if err != nil {
+				t.Fatal("FindTask: ", err)
+			}
+			task.Completed = true
+			if err := s.SaveTask(&task); err != nil {
+				t.Fatal("SaveTask: ", err)
+			}
+			if task, _ = s.FindTask(list.ID, "Watch TV"); !task.Completed {
+				t.Error("SaveTask did not persist completion")
+			}
+
+			if err := s.DeleteTask(task); err != nil {
+				t.Fatal("DeleteTask: ", err)
+			}
+			if tasks, _ := s.Tasks(list.ID); len(tasks) != 1 {
+				t.Errorf("Tasks after delete = %v", tasks)
+			}
+
+			if err := s.DeleteList(list); err != nil {
+				t.Fatal("DeleteList: ", err)
+			}
+			if _, err := s.FindList(user.ID, list.Title); err != ErrNotFound {
+				t.Errorf("FindList after delete: %v", err)
+			}
+			if tasks, _ := s.Tasks(list.ID); len(tasks) != 0 {
+				t.Errorf("DeleteList left tasks %v", tasks)
+			}
+		})
+	}
+}
+
+// testStore is the kind of store testServer sets up (see eachStore).
+var testStore = "memory"
+
+// eachStore runs a test once for each store in testStores, as subtests
+// named for them, so handlers are tried against SQL as well as memory.
+func eachStore(t *testing.T, test func(t *testing.T)) {
+	for _, name := range []string{"memory", "sqlite"} {
+		t.Run(name, func(t *testing.T) {
+			testStore = name
+			defer func() { testStore = "memory" }()
+			test(t)
+		})
+	}
+}
+
+// testServer sets up the app with demo data in a new store (in memory
+// unless eachStore says otherwise) and returns its routes.
+func testServer(t *testing.T) http.Handler {
+	t.Helper()
+	var err error
+	if templates, err = loadTemplates("."); err != nil {
+		t.Fatal(err)
+	}
+	sessionKey = newSessionKey()
+	store = openTestStore(t, testStore)
+	if err = Example(store); err != nil {
+		t.Fatal("Example: ", err)
+	}
+	return routes()
+}
+
+// loginAs makes a session cookie for a user.
+func loginAs(t *testing.T, first, last string) *http.Cookie {
+	t.Helper()
+	user, err := store.FindUser(first, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	startSession(w, user)
+	return w.Result().Cookies()[0]
+}
+
+// withSession sends a request with a session cookie, and its CSRF token as
+// the pages' forms would.
+func withSession(r *http.Request, c *http.Cookie) {
+	r.AddCookie(c)
+	r.Header.Set(csrfHeader, csrfToken(c.Value))
+}
+
+// idPath makes the ID-based path for a verb on a demo user's list, or a
+// task in it, as the links on /view do.
+func idPath(t *testing.T, verb, list string, task ...string) string {
+	t.Helper()
+	for _, name := range [][2]string{{"Andrea", "Lam"}, {"Meet", "Bhagdev"}, {"Luis", "Bosquez"}} {
+		user, err := store.FindUser(name[0], name[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := store.FindList(user.ID, list)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(task) == 0 {
+			return fmt.Sprintf("/lists/%d/%s", l.ID, verb)
+		}
+		found, err := store.FindTask(l.ID, task[0])
+		if err != nil {
+			t.Fatalf("task %q in %q: %v", task[0], list, err)
+		}
+		return fmt.Sprintf("/tasks/%d/%s", found.ID, verb)
+	}
+	t.Fatalf("no list %q", list)
+	return ""
+}
+
+func TestHandlers(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea := loginAs(t, "Andrea", "Lam")
+
+		send := func(method, path string, form url.Values, c *http.Cookie) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if c != nil {
+				withSession(r, c)
+			}
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		if w := send("GET", "/view/", nil, nil); w.Code != http.StatusFound || w.Header().Get("Location") != "/welcome/" {
+			t.Errorf("view without session: %d %s", w.Code, w.Header().Get("Location"))
+		}
+
+		if w := send("POST", idPath(t, "mark", "Andrea's list", "Do laundry"), nil, andrea); w.Code != http.StatusFound {
+			t.Fatalf("mark: %d %s", w.Code, w.Body)
+		}
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		// laundry repeats weekly, so the finished one is renamed for its due date
+		if task, _ := store.FindTask(list.ID, "Do laundry (2017-03-30)"); !task.Completed {
+			t.Error("mark did not complete task")
+		}
+		if task, _ := store.FindTask(list.ID, "Do laundry"); task.Completed || task.DueDate == nil {
+			t.Errorf("mark did not add the next laundry: %+v", task)
+		}
+
+		if w := send("POST", "/tasks/999/mark", nil, andrea); w.Code != http.StatusNotFound {
+			t.Errorf("mark of missing task: %d", w.Code)
+		}
+
+		// a user only sees their own lists, whatever the path says
+		if w := send("POST", idPath(t, "mark", "Meet's List", "Mow the lawn"), nil, andrea); w.Code != http.StatusNotFound {
+			t.Errorf("mark of another user's task: %d", w.Code)
+		}
+
+		w := send("GET", "/view/", nil, andrea)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Do laundry") {
+			t.Errorf("view: %d %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestAccounts(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+
+		post := func(path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			mux.ServeHTTP(w, r)
+			return w
+		}
+		form := func(first, last, password string) url.Values {
+			return url.Values{"first name": {first}, "last name": {last}, "password": {password}}
+		}
+
+		w := post("/register/", form("Ivan", "Webber", "correct horse"))
+		if w.Code != http.StatusFound || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("register: %d %s", w.Code, w.Body)
+		}
+		if user, err := store.FindUser("Ivan", "Webber"); err != nil || user.PasswordHash == "correct horse" {
+			t.Errorf("registered user %+v, %v", user, err)
+		}
+
+		for _, bad := range []struct {
+			path string
+			form url.Values
+			code int
+		}{
+			{"/register/", form("Ivan", "Webber", "another one"), http.StatusBadRequest},
+			{"/register/", form("Short", "Password", "abc"), http.StatusBadRequest},
+			{"/login/", form("Ivan", "Webber", "wrong horse"), http.StatusUnauthorized},
+			{"/login/", form("No", "Body", "correct horse"), http.StatusUnauthorized},
+		} {
+			if w := post(bad.path, bad.form); w.Code != bad.code || len(w.Result().Cookies()) != 0 {
+				t.Errorf("%s %v: %d, want %d", bad.path, bad.form, w.Code, bad.code)
+			}
+		}
+
+		w = post("/login/", form("Ivan", "Webber", "correct horse"))
+		if w.Code != http.StatusFound || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("login: %d %s", w.Code, w.Body)
+		}
+		r := httptest.NewRequest("GET", "/view/", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		if user, err := sessionUser(r); err != nil || user.FirstName != "Ivan" {
+			t.Errorf("sessionUser = %+v, %v", user, err)
+		}
+
+		// a tampered cookie is rejected
+		c := w.Result().Cookies()[0]
+		c.Value = "999" + c.Value[strings.Index(c.Value, "."):]
+		r = httptest.NewRequest("GET", "/view/", nil)
+		r.AddCookie(c)
+		if _, err := sessionUser(r); err != errBadSession {
+			t.Errorf("tampered cookie: %v", err)
+		}
+	})
+}
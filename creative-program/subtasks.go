@@ -0,0 +1,166 @@
+package main
+
+/*
+	## Subtasks
+	Any task can be broken into steps. A subtask is an ordinary task in the
+	same list whose ParentID is its parent's ID (top-level tasks have 0), so
+	steps can have steps of their own to any depth. Titles stay unique across
+	the whole list, steps included, so a task can still be named by title in
+	old title-based paths, todo.txt files and imports.
+
+	Deleting a task deletes all of its subtasks (see DeleteTask in the
+	stores). A task with AutoComplete set follows its subtasks: it is
+	completed when the last unfinished one is, and reopened when one of them
+	is reopened or a new one is added.
+*/
+
+import "time"
+
+// taskNode is a task with its subtasks, as the view shows them.
+type taskNode struct {
+	Task
+	CanEdit  bool   // whether the user may change it
+	CSRF     string // the session's token, for its forms (see csrf.go)
+	Subtasks []*taskNode
+}
+
+// taskTree arranges a list's tasks under their parents, keeping their
+// order. Tasks whose parent is missing are shown at the top level.
+func taskTree(canEdit bool, csrf string, tasks []Task) []*taskNode {
+	nodes := map[uint]*taskNode{}
+	for _, t := range tasks {
+		nodes[t.ID] = &taskNode{Task: t, CanEdit: canEdit, CSRF: csrf}
+	}
+
+	var top []*taskNode
+	for _, t := range tasks {
+		node := nodes[t.ID]
+		if parent, ok := nodes[t.ParentID]; ok && t.ParentID != t.ID {
+			parent.Subtasks = append(parent.Subtasks, node)
+		} else {
+			top = append(top, node)
+		}
+	}
+	return top
+}
+
+// Steps counts the subtasks at every depth below the task.
+func (n *taskNode) Steps() int {
+	count := len(n.Subtasks)
+	for _, s := range n.Subtasks {
+		count += s.Steps()
+	}
+	return count
+}
+
+// StepsDone counts the completed subtasks at every depth below the task.
+func (n *taskNode) StepsDone() int {
+	count := 0
+	for _, s := range n.Subtasks {
+		if s.Completed {
+			count++
+		}
+		count += s.StepsDone()
+	}
+	return count
+}
+
+// subtree lists the IDs of a task and all its subtasks, given its list.
+func subtree(tasks []Task, id uint) []uint {
+	ids := []uint{id}
+	for i := 0; i < len(ids); i++ {
+		for _, t := range tasks {
+			if t.ParentID == ids[i] && t.ID != ids[i] {
+				ids = append(ids, t.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// checkParent makes sure a task may be moved under parentID: the parent
+// must be in the same list and mustn't be the task itself or one of its
+// subtasks.
+func checkParent(task Task, parentID uint) error {
+	if parentID == 0 {
+		return nil
+	}
+	parent, err := store.GetTask(parentID)
+	if err == ErrNotFound || err == nil && parent.TaskListID != task.TaskListID {
+		return badInput("the parent task must be in the same list")
+	}
+	if err != nil || task.ID == 0 {
+		return err
+	}
+
+	tasks, err := store.Tasks(task.TaskListID)
+	if err != nil {
+		return err
+	}
+	for _, id := range subtree(tasks, task.ID) {
+		if id == parentID {
+			return badInput("a task can't be a subtask of itself or its own subtasks")
+		}
+	}
+	return nil
+}
+
+// setCompleted completes or reopens a task, then lets its parent follow
+// (see settleParent). Completing a repeating task returns its next
+// occurrence (see completeTask).
+func setCompleted(task *Task, done bool, now time.Time) (*Task, error) {
+	var next *Task
+	var err error
+	if done {
+		next, err = completeTask(task, now)
+	} else {
+		task.Completed = false
+		err = store.SaveTask(task)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return next, settleParent(task.TaskListID, task.ParentID, now)
+}
+
+// settleParent completes a task with AutoComplete set when all of its
+// subtasks are complete, and reopens it when they aren't.
+func settleParent(listID, parentID uint, now time.Time) error {
+	if parentID == 0 {
+		return nil
+	}
+	parent, err := store.GetTask(parentID)
+	if err == ErrNotFound || err == nil && !parent.AutoComplete {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tasks, err := store.Tasks(listID)
+	if err != nil {
+		return err
+	}
+	steps, done := 0, 0
+	for _, t := range tasks {
+		if t.ParentID == parent.ID {
+			steps++
+			if t.Completed {
+				done++
+			}
+		}
+	}
+	if steps == 0 || (done == steps) == parent.Completed {
+		return nil
+	}
+	_, err = setCompleted(&parent, done == steps, now)
+	return err
+}
+
+// deleteTask deletes a task and its subtasks, then lets its parent follow.
+func deleteTask(task Task) error {
+	if err := store.DeleteTask(task); err != nil {
+		return err
+	}
+	return settleParent(task.TaskListID, task.ParentID, time.Now())
+}
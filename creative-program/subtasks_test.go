@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestTaskTree(t *testing.T) {
+	tasks := []Task{
+		{Model: gorm.Model{ID: 1}, Title: "Make this work"},
+		{Model: gorm.Model{ID: 2}, Title: "Write code", ParentID: 1, Completed: true},
+		{Model: gorm.Model{ID: 3}, Title: "Test it", ParentID: 1},
+		{Model: gorm.Model{ID: 4}, Title: "Unit tests", ParentID: 3, Completed: true},
+		{Model: gorm.Model{ID: 5}, Title: "Orphan", ParentID: 99},
+	}
+	top := taskTree(true, "", tasks)
+	if len(top) != 2 || top[0].Title != "Make this work" || top[1].Title != "Orphan" {
+		t.Fatalf("top level = %v", top)
+	}
+	if work := top[0]; work.Steps() != 3 || work.StepsDone() != 2 || !work.Subtasks[1].Subtasks[0].CanEdit {
+		t.Errorf("%d of %d steps done", work.StepsDone(), work.Steps())
+	}
+
+	if ids := subtree(tasks, 1); fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Errorf("subtree = %v", ids)
+	}
+}
+
+func TestDeleteSubtasks(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			parent := Task{Title: "Make this work", TaskListID: 1}
+			s.CreateTask(&parent)
+			step := Task{Title: "Write code", TaskListID: 1, ParentID: parent.ID}
+			s.CreateTask(&step)
+			s.CreateTask(&Task{Title: "Commit it", TaskListID: 1, ParentID: step.ID})
+			s.CreateTask(&Task{Title: "Something else", TaskListID: 1})
+
+			if err := s.DeleteTask(parent); err != nil {
+				t.Fatal(err)
+			}
+			if tasks, _ := s.Tasks(1); len(tasks) != 1 || tasks[0].Title != "Something else" {
+				t.Errorf("left after delete: %v", tasks)
+			}
+		})
+	}
+}
+
+func TestSubtasks(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		luis := loginAs(t, "Luis", "Bosquez")
+		send := func(path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(r, luis)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		for _, step := range []string{"Find remote", "Pick a show"} {
+			if w := send(idPath(t, "add", "Luis's List", "Watch TV"), url.Values{"title": {step}}); w.Code != http.StatusFound {
+				t.Fatalf("add step: %d %s", w.Code, w.Body)
+			}
+		}
+		if w := send(idPath(t, "add", "Luis's List", "Watch TV"), url.Values{"title": {"Do more laundry"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("step with a taken title: %d", w.Code)
+		}
+		if w := send(idPath(t, "edit", "Luis's List", "Watch TV"), url.Values{"title": {"Watch TV"}, "auto complete": {"on"}}); w.Code != http.StatusFound {
+			t.Fatalf("edit: %d %s", w.Code, w.Body)
+		}
+
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		watch := func() Task {
+			task, _ := store.FindTask(list.ID, "Watch TV")
+			return task
+		}
+		if step, _ := store.FindTask(list.ID, "Pick a show"); step.ParentID != watch().ID {
+			t.Fatalf("step parent = %d", step.ParentID)
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/view/", nil)
+		r.AddCookie(luis)
+		mux.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), "0 of 2 steps done") {
+			t.Errorf("view doesn't show progress: %s", w.Body)
+		}
+
+		// the parent completes with its last step and reopens with any step
+		send(idPath(t, "mark", "Luis's List", "Find remote"), nil)
+		if watch().Completed {
+			t.Error("completed with a step left")
+		}
+		send(idPath(t, "mark", "Luis's List", "Pick a show"), nil)
+		if !watch().Completed {
+			t.Error("not completed with its steps")
+		}
+		send(idPath(t, "mark", "Luis's List", "Pick a show"), nil)
+		if watch().Completed {
+			t.Error("not reopened with a step")
+		}
+
+		// the API can nest and move steps, but not into themselves
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{luis}}
+		parent := watch()
+		w = c.call("POST", fmt.Sprintf("/api/v1/tasks/%d/subtasks", parent.ID), `{"title": "Make popcorn"}`)
+		var popcorn apiTask
+		json.NewDecoder(w.Body).Decode(&popcorn)
+		if w.Code != http.StatusCreated || popcorn.ParentID != parent.ID {
+			t.Fatalf("add subtask: %d %+v", w.Code, popcorn)
+		}
+		w = c.call("GET", fmt.Sprintf("/api/v1/tasks/%d/subtasks", parent.ID), "")
+		var steps []apiTask
+		json.NewDecoder(w.Body).Decode(&steps)
+		if len(steps) != 3 {
+			t.Errorf("subtasks = %v", steps)
+		}
+		if w := c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", parent.ID), fmt.Sprintf(`{"parent_id": %d}`, popcorn.ID)); w.Code != http.StatusBadRequest {
+			t.Errorf("move into own subtask: %d %s", w.Code, w.Body)
+		}
+		other, _ := store.FindTask(list.ID, "Do more laundry")
+		if w := c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", popcorn.ID), fmt.Sprintf(`{"parent_id": %d}`, other.ID)); w.Code != http.StatusOK {
+			t.Errorf("move: %d %s", w.Code, w.Body)
+		}
+
+		// deleting the parent deletes its steps
+		if w := send(idPath(t, "delete", "Luis's List", "Watch TV"), nil); w.Code != http.StatusFound {
+			t.Fatalf("delete: %d", w.Code)
+		}
+		if _, err := store.FindTask(list.ID, "Pick a show"); err != ErrNotFound {
+			t.Error("step outlived its parent: ", err)
+		}
+		if _, err := store.GetTask(popcorn.ID); err != nil {
+			t.Error("moved step was deleted: ", err)
+		}
+	})
+}
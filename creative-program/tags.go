@@ -0,0 +1,219 @@
+package main
+
+/*
+	## Tags
+	Lists group tasks one way; tags group them across lists. Each user has
+	their own tags, made on the fly by typing them (comma separated) into
+	the add and edit forms, and each tag can be renamed or given a colour on
+	the /tags page. /view?tag=name shows every task carrying a tag, from all
+	of the user's lists.
+
+	Tasks and tags are joined many-to-many through the task_tags table.
+	Since a task's tags are stored apart from it, Task.Tags is only filled
+	in (by loadTags) where it is shown. Tags stay personal in shared lists
+	(see share.go): each member sees and changes only their own tags on a
+	task.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// taskTag puts a tag on a task.
+type taskTag struct {
+	TaskID uint `gorm:"primary_key;auto_increment:false"`
+	TagID  uint `gorm:"primary_key;auto_increment:false"`
+}
+
+// TableName names the table joining tasks and tags.
+func (taskTag) TableName() string {
+	return "task_tags"
+}
+
+// limits and defaults for tags
+const (
+	maxTagName   = 32
+	defaultColor = "#708090" // slategrey, like the rest of the page
+)
+
+// validColor matches the colours <input type=color> sends.
+var validColor = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+// Validate checks a tag's fields before it is saved.
+func (t Tag) Validate() error {
+	switch {
+	case t.Name == "":
+		return badInput("tag name is required")
+	case len(t.Name) > maxTagName:
+		return badInput(fmt.Sprintf("tag names are at most %d characters", maxTagName))
+	case strings.ContainsAny(t.Name, "/,"):
+		return badInput("tag names may not contain / or ,")
+	case !validColor.MatchString(t.Color):
+		return badInput("tag colour must look like #rrggbb")
+	}
+	return nil
+}
+
+// parseTagNames reads comma separated tag names, dropping blanks and
+// repeats.
+func parseTagNames(s string) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if err := (Tag{Name: name, Color: defaultColor}).Validate(); err != nil {
+			return nil, err
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// TagNames lists the task's tags the way parseTagNames reads them.
+func (t Task) TagNames() string {
+	names := []string{}
+	for _, tag := range t.Tags {
+		names = append(names, tag.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// tagTask replaces the user's tags on a task with their tags of the given
+// names, making any that don't exist yet. Other users' tags are kept.
+func tagTask(user User, task *Task, names []string) error {
+	current, err := store.TaskTags(task.ID)
+	if err != nil {
+		return err
+	}
+	var ids []uint
+	for _, tag := range current {
+		if tag.UserID != user.ID {
+			ids = append(ids, tag.ID)
+		}
+	}
+	for _, name := range names {
+		tag, err := store.FindTag(user.ID, name)
+		if err == ErrNotFound {
+			tag = Tag{UserID: user.ID, Name: name, Color: defaultColor}
+			if err = tag.Validate(); err == nil {
+				err = store.CreateTag(&tag)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		ids = append(ids, tag.ID)
+	}
+	if err := store.SetTaskTags(task.ID, ids); err != nil {
+		return err
+	}
+	task.Tags, err = taskTags(user, task.ID)
+	return err
+}
+
+// taskTags returns the user's tags on a task.
+func taskTags(user User, taskID uint) ([]Tag, error) {
+	all, err := store.TaskTags(taskID)
+	if err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	for _, tag := range all {
+		if tag.UserID == user.ID {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// loadTags fills in the user's tags on each task.
+func loadTags(user User, tasks []Task) error {
+	for i := range tasks {
+		tags, err := taskTags(user, tasks[i].ID)
+		if err != nil {
+			return err
+		}
+		tasks[i].Tags = tags
+	}
+	return nil
+}
+
+// updateTag renames and recolours one of the user's tags, so long as the
+// new name isn't another of their tags.
+func updateTag(user User, tag *Tag, name, color string) error {
+	oldName := tag.Name
+	tag.Name, tag.Color = name, color
+	if err := tag.Validate(); err != nil {
+		return err
+	}
+	if name != oldName {
+		_, err := store.FindTag(user.ID, name)
+		if err == nil {
+			return badInput("you already have a tag with that name")
+		}
+		if err != ErrNotFound {
+			return err
+		}
+	}
+	return store.SaveTag(tag)
+}
+
+// tagsPage is what tags.html shows.
+type tagsPage struct {
+	Error string
+	Tags  []Tag
+	CSRF  string // the session's token, for the forms (see csrf.go)
+}
+
+// renderTags shows the user's tags.
+func renderTags(w http.ResponseWriter, r *http.Request, status int, user User, msg string) {
+	tags, err := store.Tags(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "tags.html", tagsPage{msg, tags, pageToken(r)}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// tagsHandler Shows the user's tags (GET /tags/).
+func tagsHandler(w http.ResponseWriter, r *http.Request, user User) {
+	renderTags(w, r, http.StatusOK, user, "")
+}
+
+// editTagHandler Renames, recolours or deletes a tag (POST /tags/name).
+// Redirects user to the updated tags.
+func editTagHandler(w http.ResponseWriter, r *http.Request, user User) {
+	tag, err := userTagByName(user, pathParam(r, "name"))
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+
+	if r.FormValue("delete") != "" {
+		err = store.DeleteTag(tag)
+	} else {
+		err = updateTag(user, &tag, strings.TrimSpace(r.FormValue("name")), r.FormValue("color"))
+	}
+	if _, ok := err.(badInput); ok {
+		renderTags(w, r, http.StatusBadRequest, user, err.Error())
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	refresh(user.ID)
+
+	http.Redirect(w, r, "/tags/", http.StatusFound)
+}
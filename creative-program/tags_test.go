@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseTagNames(t *testing.T) {
+	names, err := parseTagNames(" home, errands,,home ")
+	if err != nil || strings.Join(names, ";") != "home;errands" {
+		t.Errorf("parseTagNames = %q, %v", names, err)
+	}
+	for _, bad := range []string{"a/b", strings.Repeat("x", maxTagName+1)} {
+		if _, err := parseTagNames(bad); err == nil {
+			t.Errorf("parseTagNames(%q) should fail", bad)
+		}
+	}
+}
+
+func TestStoreTags(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			a, b := Task{Title: "a", TaskListID: 1}, Task{Title: "b", TaskListID: 2}
+			s.CreateTask(&a)
+			s.CreateTask(&b)
+			home, work := Tag{UserID: 1, Name: "home", Color: defaultColor}, Tag{UserID: 1, Name: "work", Color: defaultColor}
+			s.CreateTag(&home)
+			s.CreateTag(&work)
+
+			if err := s.SetTaskTags(a.ID, []uint{work.ID, home.ID}); err != nil {
+				t.Fatal("SetTaskTags: ", err)
+			}
+			s.SetTaskTags(b.ID, []uint{home.ID})
+			if tags, _ := s.TaskTags(a.ID); len(tags) != 2 || tags[0].Name != "home" {
+				t.Errorf("TaskTags = %v", tags)
+			}
+			if tasks, _ := s.TaggedTasks(home.ID); len(tasks) != 2 {
+				t.Errorf("TaggedTasks = %v", tasks)
+			}
+			if tag, err := s.FindTag(1, "work"); err != nil || tag.ID != work.ID {
+				t.Errorf("FindTag = %v, %v", tag, err)
+			}
+
+			// deleting either side of a tagging removes it
+			if err := s.DeleteTag(work); err != nil {
+				t.Fatal("DeleteTag: ", err)
+			}
+			if tags, _ := s.TaskTags(a.ID); len(tags) != 1 {
+				t.Errorf("TaskTags after DeleteTag = %v", tags)
+			}
+			if err := s.DeleteTask(b); err != nil {
+				t.Fatal("DeleteTask: ", err)
+			}
+			if tasks, _ := s.TaggedTasks(home.ID); len(tasks) != 1 || tasks[0].ID != a.ID {
+				t.Errorf("TaggedTasks after DeleteTask = %v", tasks)
+			}
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		luis := loginAs(t, "Luis", "Bosquez")
+		send := func(method, path, query string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path, RawQuery: query}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(r, luis)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		if w := send("POST", idPath(t, "add", "Luis's Other List"), "", url.Values{"title": {"Fold laundry"}, "tags": {"home, chores"}}); w.Code != http.StatusFound {
+			t.Fatalf("add: %d %s", w.Code, w.Body)
+		}
+		if w := send("POST", idPath(t, "edit", "Luis's List", "Watch TV"), "", url.Values{"title": {"Watch TV"}, "tags": {"home"}}); w.Code != http.StatusFound {
+			t.Fatalf("edit: %d %s", w.Code, w.Body)
+		}
+		if w := send("POST", idPath(t, "add", "Luis's List"), "", url.Values{"title": {"Sweep"}, "tags": {"a/b"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("bad tag: %d", w.Code)
+		}
+
+		// the tag view gathers tasks from both lists
+		w := send("GET", "/view/", "tag=home", nil)
+		if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "Fold laundry") || !strings.Contains(body, "Watch TV") || strings.Contains(body, "Do more laundry") {
+			t.Errorf("view by tag: %d %s", w.Code, body)
+		}
+		if w := send("GET", "/view/", "tag=nope", nil); w.Code != http.StatusNotFound {
+			t.Errorf("view by missing tag: %d", w.Code)
+		}
+
+		if w := send("POST", "/tags/chores", "", url.Values{"name": {"home"}, "color": {"#ff0000"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("rename to a taken name: %d", w.Code)
+		}
+		if w := send("POST", "/tags/chores", "", url.Values{"name": {"housework"}, "color": {"#ff0000"}}); w.Code != http.StatusFound {
+			t.Errorf("rename: %d %s", w.Code, w.Body)
+		}
+		user, _ := store.FindUser("Luis", "Bosquez")
+		if tag, err := store.FindTag(user.ID, "housework"); err != nil || tag.Color != "#ff0000" {
+			t.Errorf("renamed tag = %+v, %v", tag, err)
+		}
+		if w := send("POST", "/tags/housework", "", url.Values{"delete": {"Delete"}}); w.Code != http.StatusFound {
+			t.Errorf("delete: %d", w.Code)
+		}
+		if tags, _ := store.Tags(user.ID); len(tags) != 1 {
+			t.Errorf("tags after delete = %v", tags)
+		}
+
+		// another user can't see Luis's tags
+		andrea := loginAs(t, "Andrea", "Lam")
+		home, _ := store.FindTag(user.ID, "home")
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{andrea}}
+		if w := c.call("GET", fmt.Sprintf("/api/v1/tags/%d", home.ID), ""); w.Code != http.StatusNotFound {
+			t.Errorf("another user's tag: %d", w.Code)
+		}
+
+		c = &apiClient{t: t, mux: mux, cookies: []*http.Cookie{luis}}
+		list, _ := store.FindList(user.ID, "Luis's List")
+		w = c.call("POST", fmt.Sprintf("/api/v1/lists/%d/tasks", list.ID), `{"title": "Sweep", "tags": ["home", "floors"]}`)
+		var task apiTask
+		json.NewDecoder(w.Body).Decode(&task)
+		if w.Code != http.StatusCreated || strings.Join(task.Tags, ";") != "floors;home" {
+			t.Errorf("create with tags: %d %+v", w.Code, task)
+		}
+		w = c.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", task.ID), `{"tags": []}`)
+		json.NewDecoder(w.Body).Decode(&task)
+		if w.Code != http.StatusOK || len(task.Tags) != 0 {
+			t.Errorf("clear tags: %d %+v", w.Code, task)
+		}
+
+		w = c.call("GET", fmt.Sprintf("/api/v1/tags/%d/tasks", home.ID), "")
+		var tasks []apiTask
+		json.NewDecoder(w.Body).Decode(&tasks)
+		if w.Code != http.StatusOK || len(tasks) != 2 {
+			t.Errorf("tagged tasks: %d %+v", w.Code, tasks)
+		}
+		if w := c.call("PATCH", fmt.Sprintf("/api/v1/tags/%d", home.ID), `{"color": "blue"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bad colour: %d", w.Code)
+		}
+		if w := c.call("PATCH", fmt.Sprintf("/api/v1/tags/%d", home.ID), `{"name": "house"}`); w.Code != http.StatusOK {
+			t.Errorf("rename: %d %s", w.Code, w.Body)
+		}
+		w = c.call("GET", "/api/v1/tags", "")
+		var tags []apiTag
+		json.NewDecoder(w.Body).Decode(&tags)
+		if w.Code != http.StatusOK || len(tags) != 2 || tags[0].Name != "floors" || tags[1].Name != "house" {
+			t.Errorf("tags: %d %+v", w.Code, tags)
+		}
+		if w := c.call("DELETE", fmt.Sprintf("/api/v1/tags/%d", home.ID), ""); w.Code != http.StatusNoContent {
+			t.Errorf("delete: %d", w.Code)
+		}
+	})
+}
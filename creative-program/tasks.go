@@ -4,13 +4,18 @@ package main
 	This file implements a RESTful web app for keeping track of To-Do items
 	using Go.
 
-	In order for this code to run it must connect to a server. It is currently
-	configured to connect to a demo server on my local machine. Golang.org has
+	By default this code connects to a demo server on my local machine. It
+	can instead use Postgres, keep its data in an embedded SQLite file, or
+	keep it in memory (see config.go and store.go), so no database service is
+	needed to try it out. Golang.org has
 	a sql package that abstracts details about drivers. However, because I was
 	using an ORM DB I sometimes used other methods.
 
-	NOTE: This web app is for demonstration only. It has no protection measures
-	beyond simple URL validation in place.
+	NOTE: Users log in with a password, kept as a bcrypt hash, and stay logged
+	in through a signed session cookie (see auth.go). Every list and task a
+	request touches is checked to be the user's or shared with them (see
+	authz.go), changes need POST with the session's CSRF token (see csrf.go),
+	and given a certificate the server speaks HTTPS (see tls.go).
 
 	I attempted to follow golang.org's recommendations for documentation and
 	style. Namely, the doc comment for each method starts with the method name
@@ -30,16 +35,21 @@ package main
 */
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
-	"regexp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
-
-	// register sql driver
-	_ "github.com/jinzhu/gorm/dialects/mssql"
 )
 
 /*
@@ -63,89 +73,244 @@ type (
 	// User is a named owner of lists
 	User struct {
 		gorm.Model
-		FirstName string `gorm:"primary_key"`
-		LastName  string `gorm:"primary_key"`
+		FirstName    string
+		LastName     string
+		PasswordHash string // bcrypt hash (see auth.go)
+		FeedToken    string // secret in the user's calendar URL (see ical.go)
+		Email        string // where reminders go, "" for nowhere (see remind.go)
+		RemindersOff bool   // true if the user doesn't want reminders
 	}
 
 	// Task is a to-do item
 	Task struct {
 		gorm.Model
-		Title      string `gorm:"primary_key"`
-		Details    string
-		DueDate    string
-		Completed  bool
-		TaskListID uint
+		Title        string
+		Details      string
+		DueDate      *time.Time `gorm:"column:due_at"` // nil if never due (see due.go)
+		HasDueTime   bool       // false if due any time on DueDate
+		Recurrence   string     // repeat rule, "" if it doesn't (see recur.go)
+		Completed    bool
+		TaskListID   uint
+		ParentID     uint       // 0 unless a subtask (see subtasks.go)
+		AutoComplete bool       // complete when all subtasks are
+		Priority     int        // noPriority to highPriority (see order.go)
+		Position     int        // order among tasks with the same parent
+		Reminder     string     // when to remind, "" if never (see remind.go)
+		RemindedFor  *time.Time // the reminder time last sent, nil if none
+		Tags         []Tag      `gorm:"-"` // filled in by loadTags, not saved
 	}
 
 	// TaskList is named set of tasks
 	TaskList struct {
 		gorm.Model
-		Title  string `gorm:"primary_key"`
+		Title     string
+		UserID    uint
+		Position  int    // order among the user's lists
+		FeedToken string // secret in the list's calendar URL (see ical.go)
+	}
+
+	// Tag is a user's label for tasks in any of their lists (see tags.go)
+	Tag struct {
+		gorm.Model
+		UserID uint
+		Name   string
+		Color  string // "#rrggbb"
+	}
+
+	// Member gives a user a role in someone else's list (see share.go)
+	Member struct {
+		gorm.Model
+		TaskListID uint
+		UserID     uint
+		Role       string // viewer, editor or owner
+		Accepted   bool   // false while the invitation is open
+	}
+
+	// Webhook is a URL a user wants told about changes (see webhook.go)
+	Webhook struct {
+		gorm.Model
 		UserID uint
+		URL    string
+		Secret string // key for signing deliveries
+		Events string // comma separated, "" for all of them
+		Active bool
 	}
+
+	// Delivery is an event sent, or being sent, to a webhook
+	Delivery struct {
+		gorm.Model
+		WebhookID   uint
+		Event       string
+		Payload     string     // the JSON body
+		Attempts    int        // tries so far
+		Status      int        // HTTP status of the last try, 0 if none came back
+		Error       string     // why the last try failed
+		Delivered   bool       // true once the webhook took it
+		NextAttempt *time.Time // nil once delivered or given up on
+	}
+)
+
+// limits on what the forms and API accept
+const (
+	maxTitle   = 128
+	maxDetails = 4096
 )
 
-// MustConnect connects to my local SampleDB
-func MustConnect() *gorm.DB {
-	// constants for accessing the database
-	var server = "localhost"
-	var port = 1433
-	var database = "SampleDB" // TODO: make a new TasksDB
+// checkTitle validates a list or task title.
+func checkTitle(title string) error {
+	switch {
+	case strings.TrimSpace(title) == "":
+		return badInput("title is required")
+	case len(title) > maxTitle:
+		return badInput(fmt.Sprintf("title is longer than %d characters", maxTitle))
+	case strings.Contains(title, "/"):
+		return badInput("title may not contain /")
+	}
+	return nil
+}
+
+// Validate checks a task's fields before it is saved.
+func (t Task) Validate() error {
+	if err := checkTitle(t.Title); err != nil {
+		return err
+	}
+	if len(t.Details) > maxDetails {
+		return badInput(fmt.Sprintf("details are longer than %d characters", maxDetails))
+	}
+	if t.Priority < noPriority || t.Priority > highPriority {
+		return badInput("priority must be none, low, medium or high")
+	}
+	_, err := parseRecurrence(t.Recurrence)
+	return err
+}
+
+// Validate checks a list's fields before it is saved.
+func (l TaskList) Validate() error {
+	return checkTitle(l.Title)
+}
+
+// checkTaskTitle makes sure no other task in a task's list has its title,
+// as the forms, API and imports all require.
+func checkTaskTitle(task Task) error {
+	other, err := store.FindTask(task.TaskListID, task.Title)
+	switch {
+	case err == ErrNotFound:
+		return nil
+	case err != nil:
+		return err
+	case other.ID != task.ID:
+		return badInput("this list already has a task with that title")
+	}
+	return nil
+}
 
-	connectionString := fmt.Sprintf("server=%s;port=%d;database=%s",
-		server, port, database)
-	db, err := gorm.Open("mssql", connectionString)
+// checkListTitle makes sure a list's owner has no other list with its
+// title.
+func checkListTitle(list TaskList) error {
+	other, err := store.FindList(list.UserID, list.Title)
+	switch {
+	case err == ErrNotFound:
+		return nil
+	case err != nil:
+		return err
+	case other.ID != list.ID:
+		return badInput("the list's owner already has a list with that title")
+	}
+	return nil
+}
+
+// Connect opens the SQL database described by cfg.
+func Connect(cfg Config) (*gorm.DB, error) {
+	db, err := gorm.Open(cfg.Dialect(), cfg.ConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	// database errors are logged; statements too when debugging
+	db.SetLogger(gormLogger{slog.Default()})
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		db.LogMode(true)
+	}
+	if cfg.Store == "mssql" {
+		gorm.DefaultCallback.Create().Remove("mssql:set_identity_insert")
+	}
+	return db, nil
+}
 
+// MustConnect connects to the configured database or exits.
+func MustConnect(cfg Config) *gorm.DB {
+	db, err := Connect(cfg)
 	if err != nil {
-		log.Fatal("Failed to create connection pool. Error: " + err.Error())
+		fatal("failed to create connection pool", err)
 	}
-	gorm.DefaultCallback.Create().Remove("mssql:set_identity_insert")
 	return db
 }
 
-// Example connects to my local DB and resets tables (populating them with test
-// data)
-func Example() *gorm.DB {
-	db := MustConnect()
-	defer db.Close()
+// every demo user's password
+const demoPassword = "password"
 
-	fmt.Println("Reseting DB...")
-	db.DropTableIfExists(&User{}, &TaskList{}, &Task{})
-
-	fmt.Println("Migrating models...")
-	db.AutoMigrate(&User{})
-	db.AutoMigrate(&TaskList{})
-	db.AutoMigrate(&Task{})
-
-	// Create test Users
-	fmt.Println("Creating users...")
-	db.Create(&User{FirstName: "Andrea", LastName: "Lam"})   //UserID: 1
-	db.Create(&User{FirstName: "Meet", LastName: "Bhagdev"}) //UserID: 2
-	db.Create(&User{FirstName: "Luis", LastName: "Bosquez"}) //UserID: 3
-
-	// Create list for each user
-	fmt.Println("Creating lists...")
-	db.Create(&TaskList{
-		Title: "Andrea's list", UserID: 1})
-	db.Create(&TaskList{
-		Title: "Meet's List", UserID: 2})
-	db.Create(&TaskList{
-		Title: "Luis's List", UserID: 3})
-	db.Create(&TaskList{
-		Title: "Luis's Other List", UserID: 3})
-
-	// Create  Tasks for each user
-	fmt.Println("Creating new tasks...")
-	db.Create(&Task{
-		Title: "Do laundry", DueDate: "2017-03-30", Completed: false, TaskListID: 1})
-	db.Create(&Task{
-		Title: "Mow the lawn", DueDate: "2017-03-30", Completed: false, TaskListID: 2})
-	db.Create(&Task{
-		Title: "Do more laundry", DueDate: "2017-03-30", Completed: false, TaskListID: 3})
-	db.Create(&Task{
-		Title: "Watch TV", DueDate: "2017-03-30", Completed: false, TaskListID: 3})
+// Example loads demo users, lists and tasks into the store.
+// Anything already present is left alone, so it is safe to run twice.
+func Example(s TaskStore) error {
+	type demoList struct {
+		title string
+		tasks []string
+	}
+	demo := []struct {
+		first, last string
+		lists       []demoList
+	}{
+		{"Andrea", "Lam", []demoList{{"Andrea's list", []string{"Do laundry"}}}},
+		{"Meet", "Bhagdev", []demoList{{"Meet's List", []string{"Mow the lawn"}}}},
+		{"Luis", "Bosquez", []demoList{
+			{"Luis's List", []string{"Do more laundry", "Watch TV"}},
+			{"Luis's Other List", nil},
+		}},
+	}
 
-	return db
+	repeats := map[string]string{
+		"Do laundry":   "FREQ=WEEKLY",
+		"Mow the lawn": "FREQ=DAILY;INTERVAL=10;X-FROM=DONE",
+	}
+
+	slog.Info("loading demo data")
+	for _, d := range demo {
+		user, err := s.FindUser(d.first, d.last)
+		if err == ErrNotFound {
+			user = User{FirstName: d.first, LastName: d.last}
+			if err = user.SetPassword(demoPassword); err == nil {
+				err = s.CreateUser(&user)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, dl := range d.lists {
+			list, err := s.FindList(user.ID, dl.title)
+			if err == ErrNotFound {
+				list = TaskList{Title: dl.title, UserID: user.ID}
+				err = s.CreateList(&list)
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, title := range dl.tasks {
+				_, err := s.FindTask(list.ID, title)
+				if err == ErrNotFound {
+					task := Task{Title: title, TaskListID: list.ID}
+					task.SetDue("2017-03-30")
+					task.SetRepeat(repeats[title])
+					err = s.CreateTask(&task)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
 }
 
 /*
@@ -153,210 +318,496 @@ func Example() *gorm.DB {
 	This next section of code is about presentation to the user (via
 		implementation of a web server).
 
-	The html package provides many methods for serving content, but none of
-	these methods are encrytped. For encryption one is able to change html
-	out for the net package which uses TLS 1.3. Following the extra steps for
-	TLS was beyond the scope of this assignment.
+	The server speaks plain HTTP unless it's given a certificate, or told to
+	make one, when it serves HTTPS (and HTTP/2) instead (see tls.go).
+
+	Every endpoint below /view, /add, /delete and /mark acts for the user
+	logged in to the session (see auth.go); visitors without one are sent to
+	/welcome.
 
 	| endpoint                              | purpose                        |
 	| ------------------------------------- | ------------------------------ |
 	| /welcome	                            | user's first point of contact  |
-	| /login                                | finds or lists user in DB      |
-	| /view/firstname lastname              | display's user's to-do lists   |
-	| /add/firstname lastname               | request to add a list          |
-	| /delete/firstname lastname            | request to delete a list       |
-	| /add/firstname lastname/list          | request to add task to list    |
-	| /delete/firstname lastname/list     | request to delete task from list |
-	| /mark/firstname lastname/list/task  | toggle's the .Completed field of a task |
-	NOTE: the server will be live at localhost:8080
-
-	## RegEx
-	Most open-source implementations of RegEx tend to be very slow (including
-	Python's). However, Go's is much faster because it creates a digraph and
-	iteratively searches for matches instead of recursing. [I think it's very
-	interesting.](https://swtch.com/~rsc/regexp/regexp1.html)
-
-	It's best to compile each Regular Expression only once, so it's an accepted
-	idiom to have them as global variables (this also allows sharing). Notice
-	that these are inherantly constants.
+	| /register                             | creates an account             |
+	| /login                                | starts a session               |
+	| /logout                               | ends a session                 |
+	| /view                                 | display's user's to-do lists   |
+	| /view?sort=due                        | ... soonest due first          |
+	| /view?sort=priority                   | ... highest priority first     |
+	| /view?tag=name                        | ... only tasks with a tag      |
+	| /tags                                 | the user's tags                |
+	| /tags/name                            | renames, recolours or deletes a tag |
+	| /search?q=words                       | the user's tasks matching a query |
+	| /invites/id                           | accepts or declines an invitation |
+	| /feeds                                | the user's calendar feed URLs; resets one |
+	| /calendar/token.ics                   | a calendar feed, no login needed (see ical.go) |
+	| /export?format=csv                    | downloads the user's lists (see export.go) |
+	| /import                               | loads lists from a file, or previews it |
+	| /account                              | the user's email for reminders (see remind.go) |
+	| /webhooks                             | the user's webhooks and deliveries; adds, changes or deletes one (see webhook.go) |
+	| /events                               | a stream of changes to the user's lists (see live.go) |
+	| /add                                  | request to add a list          |
+	| /lists/id/delete                      | request to delete a list       |
+	| /lists/id/add                         | request to add task to list    |
+	| /lists/id/edit                        | form to rename a list          |
+	| /lists/id/move?to=up                  | moves a list (up, down or to an index) |
+	| /lists/id/share                       | the list's members; invites, changes or removes one |
+	| /tasks/id/add                         | request to add a step to a task |
+	| /tasks/id/delete                      | request to delete task (and its steps) from list |
+	| /tasks/id/mark                        | toggle's the .Completed field of a task |
+	| /tasks/id/edit                        | form to edit a task            |
+	| /tasks/id/move?to=up                  | moves a task among its siblings |
+	| /verb/list, /verb/list/task           | old title-based paths; redirect to the above |
+	| /api/v1/...                           | JSON API (see api.go)          |
+	NOTE: the server will be live at localhost:8080 (see config.go)
+
+	Each endpoint answers only the methods it's routed for in routes();
+	those that change things take only POST, with the session's CSRF token
+	in the form (see csrf.go). The router only matches the shape of a path:
+	empty parts and ids that aren't whole numbers get 404 (see router.go).
+	Each handler then looks up the list or task named, answering 404 if the
+	user can't see it (see authz.go).
 */
 
-// Compile each Regular Expression only once for efficiency
-// All urls should match this path, but parts don't share consistent positions.
-// TODO: improve security by checking for a match with this path.
-var validPath = regexp.MustCompile("^/(welcome|login)|((view|add|delete)/(\\w+) (\\w+))|((add|delete)/(\\w+) (\\w+)/([^/]+))|((mark)/(\\w+) (\\w+)/([^/]+)/([^/]+))$")
-
-// useful for parsing name (trailing optional parts could disqualify a match)
-var userPath = regexp.MustCompile("^/(view|add|delete)/(\\w+) (\\w+)")
-
-// getName get's the first and last name from a request
-// Returns emtpy strings if invalid.
-func getName(url string) (first, last string) {
-	m := userPath.FindStringSubmatch(url)
-
-	if m != nil {
-		return m[2], m[3]
+// the following handlers rely on this store to operate (see main)
+var store TaskStore
+
+// storeError replies to the client with an error from the store.
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case ErrNotFound:
+		http.NotFound(w, r)
+	case errForbidden, errBadCSRF:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		if _, ok := err.(badInput); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		noteError(w, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
-	return "", ""
 }
 
-// useful for parsing name and list title
-var listPath = regexp.MustCompile("^/(view|add|delete)/(\\w+)\\s(\\w+)/([^/]+)")
+// delTaskHandler Deletes a task and its subtasks from a user's list (DB).
+// Redirects user to the updated view.
+func delTaskHandler(w http.ResponseWriter, r *http.Request, user User) {
+	id := pathID(r, "id")
+	requestLog(r).Debug("deleting task", "user", user.ID, "task", id)
 
-// getNameList parses first name, last name, and list title.
-// Returns emtpy strings if invalid.
-func getNameList(url string) (first, last, list string) {
-	m := listPath.FindStringSubmatch(url)
-	if m != nil {
-		return m[2], m[3], m[4]
+	task, err := userTask(user, id, writeAccess)
+	if err == nil {
+		err = deleteTask(task)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
-	return "", "", ""
+	emitTask("task.deleted", user, task)
+
+	retToView(w, r)
 }
 
-// useful for parsing entire path (name, list, task)
-var taskPath = regexp.MustCompile("^/(view|add|delete|mark)/(\\w+)\\s(\\w+)/([^/]+)/([^/]+)")
+// delListHandler Deletes a user's list and all tasks within (DB).
+// Redirects user to the updated view.
+func delListHandler(w http.ResponseWriter, r *http.Request, user User) {
+	id := pathID(r, "id")
+	requestLog(r).Debug("deleting list", "user", user.ID, "list", id)
 
-// getNameListTask parses path for info.
-// Returns emtpy strings if invalid.
-func getNameListTask(url string) (first, last, list, task string) {
-	m := taskPath.FindStringSubmatch(url)
-	if m != nil {
-		return m[2], m[3], m[4], m[5]
+	list, err := userList(user, id, ownerAccess)
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
-	return "", "", "", ""
+	// the list's members go with it, so find their webhooks first
+	event := newEvent("list.deleted", user, list, nil)
+	if err := store.DeleteList(list); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	event.send()
+
+	retToView(w, r)
 }
 
-// the following handlers rely on this connection to operate
-var db = MustConnect()
+// addTaskHandler Adds a task to a user's list (DB).
+// Redirects user to the updated view.
+func addTaskHandler(w http.ResponseWriter, r *http.Request, user User) {
+	list, err := userList(user, pathID(r, "id"), writeAccess)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
 
-// delHandler Delegates delete requests by path contents.
-func delHandler(w http.ResponseWriter, r *http.Request) {
-	if taskPath.Match([]byte(r.URL.Path)) {
-		delTaskHandler(w, r)
-	} else {
-		delListHandler(w, r)
+	// make task associated with list
+	task := Task{Title: strings.TrimSpace(r.FormValue("title")), Details: r.FormValue("details"), TaskListID: list.ID}
+	task.DueDate, task.HasDueTime, err = parseDueForm(r.FormValue("due date"), r.FormValue("due time"))
+	if err == nil {
+		task.Recurrence, err = repeatForm(r)
+	}
+	if err == nil {
+		task.Priority, err = parsePriority(r.FormValue("priority"))
+	}
+	if err == nil {
+		err = task.SetReminder(r.FormValue("reminder"))
+	}
+	var tags []string
+	if err == nil {
+		tags, err = parseTagNames(r.FormValue("tags"))
 	}
+	if err == nil {
+		err = task.Validate()
+	}
+	if err == nil {
+		err = checkTaskTitle(task)
+	}
+	if err == nil {
+		err = store.CreateTask(&task)
+	}
+	if err == nil {
+		err = tagTask(user, &task, tags)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emit("task.created", user, list, &task)
+
+	retToView(w, r)
 }
 
-// delTaskHandler Deletes a task from a user's list (DB).
+// addSubtaskHandler Adds a step to a task in a user's list (DB).
 // Redirects user to the updated view.
-func delTaskHandler(w http.ResponseWriter, r *http.Request) {
-	first, last, title, task := getNameListTask(r.URL.Path)
-	fmt.Printf("F: %s\nL: %s\nTitle: %s\nTask: %s\n", first, last, title, task)
+func addSubtaskHandler(w http.ResponseWriter, r *http.Request, user User) {
+	parent, err := userTask(user, pathID(r, "id"), writeAccess)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
 
-	var user User
-	db.First(&user, &User{FirstName: first, LastName: last})
+	task := Task{Title: strings.TrimSpace(r.FormValue("title")), TaskListID: parent.TaskListID, ParentID: parent.ID}
+	err = task.Validate()
+	if err == nil {
+		err = checkTaskTitle(task)
+	}
+	if err == nil {
+		err = store.CreateTask(&task)
+	}
+	if err == nil {
+		err = settleParent(task.TaskListID, task.ParentID, time.Now())
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emitTask("task.created", user, task)
 
-	var list TaskList
-	db.First(&list, &TaskList{Title: title, UserID: user.ID})
+	retToView(w, r)
+}
 
-	db.Delete(&Task{}, &Task{Title: task, TaskListID: list.ID})
+// addListHandler Creates a new list associated with the user (DB).
+// Redirects user to the updated view.
+func addListHandler(w http.ResponseWriter, r *http.Request, user User) {
+	// make list associated with user
+	list := TaskList{Title: strings.TrimSpace(r.FormValue("list title")), UserID: user.ID}
+	err := list.Validate()
+	if err == nil {
+		err = checkListTitle(list)
+	}
+	if err == nil {
+		err = store.CreateList(&list)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emit("list.created", user, list, nil)
 
-	retToView(first, last, w, r)
+	retToView(w, r)
 }
 
-// delListHandler Deletes a user's list and all tasks within (DB).
+// markHandler toggles the is/isn't complete status of a user's task.
+// Completing a repeating task adds its next occurrence (see recur.go), and
+// parents that complete with their subtasks follow (see subtasks.go).
 // Redirects user to the updated view.
-func delListHandler(w http.ResponseWriter, r *http.Request) {
-	first, last, title := getNameList(r.URL.Path)
-	fmt.Printf("F: %s\nL: %s\nTitle: %s\n", first, last, title)
+func markHandler(w http.ResponseWriter, r *http.Request, user User) {
+	requestLog(r).Debug("marking task", "user", user.ID, "task", pathID(r, "id"))
 
-	var user User
-	db.First(&user, &User{FirstName: first, LastName: last})
+	task, err := userTask(user, pathID(r, "id"), writeAccess)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
 
-	var list TaskList
-	db.First(&list, &TaskList{Title: title, UserID: user.ID})
-	db.Delete(&Task{}, &Task{TaskListID: list.ID})
-	db.Delete(&TaskList{}, &list)
+	next, err := setCompleted(&task, !task.Completed, time.Now())
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emitTask(completedEvent(task), user, task)
+	if next != nil {
+		emitTask("task.created", user, *next)
+	}
 
-	retToView(first, last, w, r)
+	retToView(w, r)
 }
 
-// addHandler Delegates add requests by path contents.
-func addHandler(w http.ResponseWriter, r *http.Request) {
-	if listPath.Match([]byte(r.URL.Path)) {
-		addTaskHandler(w, r)
-	} else {
-		addListHandler(w, r)
+// repeatForm reads the repeat rule from the add and edit task forms.
+func repeatForm(r *http.Request) (string, error) {
+	r.ParseForm()
+	return parseRepeatForm(r.FormValue("repeat"), r.FormValue("every"), r.Form["on"], r.FormValue("after done") != "")
+}
+
+// moveTaskHandler Moves a task up, down or to an index among its siblings
+// (see order.go).
+// Redirects user to the updated view.
+func moveTaskHandler(w http.ResponseWriter, r *http.Request, user User) {
+	task, err := userTask(user, pathID(r, "id"), writeAccess)
+	if err == nil {
+		err = moveTask(task, r.FormValue("to"))
+	}
+	if err == nil {
+		task, err = store.GetTask(task.ID)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
 	}
+	emitTask("task.updated", user, task)
+
+	retToView(w, r)
 }
 
-// addTaskHandler Adds a task to a user's list (DB).
+// moveListHandler Moves a list up, down or to an index (see order.go).
 // Redirects user to the updated view.
-func addTaskHandler(w http.ResponseWriter, r *http.Request) {
-	first, last, title := getNameList(r.URL.Path)
+func moveListHandler(w http.ResponseWriter, r *http.Request, user User) {
+	list, err := userList(user, pathID(r, "id"), ownerAccess)
+	if err == nil {
+		err = moveList(list, r.FormValue("to"))
+	}
+	if err == nil {
+		list, err = store.GetList(list.ID)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emit("list.updated", user, list, nil)
 
-	var user User
-	db.First(&user, &User{FirstName: first, LastName: last})
+	retToView(w, r)
+}
 
-	var list TaskList
-	db.First(&list, &TaskList{Title: title, UserID: user.ID})
+// editForm is what edit.html shows: a list, and possibly one of its tasks.
+type editForm struct {
+	Error string
+	List  TaskList
+	Task  *Task
+	CSRF  string // the session's token, for the form (see csrf.go)
+}
 
-	// make list associated with user
-	db.Create(&Task{Title: r.FormValue("title"), DueDate: r.FormValue("due date"), Details: r.FormValue("details"), TaskListID: list.ID})
+// renderEdit shows the edit form.
+func renderEdit(w http.ResponseWriter, r *http.Request, status int, form editForm) {
+	form.CSRF = pageToken(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "edit.html", form); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	retToView(first, last, w, r)
+// editError shows the edit form again for mistakes the user can fix.
+func editError(w http.ResponseWriter, r *http.Request, form editForm, err error) {
+	if _, ok := err.(badInput); ok {
+		form.Error = err.Error()
+		renderEdit(w, r, http.StatusBadRequest, form)
+		return
+	}
+	storeError(w, r, err)
 }
 
-// addListHandler Creates a new list associated with the user (DB).
+// editTaskHandler Shows a form pre-filled with a task (GET) or saves the
+// changes to its title, details, due date, repeat rule, priority, tags and
+// whether it completes with its subtasks (POST).
 // Redirects user to the updated view.
-func addListHandler(w http.ResponseWriter, r *http.Request) {
-	first, last := getName(r.URL.Path)
+func editTaskHandler(w http.ResponseWriter, r *http.Request, user User) {
+	task, err := userTask(user, pathID(r, "id"), writeAccess)
+	var list TaskList
+	if err == nil {
+		list, err = store.GetList(task.TaskListID)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	taskTitle := task.Title
+
+	if r.Method != http.MethodPost {
+		if task.Tags, err = taskTags(user, task.ID); err != nil {
+			storeError(w, r, err)
+			return
+		}
+		renderEdit(w, r, http.StatusOK, editForm{List: list, Task: &task})
+		return
+	}
 
-	var user User
-	db.Find(&user, &User{FirstName: first, LastName: last})
+	task.Title = strings.TrimSpace(r.FormValue("title"))
+	task.Details = r.FormValue("details")
+	task.AutoComplete = r.FormValue("auto complete") != ""
+	form := editForm{List: list, Task: &task}
 
-	// make list associated with user
-	db.Create(&TaskList{Title: r.FormValue("list title"), UserID: user.ID})
+	task.DueDate, task.HasDueTime, err = parseDueForm(r.FormValue("due date"), r.FormValue("due time"))
+	if err == nil {
+		task.Recurrence, err = repeatForm(r)
+	}
+	if err == nil {
+		task.Priority, err = parsePriority(r.FormValue("priority"))
+	}
+	if err == nil {
+		err = task.SetReminder(r.FormValue("reminder"))
+	}
+	var tags []string
+	if err == nil {
+		tags, err = parseTagNames(r.FormValue("tags"))
+	}
+	if err == nil {
+		err = task.Validate()
+	}
+	if err != nil {
+		editError(w, r, form, err)
+		return
+	}
+	if task.Title != taskTitle {
+		if err := checkTaskTitle(task); err != nil {
+			editError(w, r, form, err)
+			return
+		}
+	}
+	err = store.SaveTask(&task)
+	if err == nil {
+		err = tagTask(user, &task, tags)
+	}
+	if err == nil {
+		err = settleParent(task.TaskListID, task.ID, time.Now())
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emit("task.updated", user, list, &task)
 
-	retToView(first, last, w, r)
+	retToView(w, r)
 }
 
-// markHandler toggles the is/isn't complete status of a user's task.
+// editListHandler Shows a form to rename a list (GET) or renames it (POST).
 // Redirects user to the updated view.
-func markHandler(w http.ResponseWriter, r *http.Request) {
-	first, last, title, taskTitle := getNameListTask(r.URL.Path)
-	fmt.Printf("Path: %s\nF: %s\nL: %s\nTitle: %s\nTask: %s\n", r.URL.Path, first, last, title, taskTitle)
+func editListHandler(w http.ResponseWriter, r *http.Request, user User) {
+	list, err := userList(user, pathID(r, "id"), ownerAccess)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	title := list.Title
 
-	var user User
-	db.First(&user, &User{FirstName: first, LastName: last})
+	if r.Method != http.MethodPost {
+		renderEdit(w, r, http.StatusOK, editForm{List: list})
+		return
+	}
 
-	var list TaskList
-	db.First(&list, &TaskList{Title: title, UserID: user.ID})
+	list.Title = strings.TrimSpace(r.FormValue("list title"))
+	form := editForm{List: list}
 
-	var task Task
-	db.First(&task, &Task{Title: taskTitle, TaskListID: list.ID})
+	if err := list.Validate(); err != nil {
+		editError(w, r, form, err)
+		return
+	}
+	if list.Title != title {
+		if err := checkListTitle(list); err != nil {
+			editError(w, r, form, err)
+			return
+		}
+	}
+	if err := store.SaveList(&list); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	emit("list.updated", user, list, nil)
 
-	task.Completed = !task.Completed // toggle boolean
+	retToView(w, r)
+}
 
-	db.Save(&task)
+// welcomeHandler Serves the login and registration page to the client.
+func welcomeHandler(w http.ResponseWriter, r *http.Request) {
+	renderWelcome(w, http.StatusOK, "")
+}
 
-	retToView(first, last, w, r)
+// renderWelcome shows the welcome page with an optional error message.
+func renderWelcome(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "welcome.html", struct{ Error string }{msg}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
-// welcomeHandler Serves a static login webpage to the client.
-func welcomeHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "welcome.html")
+// formError shows the welcome page again for mistakes the user can fix.
+func formError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.(type) {
+	case badInput:
+		status := http.StatusBadRequest
+		if err == errBadLogin {
+			status = http.StatusUnauthorized
+		}
+		renderWelcome(w, status, err.Error())
+	default:
+		storeError(w, r, err)
+	}
 }
 
-// loginHandler Find's the user's table or makes a new one.
-// Redirects user to the updated view.
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+// registerHandler Creates an account and logs the new user in.
+// Redirects user to their (empty) view.
+func registerHandler(w http.ResponseWriter, r *http.Request) {
 	first := r.FormValue("first name")
 	last := r.FormValue("last name")
+	user, err := register(first, last, r.FormValue("password"))
+	if err != nil {
+		formError(w, r, err)
+		return
+	}
+	requestLog(r).Info("registered", "user", user.ID)
+
+	startSession(w, user)
+	retToView(w, r)
+}
 
-	var count int // add if absent (this is just for school)
-	if db.Find(&User{FirstName: first, LastName: last}).Count(&count); count == 0 {
-		db.Create(&User{FirstName: first, LastName: last})
-		println("New User:", first, last) // DEBUG
+// loginHandler Checks the user's password and starts a session.
+// Redirects user to the updated view.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := authenticate(r.FormValue("first name"), r.FormValue("last name"), r.FormValue("password"))
+	if err != nil {
+		formError(w, r, err)
+		return
 	}
 
-	retToView(first, last, w, r)
+	startSession(w, user)
+	retToView(w, r)
+}
+
+// logoutHandler Ends the session.
+// Redirects user to the welcome page.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := sessionUser(r); err == nil {
+		if err = checkCSRF(r); err != nil {
+			storeError(w, r, err)
+			return
+		}
+	}
+	endSession(w)
+	http.Redirect(w, r, "/welcome/", http.StatusFound)
 }
 
 // cssHandler serves the css for this app to the client.
 func cssHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "tasks.css")
+	http.ServeFile(w, r, filepath.Join(config.Static, "tasks.css"))
 }
 
 /*
@@ -369,66 +820,311 @@ func cssHandler(w http.ResponseWriter, r *http.Request) {
 	Like the Regular Expressions it's best to only parse the template once.
 */
 
-// provides view of a user's task lists
-var templates = template.Must(template.ParseFiles("tasks.html"))
+// provides the welcome page and view of a user's task lists (see loadTemplates)
+var templates *template.Template
+
+// loadTemplates parses the templates found in dir.
+func loadTemplates(dir string) (*template.Template, error) {
+	var files []string
+	for _, name := range []string{"tasks.html", "welcome.html", "edit.html", "tags.html", "search.html", "share.html", "feeds.html", "import.html", "account.html", "webhooks.html"} {
+		files = append(files, filepath.Join(dir, name))
+	}
+	return template.ParseFiles(files...)
+}
 
 // viewHandler executes templates with the user's data.
-func viewHandler(w http.ResponseWriter, r *http.Request) {
+func viewHandler(w http.ResponseWriter, r *http.Request, user User) {
 	type List struct {
-		Title string
-		Tasks []Task
+		ID       uint
+		Title    string
+		Tasks    []*taskNode // top-level tasks, with their subtasks
+		SharedBy string      // the list's owner, if it isn't the user
+		Role     string      // the user's role in a shared list
+		CanEdit  bool
+		IsOwner  bool
 	}
 
 	// a temp struct for organizing a user's collective information
 	type UserFile struct {
-		Owner string
-		Lists []List
+		Owner   string
+		Sort    string
+		Tag     *Tag // only tasks with this tag, if set
+		Invites []invitation
+		Lists   []List
+		CSRF    string // the session's token, for the forms (see csrf.go)
 	}
 
-	first, last := getName(r.URL.Path)
+	sortBy := r.FormValue("sort")
+	var uFile = UserFile{user.FirstName + " " + user.LastName, sortBy, nil, nil, nil, pageToken(r)}
 
-	var uFile = UserFile{first + " " + last, nil}
-
-	var user User
-	db.Find(&user, &User{FirstName: first, LastName: last})
+	lists, err := userLists(user) // all lists for user, and shared with them
+	if err == nil {
+		uFile.Invites, err = invitations(user)
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
 
-	var lists []TaskList
-	db.Model(&user).Related(&lists) // all lists for user
+	// ?tag=name picks out the tasks with that tag from every list
+	var tagged map[uint]bool
+	if name := r.FormValue("tag"); name != "" {
+		tag, err := userTagByName(user, name)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		tasks, err := store.TaggedTasks(tag.ID)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		uFile.Tag, tagged = &tag, map[uint]bool{}
+		for _, t := range tasks {
+			tagged[t.ID] = true
+		}
+	}
 
 	for _, tl := range lists {
-		var tasks []Task
-		db.Model(&tl).Related(&tasks) // all tasks for list
-
-		uFile.Lists = append(uFile.Lists, List{tl.Title, tasks})
+		tasks, err := store.Tasks(tl.ID) // all tasks for list
+		if err == nil && tagged != nil {
+			var some []Task
+			for _, t := range tasks {
+				if tagged[t.ID] {
+					some = append(some, t)
+				}
+			}
+			if tasks = some; len(tasks) == 0 {
+				continue
+			}
+		}
+		if err == nil {
+			err = loadTags(user, tasks)
+		}
+		list := List{ID: tl.ID, Title: tl.Title}
+		var have access
+		if err == nil {
+			have, err = listAccess(user, tl)
+		}
+		if err == nil && tl.UserID != user.ID {
+			var owner User
+			owner, err = store.GetUser(tl.UserID)
+			list.SharedBy = userName(owner)
+		}
+		if err == nil && tl.UserID != user.ID {
+			var member Member
+			member, err = store.FindMember(tl.ID, user.ID)
+			if err == ErrNotFound {
+				err = nil // no role to show
+			}
+			list.Role = member.Role
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		sortTasks(tasks, sortBy)
+
+		list.CanEdit, list.IsOwner = have >= writeAccess, have == ownerAccess
+		list.Tasks = taskTree(list.CanEdit, uFile.CSRF, tasks)
+		uFile.Lists = append(uFile.Lists, list)
 	}
 
-	err := templates.ExecuteTemplate(w, "tasks.html", uFile)
+	err = templates.ExecuteTemplate(w, "tasks.html", uFile)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// titleRedirect Finds the list, or task, named by an old title-based path
+// like /mark/list/task.
+// Redirects user to its ID-based path, keeping the method and query.
+func titleRedirect(verb string) userHandler {
+	return func(w http.ResponseWriter, r *http.Request, user User) {
+		list, err := userListByTitle(user, pathParam(r, "list"), readAccess)
+		path := fmt.Sprintf("/lists/%d/%s", list.ID, verb)
+		if title := pathParam(r, "task"); err == nil && title != "" {
+			var task Task
+			task, err = store.FindTask(list.ID, title)
+			path = fmt.Sprintf("/tasks/%d/%s", task.ID, verb)
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		u := url.URL{Path: path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	}
+}
+
 // retToView redirects user to the updated view.
-// Alters path to ensure template has valid links.
-func retToView(first string, last string, w http.ResponseWriter, r *http.Request) {
-	r.URL.Path = "/view/" + first + " " + last + "/"
-	http.Redirect(w, r, r.URL.Path, http.StatusFound)
-	viewHandler(w, r)
+func retToView(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/view/", http.StatusFound)
 }
 
-// Lauches server with all handlers.
-// Uses port 8080
+// the server's settings (see LoadConfig)
+var config = DefaultConfig()
+
+// Runs one of the commands below (serve by default).
+//
+//	tasks [flags] serve              launches the server with all handlers
+//	tasks [flags] migrate [version]  moves the schema to a version (latest by default)
+//	tasks [flags] seed               loads demo data
+//	tasks [flags] sync file first last  syncs a todo.txt file with a user's tasks
 func main() {
-	db := Example()
+	var err error
+	var args []string
+	if config, args, err = LoadConfig(os.Args[1:]); err != nil {
+		log.Fatal("Bad configuration. Error: " + err.Error())
+	}
+	if err := setupLogging(config); err != nil {
+		log.Fatal("Bad configuration. Error: " + err.Error())
+	}
+
+	cmd := "serve"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve()
+	case "migrate":
+		migrate(args)
+	case "seed":
+		seed()
+	case "sync":
+		syncTodoCommand(args)
+	default:
+		log.Fatalf("Unknown command %q (want serve, migrate, seed or sync)", cmd)
+	}
+}
+
+// serve launches the server with all handlers.
+// Listens on the configured address (port 8080 by default), over HTTPS if
+// it's configured
+func serve() {
+	var err error
+	if templates, err = loadTemplates(config.Templates); err != nil {
+		fatal("failed to load templates", err)
+	}
+
+	if sessionKey = []byte(config.SessionKey); len(sessionKey) == 0 {
+		slog.Warn("no session key configured; sessions will end when the server stops")
+		sessionKey = newSessionKey()
+	}
+
+	if store, err = NewStore(config); err != nil {
+		fatal("failed to open store", err)
+	}
+	defer store.Close()
+
+	if config.Seed {
+		if err := Example(store); err != nil {
+			fatal("failed to load demo data", err)
+		}
+	}
+
+	if config.SMTPAddr != "" {
+		go runReminders(newSMTPNotifier(config))
+	} else {
+		slog.Warn("no mail server configured; reminders won't be sent")
+	}
+	go runWebhooks()
+
+	srv, err := newServer(config, routes())
+	if err != nil {
+		fatal("failed to load TLS certificate", err)
+	}
+	slog.Info("listening", "addr", config.Addr, "tls", srv.TLSConfig != nil)
+	fatal("server stopped", listen(config, srv))
+}
+
+// migrate moves a SQL store's schema to the version in args (or the latest).
+func migrate(args []string) {
+	if config.Store == "memory" {
+		fatal("can't migrate", errors.New("the memory store has no schema"))
+	}
+
+	target := latestVersion()
+	if len(args) > 0 {
+		var err error
+		if target, err = strconv.Atoi(args[0]); err != nil {
+			fatal("bad version", err)
+		}
+	}
+
+	db := MustConnect(config)
 	defer db.Close()
 
-	http.HandleFunc("/view/", viewHandler)
-	http.HandleFunc("/welcome/", welcomeHandler)
-	http.HandleFunc("/login/", loginHandler)
-	http.HandleFunc("/tasks.css", cssHandler)
-	http.HandleFunc("/add/", addHandler)
-	http.HandleFunc("/delete/", delHandler)
-	http.HandleFunc("/mark/", markHandler)
+	if err := Migrate(db, target); err != nil {
+		fatal("failed to migrate", err)
+	}
+	fmt.Println("Schema is at version", target)
+}
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// seed loads demo data into the configured store.
+func seed() {
+	s, err := NewStore(config)
+	if err != nil {
+		fatal("failed to open store", err)
+	}
+	defer s.Close()
+
+	if err := Example(s); err != nil {
+		fatal("failed to load demo data", err)
+	}
+}
+
+// routes sends each endpoint to its handler. Only the edit and share forms
+// answer GET; everything that changes things takes a POST with the
+// session's CSRF token (see csrf.go). The title-based paths from before
+// lists and tasks had them in their URLs still lead to them.
+func routes() *router {
+	rt := newRouter()
+	rt.use(logRequests)
+	rt.handleFunc("GET", "/view", loggedIn(viewHandler))
+	rt.handleFunc("GET", "/welcome", welcomeHandler)
+	rt.handleFunc("POST", "/register", registerHandler)
+	rt.handleFunc("POST", "/login", loginHandler)
+	rt.handle("GET", "/register", http.RedirectHandler("/welcome/", http.StatusFound))
+	rt.handle("GET", "/login", http.RedirectHandler("/welcome/", http.StatusFound))
+	rt.handleFunc("POST", "/logout", logoutHandler)
+	rt.handleFunc("GET", "/tasks.css", cssHandler)
+	rt.handleFunc("POST", "/add", loggedIn(addListHandler))
+	rt.handleFunc("POST", "/lists/{id:uint}/add", loggedIn(addTaskHandler))
+	rt.handleFunc("POST", "/lists/{id:uint}/delete", loggedIn(delListHandler))
+	rt.handleFunc("GET, POST", "/lists/{id:uint}/edit", loggedIn(editListHandler))
+	rt.handleFunc("POST", "/lists/{id:uint}/move", loggedIn(moveListHandler))
+	rt.handleFunc("GET, POST", "/lists/{id:uint}/share", loggedIn(shareHandler))
+	rt.handleFunc("POST", "/tasks/{id:uint}/add", loggedIn(addSubtaskHandler))
+	rt.handleFunc("POST", "/tasks/{id:uint}/delete", loggedIn(delTaskHandler))
+	rt.handleFunc("POST", "/tasks/{id:uint}/mark", loggedIn(markHandler))
+	rt.handleFunc("GET, POST", "/tasks/{id:uint}/edit", loggedIn(editTaskHandler))
+	rt.handleFunc("POST", "/tasks/{id:uint}/move", loggedIn(moveTaskHandler))
+	for _, verb := range []string{"add", "delete", "edit", "move", "share", "mark"} {
+		methods := "POST"
+		if verb == "edit" || verb == "share" {
+			methods = "GET, POST"
+		}
+		if verb != "mark" {
+			rt.handleFunc(methods, "/"+verb+"/{list}", loggedIn(titleRedirect(verb)))
+		}
+		if verb != "share" {
+			rt.handleFunc(methods, "/"+verb+"/{list}/{task}", loggedIn(titleRedirect(verb)))
+		}
+	}
+	rt.handleFunc("GET", "/tags", loggedIn(tagsHandler))
+	rt.handleFunc("POST", "/tags/{name}", loggedIn(editTagHandler))
+	rt.handleFunc("GET", "/search", loggedIn(searchHandler))
+	rt.handleFunc("POST", "/invites/{id:uint}", loggedIn(inviteHandler))
+	rt.handleFunc("GET, POST", "/feeds", loggedIn(feedsHandler))
+	rt.handleFunc("GET", "/calendar/{token}.ics", calendarHandler)
+	rt.handleFunc("GET", "/export", loggedIn(exportHandler))
+	rt.handleFunc("GET, POST", "/import", loggedIn(importHandler))
+	rt.handleFunc("GET, POST", "/account", loggedIn(accountHandler))
+	rt.handleFunc("GET, POST", "/webhooks", loggedIn(webhooksHandler))
+	rt.handleFunc("GET", "/events", loggedIn(eventsHandler))
+	rt.handleFunc("*", "/api/v1/{path...}", apiHandler) // routes its own (see api.go)
+	return rt
 }
@@ -3,7 +3,12 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,44 +19,68 @@ import (
 
 func TestTemplate(t *testing.T) {
 	var templ = template.Must(template.ParseFiles("tasks.html"))
-	f, _ := os.OpenFile("out.html", os.O_RDWR, os.ModePerm)
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer f.Close()
 
 	type List struct {
-		Title string
-		Tasks []Task
+		ID       uint
+		Title    string
+		Tasks    []*taskNode
+		SharedBy string
+		Role     string
+		CanEdit  bool
+		IsOwner  bool
 	}
 
 	type UserFile struct {
-		Owner string
-		Lists []List
+		Owner   string
+		Sort    string
+		Tag     *Tag
+		Invites []invitation
+		Lists   []List
+		CSRF    string
 	}
 
 	now := time.Now()
-	err := templ.Execute(f,
+	due := now.AddDate(0, 0, -3)
+	err = templ.Execute(f,
 		UserFile{
 			Owner: "Ivan Webber",
 			Lists: []List{
 				List{
+					ID:    1,
 					Title: "Make this work",
-					Tasks: []Task{
+					Tasks: taskTree(true, "token", []Task{
+						Task{
+							Model:     gorm.Model{ID: 1, CreatedAt: now, UpdatedAt: now, DeletedAt: &now},
+							Title:     "The Title",
+							Details:   "The details...",
+							DueDate:   &due,
+							Completed: true,
+						},
 						Task{
-							gorm.Model{0, now, now, &now},
-							"The Title",
-							"The details...",
-							"A DueDate",
-							true,
-							0,
+							Model:      gorm.Model{ID: 2, CreatedAt: now, UpdatedAt: now, DeletedAt: &now},
+							Title:      "Not The Title",
+							Details:    "Not The details...",
+							DueDate:    &now,
+							HasDueTime: true,
+							Recurrence: "FREQ=DAILY",
 						},
 						Task{
-							gorm.Model{0, now, now, &now},
-							"Not The Title",
-							"Not The details...",
-							"Not Today",
-							false,
-							0,
+							Model:   gorm.Model{ID: 3, CreatedAt: now, UpdatedAt: now, DeletedAt: &now},
+							Title:   "Someday",
+							Details: "No rush",
 						},
-					},
+						Task{
+							Model:        gorm.Model{ID: 4, CreatedAt: now, UpdatedAt: now, DeletedAt: &now},
+							Title:        "A step",
+							ParentID:     3,
+							AutoComplete: true,
+						},
+					}),
 				},
 			},
 		})
@@ -61,6 +90,83 @@ func TestTemplate(t *testing.T) {
 	}
 }
 
+func TestEdit(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		luis := loginAs(t, "Luis", "Bosquez")
+
+		send := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(r, luis)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+		taskForm := func(title, due, details string) url.Values {
+			return url.Values{"title": {title}, "due date": {due}, "details": {details}}
+		}
+
+		w := send("GET", idPath(t, "edit", "Luis's List", "Watch TV"), nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="Watch TV"`) {
+			t.Fatalf("edit form: %d %s", w.Code, w.Body)
+		}
+
+		for _, bad := range []url.Values{
+			taskForm("", "2020-04-01", ""),
+			taskForm("Watch TV", "Not Today", ""),
+			taskForm("Do more laundry", "", ""), // already in the list
+			taskForm(strings.Repeat("x", maxTitle+1), "", ""),
+		} {
+			if w := send("POST", idPath(t, "edit", "Luis's List", "Watch TV"), bad); w.Code != http.StatusBadRequest {
+				t.Errorf("edit with %v: %d", bad, w.Code)
+			}
+		}
+
+		w = send("POST", idPath(t, "edit", "Luis's List", "Watch TV"), taskForm("Watch less TV", "2020-04-01", "Just one show"))
+		if w.Code != http.StatusFound {
+			t.Fatalf("edit: %d %s", w.Code, w.Body)
+		}
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		task, err := store.FindTask(list.ID, "Watch less TV")
+		if err != nil || task.DueString() != "2020-04-01" || task.Details != "Just one show" {
+			t.Errorf("edited task = %+v, %v", task, err)
+		}
+
+		if w := send("POST", idPath(t, "edit", "Luis's List"), url.Values{"list title": {"Luis's Other List"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("rename to a taken title: %d", w.Code)
+		}
+		if w := send("POST", idPath(t, "edit", "Luis's List"), url.Values{"list title": {"Chores"}}); w.Code != http.StatusFound {
+			t.Errorf("rename: %d %s", w.Code, w.Body)
+		}
+		if _, err := store.FindList(user.ID, "Chores"); err != nil {
+			t.Error("list not renamed: ", err)
+		}
+
+		// adding checks titles as editing does
+		for _, bad := range []url.Values{
+			taskForm("", "", ""),
+			taskForm(" Watch less TV ", "", ""),
+			taskForm(strings.Repeat("x", maxTitle+1), "", ""),
+		} {
+			if w := send("POST", idPath(t, "add", "Chores"), bad); w.Code != http.StatusBadRequest {
+				t.Errorf("add task with %v: %d", bad, w.Code)
+			}
+		}
+		for _, title := range []string{" ", "Luis's Other List", strings.Repeat("x", maxTitle+1)} {
+			if w := send("POST", "/add", url.Values{"list title": {title}}); w.Code != http.StatusBadRequest {
+				t.Errorf("add list %q: %d", title, w.Code)
+			}
+		}
+
+		// only the owner may edit
+		if w := send("GET", idPath(t, "edit", "Andrea's list", "Do laundry"), nil); w.Code != http.StatusNotFound {
+			t.Errorf("edit of another user's task: %d", w.Code)
+		}
+	})
+}
+
 // ReadAllTasks prints all the tasks
 func ReadAllTasks(db *gorm.DB) {
 	var users []User
@@ -78,7 +184,7 @@ func ReadAllTasks(db *gorm.DB) {
 			fmt.Printf("\t%s:\n", tl.Title)
 			for _, task := range tasks {
 				fmt.Printf("\t\tTitle: %s\n\t\tDueDate: %s\n\t\tDetails: %s\n\t\tCompleted:%t\n\n",
-					task.Title, task.DueDate, task.Details, task.Completed)
+					task.Title, task.Due(), task.Details, task.Completed)
 			}
 		}
 	}
@@ -89,7 +195,7 @@ func UpdateATask(db *gorm.DB, TaskListID int) {
 	var task Task
 	db.Where("task_list_id = ?", TaskListID).First(&task).Update("Title", "Buy donuts for Luis")
 	fmt.Printf("Title: %s\nDueDate: %s\nCompleted:%t\n\n",
-		task.Title, task.DueDate, task.Completed)
+		task.Title, task.Due(), task.Completed)
 }
 
 // DeleteSomeonesTasks deletes all the tasks for a user
@@ -98,8 +204,8 @@ func DeleteListContents(db *gorm.DB, TaskListID int) {
 	fmt.Printf("Deleted all tasks in list %d", TaskListID)
 }
 
-func ExampleConnectEtcDB() {
-	db := MustConnect()
+func ExampleMustConnect() {
+	db := MustConnect(DefaultConfig())
 	defer db.Close()
 
 	fmt.Println("Reseting DB...")
@@ -129,14 +235,15 @@ func ExampleConnectEtcDB() {
 
 	// Create appropriate Tasks for each user
 	fmt.Println("Creating new appropriate tasks...")
+	due := time.Date(2017, 3, 30, 0, 0, 0, 0, time.Local)
 	db.Create(&Task{
-		Title: "Do laundry", DueDate: "2017-03-30", Completed: false, TaskListID: 1})
+		Title: "Do laundry", DueDate: &due, Completed: false, TaskListID: 1})
 	db.Create(&Task{
-		Title: "Mow the lawn", DueDate: "2017-03-30", Completed: false, TaskListID: 2})
+		Title: "Mow the lawn", DueDate: &due, Completed: false, TaskListID: 2})
 	db.Create(&Task{
-		Title: "Do more laundry", DueDate: "2017-03-30", Completed: false, TaskListID: 3})
+		Title: "Do more laundry", DueDate: &due, Completed: false, TaskListID: 3})
 	db.Create(&Task{
-		Title: "Watch TV", DueDate: "2017-03-30", Completed: false, TaskListID: 3})
+		Title: "Watch TV", DueDate: &due, Completed: false, TaskListID: 3})
 
 	// Read
 	fmt.Println("\nReading all the tasks...")
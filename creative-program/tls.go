@@ -0,0 +1,211 @@
+package main
+
+/*
+	## HTTPS
+	Passwords and session cookies shouldn't cross a network in plaintext, so
+	the server can speak HTTPS itself:
+
+	| setting                 | serves                                        |
+	| ----------------------- | --------------------------------------------- |
+	| (none)                  | plain HTTP on -addr, for trying it out        |
+	| -tls-cert and -tls-key  | HTTPS on -addr with a real certificate        |
+	| -tls-self-signed        | HTTPS on -addr with a certificate made here   |
+
+	A self-signed certificate is for development: it covers localhost and
+	the machine's host name, and browsers warn about it until it's trusted.
+	Given -tls-cert and -tls-key as well, it's kept in those files and made
+	again only once they're gone, so it only needs trusting once.
+
+	Over HTTPS the session cookie is marked Secure, so browsers never send
+	it over plain HTTP, and every answer asks browsers to use only HTTPS for
+	the next -hsts-max-age seconds (Strict-Transport-Security). With
+	-http-addr set, plain HTTP requests there are redirected to HTTPS and
+	nothing else is served, so a login form is never shown unencrypted.
+	Browsers that support it get HTTP/2.
+
+	The server has no write timeout, as /events streams for as long as a
+	page is open (see live.go).
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// how long a self-signed certificate lasts
+	selfSignedFor = 365 * 24 * time.Hour
+	// how long clients get to send a request's headers
+	readHeaderTimeout = 10 * time.Second
+	// how long an idle keep-alive connection is kept open
+	idleTimeout = 2 * time.Minute
+)
+
+// secureCookies marks session cookies Secure; it's set when serving HTTPS.
+var secureCookies bool
+
+// newServer makes the server for h on the configured address, set up for
+// HTTPS if a certificate is configured.
+func newServer(cfg Config, h *router) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	if !cfg.TLS() {
+		return srv, nil
+	}
+
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	secureCookies = true
+	if cfg.HSTSMaxAge > 0 {
+		h.use(hsts(cfg.HSTSMaxAge))
+	}
+	return srv, nil
+}
+
+// listen serves srv until it fails, along with the redirects to it on
+// -http-addr when it's HTTPS.
+func listen(cfg Config, srv *http.Server) error {
+	if srv.TLSConfig == nil {
+		slog.Warn("no TLS certificate configured; passwords and sessions will cross the network unencrypted")
+		return srv.ListenAndServe()
+	}
+	if cfg.HTTPAddr != "" {
+		redirects := &http.Server{
+			Addr:              cfg.HTTPAddr,
+			Handler:           redirectHTTPS(cfg.Addr),
+			ReadHeaderTimeout: readHeaderTimeout,
+			IdleTimeout:       idleTimeout,
+		}
+		go func() {
+			fatal("redirect server stopped", redirects.ListenAndServe())
+		}()
+	}
+	return srv.ListenAndServeTLS("", "") // the certificate is in TLSConfig
+}
+
+// loadCertificate reads the configured certificate and key, or makes a
+// self-signed pair (and keeps it in those files, if named).
+func loadCertificate(cfg Config) (tls.Certificate, error) {
+	if !cfg.TLSSelfSigned {
+		return tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	}
+	if cfg.TLSCert != "" {
+		if cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err == nil {
+			return cert, nil
+		} else if !os.IsNotExist(err) {
+			return tls.Certificate{}, err
+		}
+	}
+
+	certPEM, keyPEM, err := selfSigned(time.Now())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if cfg.TLSCert != "" {
+		if err := os.WriteFile(cfg.TLSCert, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(cfg.TLSKey, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		slog.Info("made a self-signed certificate", "cert", cfg.TLSCert)
+	} else {
+		slog.Info("made a self-signed certificate; it lasts until the server stops")
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// selfSigned makes a PEM certificate and key for localhost and this
+// machine, valid from now.
+func selfSigned(now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Tasks development"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour), // for clocks a little behind
+		NotAfter:              now.Add(selfSignedFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// hsts asks browsers to use only HTTPS for the next maxAge seconds.
+func hsts(maxAge int) func(http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", maxAge)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// redirectHTTPS sends every request to the same page over HTTPS on the
+// port of addr. Nothing is served, so nothing is sent back in plaintext.
+func redirectHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host // no port
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		u := *r.URL
+		u.Scheme, u.Host = "https", host
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect // keep the method
+		}
+		http.Redirect(w, r, u.String(), status)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{TLSSelfSigned: true, TLSCert: filepath.Join(dir, "dev.crt"), TLSKey: filepath.Join(dir, "dev.key")}
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(cfg.TLSKey); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file: %v, %v", info, err)
+	}
+
+	// the files are used again rather than making a new one each start
+	again, err := loadCertificate(cfg)
+	if err != nil || !bytes.Equal(again.Certificate[0], cert.Certificate[0]) {
+		t.Errorf("second start made a new certificate: %v", err)
+	}
+
+	// ...and without them, one is kept in memory
+	cfg.TLSCert, cfg.TLSKey = "", ""
+	if cert, err := loadCertificate(cfg); err != nil || len(cert.Certificate) != 1 {
+		t.Errorf("in memory: %v", err)
+	}
+
+	if _, err := loadCertificate(Config{TLSCert: filepath.Join(dir, "missing.crt"), TLSKey: cfg.TLSKey}); err == nil {
+		t.Error("loaded a missing certificate")
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t).(*router)
+		t.Cleanup(func() { secureCookies = false })
+		srv, err := newServer(Config{TLSSelfSigned: true, HSTSMaxAge: 60}, mux)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.ServeTLS(ln, "", "")
+		defer srv.Close()
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // it's self-signed
+				ForceAttemptHTTP2: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Timeout:       5 * time.Second,
+		}
+		form := url.Values{"first name": {"Andrea"}, "last name": {"Lam"}, "password": {demoPassword}}
+		resp, err := client.PostForm("https://"+ln.Addr().String()+"/login/", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusFound || resp.ProtoMajor != 2 {
+			t.Errorf("login: %d over %s", resp.StatusCode, resp.Proto)
+		}
+		if got := resp.Header.Get("Strict-Transport-Security"); got != "max-age=60" {
+			t.Errorf("Strict-Transport-Security = %q", got)
+		}
+		if cookies := resp.Cookies(); len(cookies) != 1 || !cookies[0].Secure {
+			t.Errorf("session cookie isn't Secure: %v", cookies)
+		}
+	})
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, c := range []struct {
+		addr, method, target string
+		status               int
+		to                   string
+	}{
+		{":8443", "GET", "http://localhost:8080/view/?sort=due", http.StatusMovedPermanently, "https://localhost:8443/view/?sort=due"},
+		{":443", "GET", "http://example.com/", http.StatusMovedPermanently, "https://example.com/"},
+		{"", "HEAD", "http://example.com/tags/", http.StatusMovedPermanently, "https://example.com/tags/"},
+		{":443", "POST", "http://example.com:80/login/", http.StatusPermanentRedirect, "https://example.com/login/"},
+	} {
+		w := httptest.NewRecorder()
+		redirectHTTPS(c.addr).ServeHTTP(w, httptest.NewRequest(c.method, c.target, strings.NewReader("password=secret")))
+		if w.Code != c.status || w.Header().Get("Location") != c.to {
+			t.Errorf("%s %s: %d to %q, want %d to %q", c.method, c.target, w.Code, w.Header().Get("Location"), c.status, c.to)
+		}
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s %s: echoed the request", c.method, c.target)
+		}
+	}
+}
@@ -0,0 +1,641 @@
+package main
+
+/*
+	## todo.txt
+	Tasks can also be read and written as todo.txt (see
+	https://github.com/todotxt/todo.txt), a line per task:
+
+		(A) 2020-03-30 Call mum +Luis's_List @phone due:2020-04-01 id:12
+		x 2020-04-02 2020-03-30 Buy flowers +Luis's_List pri:B id:13
+
+	| todo.txt          | task                                          |
+	| ----------------- | --------------------------------------------- |
+	| x 2020-04-02      | completed (the date is when it was last changed) |
+	| (A) (B) (C)       | high, medium and low priority; (D) to (Z) are low too |
+	| pri:A             | the same, for completed tasks                 |
+	| 2020-03-30        | when the task was added                       |
+	| +project          | the list, with _ for spaces (the last, if several) |
+	| @context          | a tag, with _ for spaces                      |
+	| due:2020-04-01    | the due date, or date and time (see due.go)   |
+	| id:12             | the task's ID, written by the app for syncing |
+
+	Everything else is the title. Lines without a +project go in the "Inbox"
+	list. Steps are written as tasks of their own, and details and repeat
+	rules have no place in todo.txt, so they're left as they are.
+
+	/export and /import (see export.go) read and write todo.txt alongside
+	their other formats, matching tasks by title. The sync command keeps a
+	todo.txt file and the database in step both ways:
+
+		tasks sync ~/todo.txt Luis Bosquez
+
+	It remembers the file as it was after the last sync (in todo.txt.base
+	beside it), so it can tell which side changed each task since. Changes
+	on one side are copied to the other; a task changed on both sides, or
+	changed on one and deleted on the other, is a conflict. Conflicts are
+	reported and settled by keeping the database's version, or the edited
+	task if the other side deleted it. The file is then rewritten from the
+	database, so new tasks get their id: and repeating tasks completed in
+	the file their next occurrence.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inbox is the list for todo.txt lines without a +project.
+const inbox = "Inbox"
+
+// todo.txt priorities, by the app's (see order.go)
+var todoPriority = map[int]string{
+	lowPriority:    "C",
+	mediumPriority: "B",
+	highPriority:   "A",
+}
+
+// todoItem is a line of a todo.txt file.
+type todoItem struct {
+	ID       uint // 0 until the task is in the database
+	Done     bool
+	Priority int
+	Finished string // YYYY-MM-DD, or ""
+	Created  string // YYYY-MM-DD, or ""
+	Project  string // the list's title as a +project, without the +
+	Title    string
+	Tags     []string // as @contexts, without the @
+	Due      string   // as parseDue reads it
+}
+
+// todoWord writes a list title or tag name as one todo.txt word.
+func todoWord(s string) string {
+	return strings.Join(strings.Fields(s), "_")
+}
+
+// todoPriorityOf reads a priority letter.
+func todoPriorityOf(letter string) (int, bool) {
+	if len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z' {
+		return noPriority, false
+	}
+	for p, l := range todoPriority {
+		if l == letter {
+			return p, true
+		}
+	}
+	return lowPriority, true
+}
+
+// isDate reports whether s is a YYYY-MM-DD date.
+func isDate(s string) bool {
+	_, err := time.Parse(dateFormat, s)
+	return err == nil
+}
+
+// parseTodo reads a line of todo.txt.
+func parseTodo(line string) (todoItem, error) {
+	var item todoItem
+	words := strings.Fields(line)
+	if len(words) > 0 && words[0] == "x" {
+		item.Done, words = true, words[1:]
+		if len(words) > 0 && isDate(words[0]) {
+			item.Finished, words = words[0], words[1:]
+		}
+	}
+	if len(words) > 0 && len(words[0]) == 3 && words[0][0] == '(' && words[0][2] == ')' {
+		if p, ok := todoPriorityOf(words[0][1:2]); ok {
+			item.Priority, words = p, words[1:]
+		}
+	}
+	if len(words) > 0 && isDate(words[0]) {
+		item.Created, words = words[0], words[1:]
+	}
+
+	var title []string
+	projectAt := 0 // where the +project was among the title's words
+	for _, w := range words {
+		key, value := "", ""
+		if i := strings.IndexByte(w, ':'); i > 0 {
+			key, value = w[:i], w[i+1:]
+		}
+		switch {
+		case len(w) > 1 && w[0] == '+':
+			// the last +project is the list; any before it are words
+			if item.Project != "" {
+				title = append(title[:projectAt], append([]string{"+" + item.Project}, title[projectAt:]...)...)
+			}
+			item.Project, projectAt = w[1:], len(title)
+		case len(w) > 1 && w[0] == '@':
+			item.Tags = append(item.Tags, w[1:])
+		case key == "due" && value != "":
+			if _, _, err := parseDue(value); err != nil {
+				return item, err
+			}
+			item.Due = value
+		case key == "id" && value != "":
+			id, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return item, badInput("id: must be a number")
+			}
+			item.ID = uint(id)
+		case key == "pri" && value != "":
+			if p, ok := todoPriorityOf(value); ok {
+				item.Priority = p
+				continue
+			}
+			title = append(title, w)
+		default:
+			title = append(title, w)
+		}
+	}
+	item.Title = strings.Join(title, " ")
+	if item.Title == "" {
+		return item, badInput("the task has no title")
+	}
+	return item, nil
+}
+
+// String writes the item as a line of todo.txt.
+func (item todoItem) String() string {
+	var words []string
+	if item.Done {
+		words = append(words, "x")
+		if item.Finished != "" {
+			words = append(words, item.Finished)
+		}
+	} else if p, ok := todoPriority[item.Priority]; ok {
+		words = append(words, "("+p+")")
+	}
+	if item.Created != "" {
+		words = append(words, item.Created)
+	}
+	words = append(words, item.Title)
+	if item.Project != "" {
+		words = append(words, "+"+item.Project)
+	}
+	for _, tag := range item.Tags {
+		words = append(words, "@"+tag)
+	}
+	if item.Due != "" {
+		words = append(words, "due:"+item.Due)
+	}
+	if p, ok := todoPriority[item.Priority]; ok && item.Done {
+		words = append(words, "pri:"+p)
+	}
+	if item.ID != 0 {
+		words = append(words, fmt.Sprint("id:", item.ID))
+	}
+	return strings.Join(words, " ")
+}
+
+// same reports whether two items say the same about a task. Dates aren't
+// compared, since the app doesn't keep them.
+func (item todoItem) same(other todoItem) bool {
+	tags := func(t []string) string {
+		s := append([]string{}, t...)
+		sort.Strings(s)
+		return strings.Join(s, " ")
+	}
+	return item.Done == other.Done && item.Priority == other.Priority &&
+		item.Project == other.Project && item.Title == other.Title &&
+		tags(item.Tags) == tags(other.Tags) && item.Due == other.Due
+}
+
+// readTodo reads a todo.txt file, skipping blank lines.
+func readTodo(r io.Reader) ([]todoItem, error) {
+	var items []todoItem
+	lines := bufio.NewScanner(r)
+	for n := 1; lines.Scan(); n++ {
+		if strings.TrimSpace(lines.Text()) == "" {
+			continue
+		}
+		item, err := parseTodo(lines.Text())
+		if err != nil {
+			return nil, badInput(fmt.Sprintf("line %d: %s", n, err))
+		}
+		items = append(items, item)
+	}
+	return items, lines.Err()
+}
+
+// writeTodo writes items as a todo.txt file.
+func writeTodo(w io.Writer, items []todoItem) error {
+	for _, item := range items {
+		if _, err := fmt.Fprintln(w, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// todoItems writes the tasks in lists as todo.txt items, with the user's
+// tags.
+func todoItems(user User, lists []TaskList) ([]todoItem, error) {
+	var items []todoItem
+	for _, list := range lists {
+		tasks, err := store.Tasks(list.ID)
+		if err == nil {
+			err = loadTags(user, tasks)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			item := todoItem{
+				ID:       t.ID,
+				Done:     t.Completed,
+				Priority: t.Priority,
+				Created:  t.CreatedAt.Format(dateFormat),
+				Project:  todoWord(list.Title),
+				Title:    t.Title,
+				Due:      t.DueString(),
+			}
+			if t.Completed {
+				item.Finished = t.UpdatedAt.Format(dateFormat)
+			}
+			for _, tag := range t.Tags {
+				item.Tags = append(item.Tags, todoWord(tag.Name))
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// todoNames turns todo.txt words back into the titles or names they came
+// from: known ones as they are, new ones with spaces for _.
+func todoNames(known []string) func(word string) string {
+	names := map[string]string{}
+	for _, name := range known {
+		names[todoWord(name)] = name
+	}
+	return func(word string) string {
+		if word == "" {
+			return inbox
+		}
+		if name, ok := names[word]; ok {
+			return name
+		}
+		return strings.Replace(word, "_", " ", -1)
+	}
+}
+
+// todoTagNames finds the user's tag names for an item's @contexts.
+func todoTagNames(user User, item todoItem) ([]string, error) {
+	tags, err := store.Tags(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var known []string
+	for _, t := range tags {
+		known = append(known, t.Name)
+	}
+	name := todoNames(known)
+	var names []string
+	for _, w := range item.Tags {
+		names = append(names, name(w))
+	}
+	return parseTagNames(strings.Join(names, ","))
+}
+
+// todoFile turns todo.txt items into lists of tasks for importLists. The
+// file is partial, so tasks keep their details, repeat rules and parents.
+func todoFile(user User, items []todoItem) (exportFile, error) {
+	file := exportFile{Version: exportVersion, partial: true}
+	lists, err := store.Lists(user.ID)
+	if err != nil {
+		return file, err
+	}
+	var titles []string
+	for _, l := range lists {
+		titles = append(titles, l.Title)
+	}
+	listTitle := todoNames(titles)
+
+	index := map[string]int{}
+	for _, item := range items {
+		title := listTitle(item.Project)
+		i, ok := index[title]
+		if !ok {
+			i = len(file.Lists)
+			index[title] = i
+			file.Lists = append(file.Lists, exportList{Title: title})
+		}
+		tags, err := todoTagNames(user, item)
+		if err != nil {
+			return file, err
+		}
+		file.Lists[i].Tasks = append(file.Lists[i].Tasks, exportTask{
+			Title:     item.Title,
+			DueDate:   item.Due,
+			Completed: item.Done,
+			Priority:  priorityNames[item.Priority],
+			Tags:      tags,
+		})
+	}
+	return file, nil
+}
+
+// todoReport is what a sync did.
+type todoReport struct {
+	Created, Updated, Deleted int
+	Conflicts                 []string
+}
+
+// syncTodo brings a todo.txt file and the user's tasks in step, using the
+// file as it was after the last sync (base, nil before the first) to tell
+// which side changed what. It returns the new file.
+func syncTodo(user User, base, file []todoItem) ([]todoItem, todoReport, error) {
+	var report todoReport
+	conflict := func(format string, args ...interface{}) {
+		report.Conflicts = append(report.Conflicts, fmt.Sprintf(format, args...))
+	}
+
+	lists, err := userLists(user)
+	if err != nil {
+		return nil, report, err
+	}
+	db, err := todoItems(user, lists)
+	if err != nil {
+		return nil, report, err
+	}
+	inBase, inDB, inFile := map[uint]todoItem{}, map[uint]todoItem{}, map[uint]todoItem{}
+	byTitle := map[string]uint{}
+	for _, item := range base {
+		inBase[item.ID] = item
+	}
+	for _, item := range db {
+		inDB[item.ID] = item
+		byTitle[item.Project+"\n"+item.Title] = item.ID
+	}
+
+	// lines without an id are matched to tasks by list and title, or new
+	var added []todoItem
+	for _, item := range file {
+		if item.Project == "" {
+			item.Project = inbox
+		}
+		if item.ID == 0 {
+			item.ID = byTitle[item.Project+"\n"+item.Title]
+		}
+		if _, dup := inFile[item.ID]; dup && item.ID != 0 {
+			conflict("%q appears twice in the file; kept the first", item.Title)
+			continue
+		}
+		if item.ID == 0 {
+			added = append(added, item)
+			continue
+		}
+		inFile[item.ID] = item
+	}
+
+	// changes the user can't make are conflicts too; other errors stop
+	apply := func(what string, err error, item todoItem) (bool, error) {
+		_, bad := err.(badInput)
+		if bad || err == ErrNotFound || err == errForbidden {
+			conflict("%q couldn't be %s: %s", item.Title, what, err)
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	var ids []uint
+	for _, m := range []map[uint]todoItem{inBase, inDB, inFile} {
+		for id := range m {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		b, wasSynced := inBase[id]
+		d, stillInDB := inDB[id]
+		f, stillInFile := inFile[id]
+		var ok bool
+		var err error
+		switch {
+		case stillInFile && stillInDB:
+			switch {
+			case f.same(d), wasSynced && f.same(b):
+				// nothing new in the file
+			case wasSynced && d.same(b):
+				if ok, err = apply("updated", updateFromTodo(user, d, f), f); ok {
+					report.Updated++
+				}
+			default:
+				conflict("%q changed in both the file and the database; kept the database's", d.Title)
+			}
+		case stillInFile:
+			if wasSynced && f.same(b) {
+				break // deleted from the database
+			}
+			if wasSynced {
+				conflict("%q was deleted from the database but changed in the file; added it again", f.Title)
+			}
+			added = append(added, f)
+		case stillInDB:
+			switch {
+			case !wasSynced:
+				// new in the database
+			case d.same(b):
+				if ok, err = apply("deleted", deleteFromTodo(user, d), d); ok {
+					report.Deleted++
+				}
+			default:
+				conflict("%q was deleted from the file but changed in the database; kept it", d.Title)
+			}
+		}
+		if err != nil {
+			return nil, report, err
+		}
+	}
+	for _, item := range added {
+		ok, err := apply("added", createFromTodo(user, item), item)
+		if err != nil {
+			return nil, report, err
+		}
+		if ok {
+			report.Created++
+		}
+	}
+
+	if lists, err = userLists(user); err != nil {
+		return nil, report, err
+	}
+	items, err := todoItems(user, lists)
+	return items, report, err
+}
+
+// updateFromTodo changes a task to match its line in the file.
+func updateFromTodo(user User, was, item todoItem) error {
+	if was.Project != item.Project {
+		return badInput("tasks can't move between lists")
+	}
+	task, err := userTask(user, item.ID, writeAccess)
+	if err != nil {
+		return err
+	}
+	if item.Title != task.Title {
+		renamed := task
+		renamed.Title = item.Title
+		if err := checkTaskTitle(renamed); err != nil {
+			return err
+		}
+	}
+	tags, err := todoTagNames(user, item)
+	if err != nil {
+		return err
+	}
+	task.Title, task.Priority = item.Title, item.Priority
+	if err = task.SetDue(item.Due); err == nil {
+		err = task.Validate()
+	}
+	if err != nil {
+		return err
+	}
+
+	if item.Done != task.Completed {
+		_, err = setCompleted(&task, item.Done, time.Now())
+	} else {
+		err = store.SaveTask(&task)
+	}
+	if err == nil {
+		err = tagTask(user, &task, tags)
+	}
+	return err
+}
+
+// deleteFromTodo deletes a task removed from the file.
+func deleteFromTodo(user User, item todoItem) error {
+	task, err := userTask(user, item.ID, writeAccess)
+	if err == nil {
+		err = deleteTask(task)
+	}
+	return err
+}
+
+// createFromTodo adds a task for a new line in the file, making its list
+// if the user has none by that name.
+func createFromTodo(user User, item todoItem) error {
+	lists, err := userLists(user)
+	if err != nil {
+		return err
+	}
+	var titles []string
+	for _, l := range lists {
+		titles = append(titles, l.Title)
+	}
+	title := todoNames(titles)(item.Project)
+	list, err := userListByTitle(user, title, writeAccess)
+	if err == ErrNotFound {
+		list = TaskList{Title: title, UserID: user.ID}
+		if err = list.Validate(); err == nil {
+			err = store.CreateList(&list)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	task := Task{Title: item.Title, Priority: item.Priority, Completed: item.Done, TaskListID: list.ID}
+	if err = task.SetDue(item.Due); err == nil {
+		err = task.Validate()
+	}
+	if err == nil {
+		err = checkTaskTitle(task)
+	}
+	tags, terr := todoTagNames(user, item)
+	if err == nil {
+		err = terr
+	}
+	if err == nil {
+		err = store.CreateTask(&task)
+	}
+	if err == nil {
+		err = tagTask(user, &task, tags)
+	}
+	return err
+}
+
+// readTodoFile reads a todo.txt file, or nothing if there isn't one.
+func readTodoFile(path string) ([]todoItem, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTodo(f)
+}
+
+// writeTodoFile replaces a todo.txt file, all at once.
+func writeTodoFile(path string, items []todoItem) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".todo-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = writeTodo(tmp, items); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// syncTodoCommand syncs the todo.txt file in args[0] with the tasks of
+// the user named in args[1:].
+func syncTodoCommand(args []string) {
+	if len(args) != 3 {
+		log.Fatal("Usage: tasks [flags] sync file first-name last-name")
+	}
+	path := args[0]
+
+	var err error
+	if store, err = NewStore(config); err != nil {
+		fatal("failed to open store", err)
+	}
+	defer store.Close()
+	user, err := store.FindUser(args[1], args[2])
+	if err != nil {
+		fatal("failed to find "+args[1]+" "+args[2], err)
+	}
+
+	base, err := readTodoFile(path + ".base")
+	if err != nil {
+		fatal("failed to read the last sync", err)
+	}
+	file, err := readTodoFile(path)
+	if err != nil {
+		fatal("failed to read "+path, err)
+	}
+	items, report, err := syncTodo(user, base, file)
+	if err == nil {
+		err = writeTodoFile(path, items)
+	}
+	if err == nil {
+		err = writeTodoFile(path+".base", items)
+	}
+	if err != nil {
+		fatal("failed to sync", err)
+	}
+
+	fmt.Printf("Synced %s: %d added, %d updated, %d deleted in the database\n", path, report.Created, report.Updated, report.Deleted)
+	for _, c := range report.Conflicts {
+		fmt.Println("Conflict:", c)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTodo(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // as String writes it back
+	}{
+		{"Call mum", "Call mum"},
+		{"(A) 2020-03-30 Call mum @phone +Family due:2020-04-01 id:12", "(A) 2020-03-30 Call mum +Family @phone due:2020-04-01 id:12"},
+		{"x 2020-04-02 2020-03-30 Buy +Shop flowers +Other pri:B", "x 2020-04-02 2020-03-30 Buy +Shop flowers +Other pri:B"},
+		{"(Q) see http://example.com due:2020-04-01T15:30", "(C) see http://example.com due:2020-04-01T15:30"},
+		{"x (A) done", "x done pri:A"},
+	}
+	for _, test := range tests {
+		item, err := parseTodo(test.line)
+		if got := item.String(); err != nil || got != test.want {
+			t.Errorf("parseTodo(%q) = %q, %v; want %q", test.line, got, err, test.want)
+		}
+	}
+	for _, bad := range []string{"x", "+List @tag", "Call due:someday", "Call id:twelve"} {
+		if _, err := parseTodo(bad); err == nil {
+			t.Errorf("parseTodo(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSyncTodo(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		testServer(t)
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		read := func(s string) []todoItem {
+			items, err := readTodo(strings.NewReader(s))
+			if err != nil {
+				t.Fatal("readTodo: ", err)
+			}
+			return items
+		}
+		find := func(items []todoItem, title string) *todoItem {
+			for i := range items {
+				if items[i].Title == title {
+					return &items[i]
+				}
+			}
+			return nil
+		}
+
+		// the first sync adds new lines and keeps the database where they differ
+		items, report, err := syncTodo(user, nil, read("(A) Call mum +Luis's_Other_List @phone\nWatch TV +Luis's_List due:2020-01-01\n"))
+		if err != nil || report.Created != 1 || len(report.Conflicts) != 1 || len(items) != 3 {
+			t.Fatalf("first sync = %v, %+v, %v", items, report, err)
+		}
+		if tv := find(items, "Watch TV"); tv == nil || tv.Due != "2017-03-30" || tv.ID == 0 {
+			t.Errorf("Watch TV = %+v", tv)
+		}
+		if mum := find(items, "Call mum"); mum == nil || mum.Priority != highPriority || strings.Join(mum.Tags, " ") != "phone" {
+			t.Errorf("Call mum = %+v", mum)
+		}
+
+		// then each side's changes go to the other
+		base := items
+		file := read(fmt.Sprint(items[0], "\n", items[1], "\nFold towels +Luis's_List\n", items[2], "\n"))
+		file[3].Done = true // Call mum, done in the file
+		file = file[1:]     // Do more laundry, deleted from the file
+		tv, _ := store.FindTask(list.ID, "Watch TV")
+		tv.Priority = lowPriority // and Watch TV changed in the database
+		store.SaveTask(&tv)
+		items, report, err = syncTodo(user, base, file)
+		if err != nil || fmt.Sprint(report) != fmt.Sprint(todoReport{Created: 1, Updated: 1, Deleted: 1}) {
+			t.Fatalf("second sync = %+v, %v", report, err)
+		}
+		if _, err := store.FindTask(list.ID, "Do more laundry"); err != ErrNotFound {
+			t.Errorf("Do more laundry wasn't deleted: %v", err)
+		}
+		if find(items, "Fold towels") == nil || !find(items, "Call mum").Done || find(items, "Watch TV").Priority != lowPriority {
+			t.Errorf("after the second sync: %v", items)
+		}
+
+		// changes to both sides conflict, and the database wins
+		base = items
+		file = read(fmt.Sprint(items[0], "\n", items[1], "\n")) // without Call mum
+		file[0].Title = "Watch a film"
+		tv.Title = "Watch the news"
+		store.SaveTask(&tv)
+		other, _ := store.FindList(user.ID, "Luis's Other List")
+		mum, _ := store.FindTask(other.ID, "Call mum")
+		mum.SetDue("2020-05-01")
+		store.SaveTask(&mum)
+		items, report, err = syncTodo(user, base, file)
+		if err != nil || len(report.Conflicts) != 2 || find(items, "Watch the news") == nil || find(items, "Call mum") == nil {
+			t.Errorf("conflicting sync = %v, %+v, %v", items, report, err)
+		}
+
+		// a line edited only in the file renames its task
+		base = items
+		file = read(fmt.Sprint(items[0], "\n", items[1], "\n", items[2], "\n"))
+		towels := find(file, "Fold towels")
+		if towels == nil {
+			t.Fatalf("no Fold towels in %v", file)
+		}
+		towels.Title = "Fold shirts"
+		items, report, err = syncTodo(user, base, file)
+		if err != nil || report.Updated != 1 {
+			t.Fatalf("renaming sync = %+v, %v", report, err)
+		}
+		if _, err := store.FindTask(list.ID, "Fold shirts"); err != nil || find(items, "Fold shirts") == nil {
+			t.Errorf("Fold towels wasn't renamed: %v", err)
+		}
+	})
+}
+
+func TestTodoImportExport(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		c := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{loginAs(t, "Luis", "Bosquez")}}
+		user, _ := store.FindUser("Luis", "Bosquez")
+		list, _ := store.FindList(user.ID, "Luis's List")
+		tv, _ := store.FindTask(list.ID, "Watch TV")
+		tv.Details = "the news"
+		store.SaveTask(&tv)
+
+		w := c.call("GET", fmt.Sprintf("/api/v1/users/%d/export?format=todotxt", user.ID), "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), " Watch TV +Luis's_List due:2017-03-30 id:") {
+			t.Fatalf("export: %d %s", w.Code, w.Body)
+		}
+
+		w = c.call("POST", fmt.Sprintf("/api/v1/users/%d/import?format=todotxt", user.ID), "x (B) Watch TV +Luis's_List @evening\nNew thing +Luis's_Other_List\n")
+		if w.Code != http.StatusOK {
+			t.Fatalf("import: %d %s", w.Code, w.Body)
+		}
+		tv, _ = store.GetTask(tv.ID)
+		if !tv.Completed || tv.Priority != mediumPriority || tv.DueDate != nil || tv.Details != "the news" {
+			t.Errorf("Watch TV after import = %+v", tv)
+		}
+		other, _ := store.FindList(user.ID, "Luis's Other List")
+		if _, err := store.FindTask(other.ID, "New thing"); err != nil {
+			t.Errorf("New thing: %v", err)
+		}
+	})
+}
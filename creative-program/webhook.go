@@ -0,0 +1,446 @@
+package main
+
+/*
+	## Webhooks
+	Users can have changes to their lists POSTed to URLs of their own, to
+	set off other automation. Webhooks are set up on the /webhooks page (or
+	through the API), each for every event or only some:
+
+	| event          | when                                          |
+	| -------------- | --------------------------------------------- |
+	| task.created   | a task or step is added                       |
+	| task.updated   | a task is edited or moved                     |
+	| task.completed | a task is marked complete                     |
+	| task.reopened  | a complete task is marked incomplete          |
+	| task.deleted   | a task is deleted (its steps go with it)      |
+	| list.created   | a list is added                               |
+	| list.updated   | a list is renamed or moved                    |
+	| list.deleted   | a list is deleted (its tasks go with it)      |
+
+	Events are sent to the webhooks of everyone in the list, owner and
+	members alike, whoever made the change. Imports and todo.txt syncs
+	don't send them.
+
+	Each delivery is a JSON body like
+
+		{"event": "task.completed", "delivery": 12, "sent_at": "...",
+		 "user": {"id": 1, "first_name": "Andrea", "last_name": "Lam"},
+		 "list": {...}, "task": {...}}
+
+	with the list and task as in the API, and these headers:
+
+	| header            | holds                                           |
+	| ----------------- | ----------------------------------------------- |
+	| X-Tasks-Event     | the event                                       |
+	| X-Tasks-Delivery  | the delivery's ID, the same on every retry      |
+	| X-Tasks-Signature | sha256= and the hex HMAC-SHA256 of the body,    |
+	|                   | keyed with the webhook's secret                 |
+
+	Receivers should check the signature (with a constant time compare)
+	before trusting a delivery. Any 2xx answer counts as delivered. Anything
+	else, or no answer within 10 seconds, is tried again after 1 minute,
+	then 5, 30, 2 hours and 6 hours, before being given up on. Deliveries
+	are queued in the store, so retries survive restarts, and each
+	webhook's page keeps a log of them with the last answer.
+
+	The server POSTs to whatever URL it's given, including ones on its own
+	network, so only run it where users can be trusted with that.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the events webhooks can ask for
+var webhookEvents = []string{
+	"task.created", "task.updated", "task.completed", "task.reopened", "task.deleted",
+	"list.created", "list.updated", "list.deleted",
+}
+
+// how long to wait before each retry; deliveries are given up on after
+// the last
+var webhookBackoff = []time.Duration{
+	time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 6 * time.Hour,
+}
+
+const (
+	webhookTimeout = 10 * time.Second // for a receiver to answer
+	webhookPoll    = time.Minute      // how often retries are looked for
+	maxLoggedError = 255              // characters of a failure kept
+	deliveryLog    = 20               // deliveries shown per webhook
+)
+
+// webhookClient sends deliveries.
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookNudge wakes the sender when there's something new to send.
+var webhookNudge = make(chan struct{}, 1)
+
+// parseEvents checks the events a webhook asks for, given as a list. None
+// means all of them.
+func parseEvents(events []string) (string, error) {
+	asked := map[string]bool{}
+	for _, e := range events {
+		asked[strings.TrimSpace(e)] = true
+	}
+	var picked []string
+	for _, e := range webhookEvents {
+		if asked[e] {
+			picked = append(picked, e)
+			delete(asked, e)
+		}
+	}
+	for e := range asked {
+		if e != "" {
+			return "", badInput(fmt.Sprintf("there's no event called %q", e))
+		}
+	}
+	if len(picked) == len(webhookEvents) {
+		return "", nil
+	}
+	return strings.Join(picked, ","), nil
+}
+
+// parseWebhookURL checks a webhook's URL.
+func parseWebhookURL(s string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", badInput("webhook URL must start with http:// or https://")
+	}
+	if len(u.String()) > 2048 {
+		return "", badInput("webhook URL is too long")
+	}
+	return u.String(), nil
+}
+
+// Wants reports whether the webhook asked for an event.
+func (h Webhook) Wants(event string) bool {
+	if h.Events == "" {
+		return true
+	}
+	for _, e := range strings.Split(h.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// EventList is the events the webhook asked for, for people.
+func (h Webhook) EventList() string {
+	if h.Events == "" {
+		return "all events"
+	}
+	return strings.Replace(h.Events, ",", ", ", -1)
+}
+
+// newWebhook makes a webhook for a user with a fresh secret.
+func newWebhook(user User, rawURL string, events []string) (Webhook, error) {
+	hook := Webhook{UserID: user.ID, Secret: newFeedToken(), Active: true}
+	var err error
+	if hook.URL, err = parseWebhookURL(rawURL); err != nil {
+		return Webhook{}, err
+	}
+	if hook.Events, err = parseEvents(events); err != nil {
+		return Webhook{}, err
+	}
+	return hook, store.CreateWebhook(&hook)
+}
+
+// signDelivery makes the signature header for a delivery's body.
+func signDelivery(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type (
+	// apiActor is who made a change, as deliveries show them
+	apiActor struct {
+		ID        uint   `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	}
+
+	// webhookEvent is the body of a delivery
+	webhookEvent struct {
+		Event    string    `json:"event"`
+		Delivery uint      `json:"delivery"`
+		SentAt   time.Time `json:"sent_at"`
+		User     apiActor  `json:"user"`
+		List     apiList   `json:"list"`
+		Task     *apiTask  `json:"task,omitempty"`
+	}
+
+	// pendingEvent is an event ready to queue for the webhooks that want it,
+	// and to pass on to the open pages of everyone in the list.
+	pendingEvent struct {
+		webhookEvent
+		hooks []Webhook
+		live  liveEvent
+		users []uint
+	}
+)
+
+// newEvent gets an event ready for the webhooks of everyone in the list,
+// finding them now, while the list's members are still there to find.
+// Problems are logged rather than returned: the change has been made, and
+// a webhook missing it shouldn't undo it.
+func newEvent(event string, user User, list TaskList, task *Task) pendingEvent {
+	e := pendingEvent{webhookEvent: webhookEvent{
+		Event: event,
+		User:  apiActor{ID: user.ID, FirstName: user.FirstName, LastName: user.LastName},
+		List:  listJSON(list),
+	}, live: liveEvent{Event: event, List: list.ID}}
+	if task != nil {
+		t := taskJSON(*task)
+		e.Task, e.live.Task = &t, task.ID
+	}
+	ids, err := listUsers(list)
+	if err != nil {
+		slog.Error("failed to find webhooks", "event", event, "list", list.ID, "err", err)
+		return e
+	}
+	e.users = ids
+	for _, id := range ids {
+		hooks, err := store.Webhooks(id)
+		if err != nil {
+			slog.Error("failed to find webhooks", "event", event, "user", id, "err", err)
+			continue
+		}
+		for _, h := range hooks {
+			if h.Active && h.Wants(event) {
+				e.hooks = append(e.hooks, h)
+			}
+		}
+	}
+	return e
+}
+
+// send queues the event for its webhooks and wakes the sender, and tells
+// open pages about it.
+func (e pendingEvent) send() {
+	live.publish(e.live, e.users...)
+	if len(e.hooks) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, h := range e.hooks {
+		// the body holds the delivery's ID, so it's only due once saved again
+		d := Delivery{WebhookID: h.ID, Event: e.Event}
+		err := store.CreateDelivery(&d)
+		if err == nil {
+			body := e.webhookEvent
+			body.Delivery, body.SentAt = d.ID, now
+			var payload []byte
+			payload, err = json.Marshal(body)
+			d.Payload, d.NextAttempt = string(payload), &now
+		}
+		if err == nil {
+			err = store.SaveDelivery(&d)
+		}
+		if err != nil {
+			slog.Error("failed to queue delivery", "event", e.Event, "webhook", h.ID, "err", err)
+		}
+	}
+	select {
+	case webhookNudge <- struct{}{}:
+	default: // the sender is already due to look
+	}
+}
+
+// emit sends an event about a change to the webhooks that want it.
+func emit(event string, user User, list TaskList, task *Task) {
+	newEvent(event, user, list, task).send()
+}
+
+// emitTask sends an event about a change to a task.
+func emitTask(event string, user User, task Task) {
+	list, err := store.GetList(task.TaskListID)
+	if err != nil {
+		slog.Error("failed to find the list for an event", "event", event, "task", task.ID, "err", err)
+		return
+	}
+	emit(event, user, list, &task)
+}
+
+// completedEvent is the event for marking a task complete or not.
+func completedEvent(task Task) string {
+	if task.Completed {
+		return "task.completed"
+	}
+	return "task.reopened"
+}
+
+// deliver tries to send a delivery once, recording how it went.
+func deliver(d *Delivery, now time.Time) error {
+	hook, err := store.GetWebhook(d.WebhookID)
+	if err != nil {
+		return err
+	}
+	d.Attempts++
+	d.Status, d.Error = 0, ""
+	if hook.Active {
+		d.Status, err = post(hook, *d)
+	} else {
+		err = fmt.Errorf("webhook is turned off")
+	}
+
+	switch {
+	case err == nil:
+		d.Delivered, d.NextAttempt = true, nil
+	case d.Attempts > len(webhookBackoff):
+		d.NextAttempt = nil // given up on
+	default:
+		next := now.Add(webhookBackoff[d.Attempts-1])
+		d.NextAttempt = &next
+	}
+	if err != nil {
+		if d.Error = err.Error(); len(d.Error) > maxLoggedError {
+			d.Error = d.Error[:maxLoggedError]
+		}
+	}
+	return store.SaveDelivery(d)
+}
+
+// post POSTs a delivery to its webhook, returning the status it answered.
+func post(hook Webhook, d Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cs372-tasks-webhooks")
+	req.Header.Set("X-Tasks-Event", d.Event)
+	req.Header.Set("X-Tasks-Delivery", strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set("X-Tasks-Signature", signDelivery(hook.Secret, []byte(d.Payload)))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// sendDeliveries tries every delivery due by now.
+func sendDeliveries(now time.Time) error {
+	deliveries, err := store.PendingDeliveries(now)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		if err := deliver(&d, now); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// runWebhooks sends deliveries as they're queued and retries failed ones,
+// forever.
+func runWebhooks() {
+	for {
+		if err := sendDeliveries(time.Now()); err != nil {
+			slog.Error("failed to send webhooks", "err", err)
+		}
+		select {
+		case <-webhookNudge:
+		case <-time.After(webhookPoll):
+		}
+	}
+}
+
+type (
+	// webhookRow is a webhook and its recent deliveries, as webhooks.html
+	// shows them.
+	webhookRow struct {
+		Webhook
+		Deliveries []Delivery
+	}
+
+	// webhooksPage is what webhooks.html shows.
+	webhooksPage struct {
+		Error    string
+		Events   []string
+		Webhooks []webhookRow
+		CSRF     string // the session's token, for the forms (see csrf.go)
+	}
+)
+
+// renderWebhooks shows the user's webhooks.
+func renderWebhooks(w http.ResponseWriter, r *http.Request, user User, status int, msg string) {
+	hooks, err := store.Webhooks(user.ID)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	page := webhooksPage{Error: msg, Events: webhookEvents, CSRF: pageToken(r)}
+	for _, h := range hooks {
+		deliveries, err := store.Deliveries(h.ID)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		if len(deliveries) > deliveryLog {
+			deliveries = deliveries[:deliveryLog]
+		}
+		page.Webhooks = append(page.Webhooks, webhookRow{h, deliveries})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, "webhooks.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// webhooksHandler Shows the user's webhooks and their deliveries (GET
+// /webhooks/), or adds one (POST with "url" and any "event"s), or turns
+// one on or off or deletes it (POST with "webhook" set to its ID and
+// "active" or "delete").
+// Redirects user to the updated webhooks.
+func webhooksHandler(w http.ResponseWriter, r *http.Request, user User) {
+	if r.Method != http.MethodPost {
+		renderWebhooks(w, r, user, http.StatusOK, "")
+		return
+	}
+
+	r.ParseForm()
+	var err error
+	if id := r.FormValue("webhook"); id != "" {
+		var hook Webhook
+		n, _ := strconv.ParseUint(id, 10, 0)
+		if hook, err = userWebhook(user, uint(n)); err == nil {
+			if r.FormValue("delete") != "" {
+				err = store.DeleteWebhook(hook)
+			} else {
+				hook.Active = r.FormValue("active") == "on"
+				err = store.SaveWebhook(&hook)
+			}
+		}
+	} else {
+		_, err = newWebhook(user, r.FormValue("url"), r.Form["event"])
+	}
+	if _, ok := err.(badInput); ok {
+		renderWebhooks(w, r, user, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/webhooks/", http.StatusFound)
+}
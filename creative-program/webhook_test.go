@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookReceiver is a webhook's URL that keeps what it's sent.
+type hookReceiver struct {
+	*httptest.Server
+	mu     sync.Mutex
+	status int // to answer with
+	got    []*http.Request
+	bodies []string
+}
+
+func newHookReceiver(t *testing.T) *hookReceiver {
+	h := &hookReceiver{status: http.StatusOK}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.got = append(h.got, r)
+		h.bodies = append(h.bodies, string(body))
+		w.WriteHeader(h.status)
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+// events returns the events received so far and forgets them.
+func (h *hookReceiver) events() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var events []string
+	for _, r := range h.got {
+		events = append(events, r.Header.Get("X-Tasks-Event"))
+	}
+	h.got, h.bodies = nil, nil
+	return events
+}
+
+func TestStoreWebhooks(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			hook := Webhook{UserID: 1, URL: "http://example.com/", Secret: "s", Active: true}
+			if err := s.CreateWebhook(&hook); err != nil {
+				t.Fatal("CreateWebhook: ", err)
+			}
+			s.CreateWebhook(&Webhook{UserID: 2, URL: "http://example.com/2"})
+			if hooks, _ := s.Webhooks(1); len(hooks) != 1 || hooks[0].ID != hook.ID {
+				t.Errorf("Webhooks = %v", hooks)
+			}
+
+			now := time.Now().Round(time.Second)
+			later := now.Add(time.Hour)
+			first, second := Delivery{WebhookID: hook.ID, Event: "task.created", NextAttempt: &now}, Delivery{WebhookID: hook.ID, Event: "task.deleted", NextAttempt: &later}
+			s.CreateDelivery(&first)
+			s.CreateDelivery(&second)
+			s.CreateDelivery(&Delivery{WebhookID: hook.ID, Event: "list.created", Delivered: true})
+			if pending, _ := s.PendingDeliveries(now); len(pending) != 1 || pending[0].ID != first.ID {
+				t.Errorf("PendingDeliveries = %v", pending)
+			}
+			if all, _ := s.Deliveries(hook.ID); len(all) != 3 || all[0].Event != "list.created" {
+				t.Errorf("Deliveries = %v", all)
+			}
+			first.NextAttempt, first.Delivered = nil, true
+			s.SaveDelivery(&first)
+			if pending, _ := s.PendingDeliveries(later); len(pending) != 1 || pending[0].ID != second.ID {
+				t.Errorf("PendingDeliveries after saving = %v", pending)
+			}
+
+			if err := s.DeleteWebhook(hook); err != nil {
+				t.Fatal("DeleteWebhook: ", err)
+			}
+			if _, err := s.GetWebhook(hook.ID); err != ErrNotFound {
+				t.Errorf("GetWebhook after delete: %v", err)
+			}
+			if all, _ := s.Deliveries(hook.ID); len(all) != 0 {
+				t.Errorf("DeleteWebhook left deliveries %v", all)
+			}
+		})
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		andrea, luis := loginAs(t, "Andrea", "Lam"), loginAs(t, "Luis", "Bosquez")
+		send := func(c *http.Cookie, method, path string, form url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, (&url.URL{Path: path}).String(), strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			withSession(r, c)
+			mux.ServeHTTP(w, r)
+			return w
+		}
+		mine, his := newHookReceiver(t), newHookReceiver(t)
+
+		for _, bad := range []url.Values{{"url": {"ftp://example.com/"}}, {"url": {mine.URL}, "event": {"task.exploded"}}} {
+			if w := send(andrea, "POST", "/webhooks/", bad); w.Code != http.StatusBadRequest {
+				t.Errorf("add %v: %d", bad, w.Code)
+			}
+		}
+		if w := send(andrea, "POST", "/webhooks/", url.Values{"url": {mine.URL}}); w.Code != http.StatusFound {
+			t.Fatalf("add webhook: %d %s", w.Code, w.Body)
+		}
+		send(luis, "POST", "/webhooks/", url.Values{"url": {his.URL}, "event": {"task.completed", "list.deleted"}})
+		user, _ := store.FindUser("Andrea", "Lam")
+		hooks, _ := store.Webhooks(user.ID)
+		if len(hooks) != 1 || hooks[0].Events != "" || len(hooks[0].Secret) != 32 {
+			t.Fatalf("webhooks = %+v", hooks)
+		}
+
+		// Luis hears about Andrea's list once he's a member
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		luisUser, _ := store.FindUser("Luis", "Bosquez")
+		store.CreateMember(&Member{TaskListID: list.ID, UserID: luisUser.ID, Role: "editor", Accepted: true})
+
+		send(andrea, "POST", idPath(t, "add", "Andrea's list"), url.Values{"title": {"Fold laundry"}})
+		send(luis, "POST", idPath(t, "mark", "Andrea's list", "Fold laundry"), nil)
+		send(andrea, "POST", idPath(t, "mark", "Andrea's list", "Fold laundry"), nil)
+		send(andrea, "POST", idPath(t, "edit", "Andrea's list", "Fold laundry"), url.Values{"title": {"Fold the laundry"}})
+		send(andrea, "POST", idPath(t, "delete", "Andrea's list", "Fold the laundry"), nil)
+		send(andrea, "POST", "/add/", url.Values{"list title": {"Garden"}})
+		send(andrea, "POST", idPath(t, "delete", "Andrea's list"), nil)
+		if err := sendDeliveries(time.Now()); err != nil {
+			t.Fatal("sendDeliveries: ", err)
+		}
+
+		want := "task.created task.completed task.reopened task.updated task.deleted list.created list.deleted"
+		if got := strings.Join(mine.events(), " "); got != want {
+			t.Errorf("Andrea's webhook got %q, want %q", got, want)
+		}
+		if got := strings.Join(his.events(), " "); got != "task.completed list.deleted" {
+			t.Errorf("Luis's webhook got %q", got)
+		}
+
+		// deliveries are signed and say who did what
+		send(andrea, "POST", idPath(t, "add", "Garden"), url.Values{"title": {"Weed"}})
+		sendDeliveries(time.Now())
+		mine.mu.Lock()
+		r, body := mine.got[0], mine.bodies[0]
+		mine.mu.Unlock()
+		if sig := r.Header.Get("X-Tasks-Signature"); sig != signDelivery(hooks[0].Secret, []byte(body)) {
+			t.Errorf("signature %q for %s", sig, body)
+		}
+		var event webhookEvent
+		json.Unmarshal([]byte(body), &event)
+		if event.Event != "task.created" || event.User.FirstName != "Andrea" || event.List.Title != "Garden" || event.Task == nil || event.Task.Title != "Weed" || fmt.Sprint(event.Delivery) != r.Header.Get("X-Tasks-Delivery") {
+			t.Errorf("event = %+v", event)
+		}
+
+		// turned off webhooks hear nothing
+		send(andrea, "POST", "/webhooks/", url.Values{"webhook": {fmt.Sprint(hooks[0].ID)}, "active": {"off"}})
+		mine.events()
+		send(andrea, "POST", idPath(t, "add", "Garden"), url.Values{"title": {"Water"}})
+		sendDeliveries(time.Now())
+		if got := mine.events(); len(got) != 0 {
+			t.Errorf("turned off webhook got %v", got)
+		}
+		if body := send(andrea, "GET", "/webhooks/", nil).Body.String(); !strings.Contains(body, "(off)") || !strings.Contains(body, "delivered (200)") {
+			t.Errorf("webhooks page: %s", body)
+		}
+	})
+}
+
+func TestWebhookRetries(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		testServer(t)
+		rcv := newHookReceiver(t)
+		rcv.status = http.StatusServiceUnavailable
+		user, _ := store.FindUser("Meet", "Bhagdev")
+		hook, err := newWebhook(user, rcv.URL, []string{"task.deleted"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, _ := store.FindList(user.ID, "Meet's List")
+		task, _ := store.FindTask(list.ID, "Mow the lawn")
+		emit("task.created", user, list, &task) // not wanted
+		emit("task.deleted", user, list, &task)
+
+		now := time.Now()
+		sendDeliveries(now)
+		deliveries, _ := store.Deliveries(hook.ID)
+		if len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].Status != 503 || deliveries[0].NextAttempt == nil || !deliveries[0].NextAttempt.Equal(now.Add(time.Minute)) {
+			t.Fatalf("after one failure: %+v", deliveries)
+		}
+		// not again until the backoff is up, then every try
+		sendDeliveries(now.Add(30 * time.Second))
+		if got := rcv.events(); len(got) != 1 {
+			t.Errorf("tried %d times in the first minute", len(got))
+		}
+		at := now
+		for _, wait := range webhookBackoff {
+			at = at.Add(wait)
+			sendDeliveries(at)
+		}
+		d, _ := store.Deliveries(hook.ID)
+		if got := rcv.events(); len(got) != len(webhookBackoff) || d[0].Attempts != len(webhookBackoff)+1 || d[0].NextAttempt != nil || d[0].Delivered {
+			t.Errorf("after giving up: %d more tries, %+v", len(got), d[0])
+		}
+
+		// a webhook that comes back takes the next one
+		rcv.status = http.StatusNoContent
+		emit("task.deleted", user, list, &task)
+		sendDeliveries(time.Now())
+		if d, _ := store.Deliveries(hook.ID); !d[0].Delivered || d[0].Attempts != 1 || d[0].Error != "" {
+			t.Errorf("delivered: %+v", d[0])
+		}
+	})
+}
+
+func TestWebhooksAPI(t *testing.T) {
+	eachStore(t, func(t *testing.T) {
+		mux := testServer(t)
+		ac := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{loginAs(t, "Andrea", "Lam")}}
+		lc := &apiClient{t: t, mux: mux, cookies: []*http.Cookie{loginAs(t, "Luis", "Bosquez")}}
+		rcv := newHookReceiver(t)
+
+		w := ac.call("POST", "/api/v1/webhooks", fmt.Sprintf(`{"url": %q, "events": ["task.completed"]}`, rcv.URL))
+		var hook apiWebhook
+		json.NewDecoder(w.Body).Decode(&hook)
+		if w.Code != http.StatusCreated || hook.Secret == "" || strings.Join(hook.Events, ",") != "task.completed" {
+			t.Fatalf("add: %d %+v", w.Code, hook)
+		}
+		path := fmt.Sprintf("/api/v1/webhooks/%d", hook.ID)
+		if w := lc.call("GET", path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("someone else's webhook: %d", w.Code)
+		}
+		if w := ac.call("PATCH", path, `{"events": ["task.done"]}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bad events: %d", w.Code)
+		}
+		if w := ac.call("PATCH", path, `{"events": []}`); w.Code != http.StatusOK {
+			t.Errorf("all events: %d %s", w.Code, w.Body)
+		}
+
+		user, _ := store.FindUser("Andrea", "Lam")
+		list, _ := store.FindList(user.ID, "Andrea's list")
+		task, _ := store.FindTask(list.ID, "Do laundry")
+		ac.call("PATCH", fmt.Sprintf("/api/v1/tasks/%d", task.ID), `{"completed": true}`)
+		ac.call("POST", fmt.Sprintf("/api/v1/lists/%d/tasks", list.ID), `{"title": "Iron"}`)
+		sendDeliveries(time.Now())
+		// Do laundry repeats weekly, so completing it adds the next one
+		if got := strings.Join(rcv.events(), " "); got != "task.completed task.created task.created" {
+			t.Errorf("API events: %q", got)
+		}
+
+		w = ac.call("GET", path+"/deliveries", "")
+		var deliveries []apiDelivery
+		json.NewDecoder(w.Body).Decode(&deliveries)
+		if len(deliveries) != 3 || !deliveries[2].Delivered || !strings.Contains(string(deliveries[2].Payload), `"task.completed"`) {
+			t.Errorf("deliveries: %s", w.Body)
+		}
+		if w := ac.call("DELETE", path, ""); w.Code != http.StatusNoContent {
+			t.Errorf("delete: %d", w.Code)
+		}
+		if w := ac.call("GET", "/api/v1/webhooks", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+			t.Errorf("webhooks after delete: %s", w.Body)
+		}
+	})
+}